@@ -0,0 +1,453 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Attributes holds the custom per-user fields stored in the JSONB
+// attributes column
+type Attributes map[string]interface{}
+
+func (a Attributes) Value() (driver.Value, error) {
+	if a == nil {
+		return "{}", nil
+	}
+	data, err := json.Marshal(a)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+func (a *Attributes) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	case nil:
+		*a = Attributes{}
+		return nil
+	default:
+		return fmt.Errorf("cannot scan %T into attributes", src)
+	}
+
+	attrs := Attributes{}
+	if err := json.Unmarshal(data, &attrs); err != nil {
+		return fmt.Errorf("invalid attributes: %w", err)
+	}
+	*a = attrs
+	return nil
+}
+
+func (a Attributes) orEmpty() Attributes {
+	if a == nil {
+		return Attributes{}
+	}
+	return a
+}
+
+func sameAttributes(a, b Attributes) bool {
+	return reflect.DeepEqual(a.orEmpty(), b.orEmpty())
+}
+
+// AttributeSchema is the subset of JSON Schema that custom attributes are
+// validated against. Properties marked with x-token-claim are copied into
+// the user's tokens.
+type AttributeSchema struct {
+	Schema      string `json:"$schema,omitempty"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+
+	Type                 string                      `json:"type,omitempty"`
+	Properties           map[string]*AttributeSchema `json:"properties,omitempty"`
+	Required             []string                    `json:"required,omitempty"`
+	AdditionalProperties *bool                       `json:"additionalProperties,omitempty"`
+	Items                *AttributeSchema            `json:"items,omitempty"`
+	Enum                 []interface{}               `json:"enum,omitempty"`
+	MinLength            *int                        `json:"minLength,omitempty"`
+	MaxLength            *int                        `json:"maxLength,omitempty"`
+	Minimum              *float64                    `json:"minimum,omitempty"`
+	Maximum              *float64                    `json:"maximum,omitempty"`
+	Pattern              string                      `json:"pattern,omitempty"`
+	MaxItems             *int                        `json:"maxItems,omitempty"`
+
+	TokenClaim bool `json:"x-token-claim,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+var schemaTypes = map[string]bool{
+	"object": true, "array": true, "string": true,
+	"number": true, "integer": true, "boolean": true,
+}
+
+// attributeName restricts attribute names so they are safe to use as
+// query parameters and claim names
+var attributeName = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_]{0,62}$`)
+
+// ParseAttributeSchema parses and checks an attribute schema. Unknown
+// keywords are rejected rather than silently ignored.
+func ParseAttributeSchema(data []byte) (*AttributeSchema, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	schema := &AttributeSchema{}
+	if err := decoder.Decode(schema); err != nil {
+		return nil, fmt.Errorf("invalid attribute schema: %w", err)
+	}
+	if schema.Type != "object" {
+		return nil, fmt.Errorf("invalid attribute schema: top-level type must be object")
+	}
+	for name := range schema.Properties {
+		if !attributeName.MatchString(name) {
+			return nil, fmt.Errorf("invalid attribute schema: invalid attribute name %q", name)
+		}
+	}
+	if err := schema.compile("attributes"); err != nil {
+		return nil, fmt.Errorf("invalid attribute schema: %w", err)
+	}
+
+	return schema, nil
+}
+
+func (s *AttributeSchema) compile(path string) error {
+	if s.Type != "" && !schemaTypes[s.Type] {
+		return fmt.Errorf("%s: unsupported type %q", path, s.Type)
+	}
+
+	if s.Pattern != "" {
+		pattern, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("%s: invalid pattern: %w", path, err)
+		}
+		s.pattern = pattern
+	}
+
+	for _, name := range s.Required {
+		if _, ok := s.Properties[name]; !ok {
+			return fmt.Errorf("%s: required property %q is not defined", path, name)
+		}
+	}
+
+	for name, property := range s.Properties {
+		if property == nil {
+			return fmt.Errorf("%s.%s: schema must be an object", path, name)
+		}
+		if err := property.compile(path + "." + name); err != nil {
+			return err
+		}
+	}
+
+	if s.Items != nil {
+		return s.Items.compile(path + "[]")
+	}
+	return nil
+}
+
+// Validate checks a value against the schema and returns every violation
+func (s *AttributeSchema) Validate(value interface{}) []FieldError {
+	var errs []FieldError
+	s.validate("attributes", value, &errs)
+	sort.Slice(errs, func(i, j int) bool {
+		if errs[i].Field != errs[j].Field {
+			return errs[i].Field < errs[j].Field
+		}
+		return errs[i].Rule < errs[j].Rule
+	})
+	return errs
+}
+
+func (s *AttributeSchema) validate(path string, value interface{}, errs *[]FieldError) {
+	// Rules are named after the JSON Schema keyword that failed
+	fail := func(rule string, param interface{}, format string, args ...interface{}) {
+		*errs = append(*errs, FieldError{
+			Field:   path,
+			Rule:    rule,
+			Param:   fmt.Sprint(param),
+			Message: fmt.Sprintf(format, args...),
+		})
+	}
+
+	if s.Type != "" && !hasSchemaType(value, s.Type) {
+		fail("type", s.Type, "must be of type %s", s.Type)
+		return
+	}
+
+	if len(s.Enum) > 0 {
+		found := false
+		for _, allowed := range s.Enum {
+			if reflect.DeepEqual(value, allowed) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fail("enum", "", "must be one of the allowed values")
+		}
+	}
+
+	switch v := value.(type) {
+	case string:
+		length := len([]rune(v))
+		if s.MinLength != nil && length < *s.MinLength {
+			fail("minLength", *s.MinLength, "must be at least %d characters", *s.MinLength)
+		}
+		if s.MaxLength != nil && length > *s.MaxLength {
+			fail("maxLength", *s.MaxLength, "must be at most %d characters", *s.MaxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			fail("pattern", s.Pattern, "must match pattern %s", s.Pattern)
+		}
+	case float64:
+		if s.Minimum != nil && v < *s.Minimum {
+			fail("minimum", *s.Minimum, "must be at least %v", *s.Minimum)
+		}
+		if s.Maximum != nil && v > *s.Maximum {
+			fail("maximum", *s.Maximum, "must be at most %v", *s.Maximum)
+		}
+	case []interface{}:
+		if s.MaxItems != nil && len(v) > *s.MaxItems {
+			fail("maxItems", *s.MaxItems, "must have at most %d items", *s.MaxItems)
+		}
+		if s.Items != nil {
+			for i, item := range v {
+				s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item, errs)
+			}
+		}
+	case map[string]interface{}:
+		s.validateObject(path, v, errs)
+	case Attributes:
+		s.validateObject(path, v, errs)
+	}
+}
+
+func (s *AttributeSchema) validateObject(path string, obj map[string]interface{}, errs *[]FieldError) {
+	for _, name := range s.Required {
+		if _, ok := obj[name]; !ok {
+			*errs = append(*errs, FieldError{Field: path + "." + name, Rule: "required", Message: "is required"})
+		}
+	}
+
+	for name, value := range obj {
+		property, ok := s.Properties[name]
+		if !ok {
+			if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+				*errs = append(*errs, FieldError{Field: path + "." + name, Rule: "additionalProperties", Message: "is not allowed"})
+			}
+			continue
+		}
+		property.validate(path+"."+name, value, errs)
+	}
+}
+
+func hasSchemaType(value interface{}, schemaType string) bool {
+	switch schemaType {
+	case "object":
+		switch value.(type) {
+		case map[string]interface{}, Attributes:
+			return true
+		}
+		return false
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		v, ok := value.(float64)
+		return ok && v == math.Trunc(v)
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	}
+	return false
+}
+
+// ClaimAttributes returns the attributes the schema marks as token claims
+func (s *AttributeSchema) ClaimAttributes(attrs Attributes) Attributes {
+	claims := Attributes{}
+	for name, property := range s.Properties {
+		if value, ok := attrs[name]; ok && property.TokenClaim {
+			claims[name] = value
+		}
+	}
+	return claims
+}
+
+// filterValue converts a listing filter given as a string to the JSON type
+// of the attribute so it can be matched against stored values
+func (s *AttributeSchema) filterValue(name, value string) (interface{}, error) {
+	property, ok := s.Properties[name]
+	if !ok {
+		return nil, Validation("invalid_parameter", "unknown attribute %q", name)
+	}
+
+	switch property.Type {
+	case "number", "integer":
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, Validation("invalid_parameter", "attribute %s must be a number", name)
+		}
+		return n, nil
+	case "boolean":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, Validation("invalid_parameter", "attribute %s must be a boolean", name)
+		}
+		return b, nil
+	case "string", "":
+		return value, nil
+	}
+	return nil, Validation("invalid_parameter", "attribute %s cannot be used as a filter", name)
+}
+
+// GetAttributeSchema returns the current attribute schema, or nil if none
+// has been configured
+func (s *Service) GetAttributeSchema(ctx context.Context) (*AttributeSchema, error) {
+	data, err := s.repo.GetAttributeSchema(ctx)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	return ParseAttributeSchema(data)
+}
+
+// SetAttributeSchema replaces the attribute schema. Existing attribute
+// values are not revalidated; the schema applies to subsequent writes.
+func (s *Service) SetAttributeSchema(ctx context.Context, data []byte) (*AttributeSchema, error) {
+	schema, err := ParseAttributeSchema(data)
+	if err != nil {
+		return nil, Validation("invalid_attribute_schema", "%v", err)
+	}
+
+	if err := s.repo.SaveAttributeSchema(ctx, data); err != nil {
+		return nil, err
+	}
+
+	return schema, nil
+}
+
+// validateAttributes checks attributes against the configured schema.
+// Attributes can only be written once a schema exists.
+func (s *Service) validateAttributes(ctx context.Context, attrs Attributes) error {
+	schema, err := s.GetAttributeSchema(ctx)
+	if err != nil {
+		return err
+	}
+
+	if schema == nil {
+		if len(attrs) > 0 {
+			return Validation("invalid_attributes", "no attribute schema has been configured")
+		}
+		return nil
+	}
+
+	if attrs == nil {
+		attrs = Attributes{}
+	}
+	if errs := schema.Validate(attrs); len(errs) > 0 {
+		messages := make([]string, len(errs))
+		for i, fe := range errs {
+			messages[i] = fe.Field + ": " + fe.Message
+		}
+		invalid := Validation("invalid_attributes", "invalid attributes: %s", strings.Join(messages, "; "))
+		invalid.Fields = errs
+		return invalid
+	}
+	return nil
+}
+
+// generateTokens issues tokens for the user, including any attributes the
+// schema marks as token claims
+func (s *Service) generateTokens(ctx context.Context, user *User) (string, string, error) {
+	schema, err := s.GetAttributeSchema(ctx)
+	if err != nil {
+		return "", "", err
+	}
+
+	var claims Attributes
+	if schema != nil {
+		claims = schema.ClaimAttributes(user.Attributes)
+	}
+	return s.jwtService.GenerateTokens(user, claims)
+}
+
+// typeAttributeFilters converts string attribute filters to the attribute
+// types declared in the schema
+func (s *Service) typeAttributeFilters(ctx context.Context, params *ListUsersParams) error {
+	if len(params.Attributes) == 0 {
+		return nil
+	}
+
+	schema, err := s.GetAttributeSchema(ctx)
+	if err != nil {
+		return err
+	}
+	if schema == nil {
+		return Validation("invalid_parameter", "no attribute schema has been configured")
+	}
+
+	for name, value := range params.Attributes {
+		str, ok := value.(string)
+		if !ok {
+			continue
+		}
+		typed, err := schema.filterValue(name, str)
+		if err != nil {
+			return err
+		}
+		params.Attributes[name] = typed
+	}
+	return nil
+}
+
+// jsonContains implements the jsonb @> operator on decoded JSON values
+func jsonContains(have, want interface{}) bool {
+	switch want := want.(type) {
+	case map[string]interface{}:
+		have, ok := have.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for key, value := range want {
+			if v, ok := have[key]; !ok || !jsonContains(v, value) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		have, ok := have.([]interface{})
+		if !ok {
+			return false
+		}
+		for _, value := range want {
+			found := false
+			for _, v := range have {
+				if jsonContains(v, value) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(have, want)
+}
@@ -0,0 +1,240 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"time"
+
+	// Register the decoders for the accepted avatar formats
+	_ "image/gif"
+	_ "image/png"
+
+	"github.com/gabriel-vasile/mimetype"
+)
+
+const (
+	// MaxAvatarSize is the largest avatar upload accepted, in bytes
+	MaxAvatarSize = 5 << 20
+	// MaxAvatarRequestSize bounds an avatar upload request, leaving room
+	// for the multipart framing around the image
+	MaxAvatarRequestSize = MaxAvatarSize + 64<<10
+	// MaxAvatarDimension bounds the width and height of uploaded images so
+	// that small files can't decode into huge bitmaps
+	MaxAvatarDimension = 4096
+
+	// DefaultAvatarSize is the rendition exposed as avatar_url
+	DefaultAvatarSize = "medium"
+)
+
+// AvatarSizes are the square renditions every avatar is resized to
+var AvatarSizes = map[string]int{
+	"small":  64,
+	"medium": 256,
+	"large":  512,
+}
+
+// avatarTypes are the accepted upload formats
+var avatarTypes = []string{"image/jpeg", "image/png", "image/gif"}
+
+// AvatarURLs maps each avatar size to the URL of its rendition
+type AvatarURLs map[string]string
+
+func (a AvatarURLs) Value() (driver.Value, error) {
+	if a == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(a)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+func (a *AvatarURLs) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	case nil:
+		*a = nil
+		return nil
+	default:
+		return fmt.Errorf("cannot scan %T into avatar URLs", src)
+	}
+	return json.Unmarshal(data, a)
+}
+
+func avatarKey(userID int, size string) string {
+	return fmt.Sprintf("avatars/%d/%s.jpg", userID, size)
+}
+
+// SetAvatar validates an uploaded image, stores it in every avatar size
+// and points the user at the new renditions
+func (s *Service) SetAvatar(ctx context.Context, id int, data []byte, expectedVersion *int) (*User, error) {
+	if s.blobs == nil {
+		return nil, Internal(fmt.Errorf("avatar storage is not configured"))
+	}
+
+	user, err := s.repo.GetUserByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if expectedVersion != nil && user.Version != *expectedVersion {
+		return nil, ErrPreconditionFailed
+	}
+
+	img, err := decodeAvatar(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// The version query parameter makes clients refetch replaced avatars
+	version := time.Now().Unix()
+	urls := AvatarURLs{}
+	for size, pixels := range AvatarSizes {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, resizeSquare(img, pixels), &jpeg.Options{Quality: 85}); err != nil {
+			return nil, fmt.Errorf("failed to encode avatar: %w", err)
+		}
+
+		key := avatarKey(user.ID, size)
+		if err := s.blobs.Put(key, &buf, "image/jpeg"); err != nil {
+			return nil, err
+		}
+		urls[size] = fmt.Sprintf("%s?v=%d", s.blobs.URL(key), version)
+	}
+
+	// Only applies if the user hasn't changed since the precondition was
+	// checked
+	if err := s.repo.SetUserAvatar(ctx, user.ID, user.Version, urls); err != nil {
+		return nil, err
+	}
+
+	return s.repo.GetUserByID(ctx, user.ID)
+}
+
+// DeleteAvatar removes the user's avatar
+func (s *Service) DeleteAvatar(ctx context.Context, id int, expectedVersion *int) (*User, error) {
+	user, err := s.repo.GetUserByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if expectedVersion != nil && user.Version != *expectedVersion {
+		return nil, ErrPreconditionFailed
+	}
+
+	if err := s.repo.SetUserAvatar(ctx, user.ID, user.Version, nil); err != nil {
+		return nil, err
+	}
+	if err := s.deleteAvatarFiles(user.ID); err != nil {
+		return nil, err
+	}
+
+	return s.repo.GetUserByID(ctx, user.ID)
+}
+
+// deleteAvatarFiles removes every stored rendition of the user's avatar
+func (s *Service) deleteAvatarFiles(ids ...int) error {
+	if s.blobs == nil {
+		return nil
+	}
+
+	for _, id := range ids {
+		for size := range AvatarSizes {
+			if err := s.blobs.Delete(avatarKey(id, size)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// decodeAvatar sniffs the real content type of an upload, ignoring the
+// type claimed by the client, and decodes it
+func decodeAvatar(data []byte) (image.Image, error) {
+	if len(data) > MaxAvatarSize {
+		return nil, Validation("invalid_avatar", "avatar must be at most %d bytes", MaxAvatarSize)
+	}
+
+	detected := mimetype.Detect(data)
+	if !mimetype.EqualsAny(detected.String(), avatarTypes...) {
+		return nil, Validation("invalid_avatar", "unsupported avatar type %s", detected.String())
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, Validation("invalid_avatar", "invalid image: %v", err)
+	}
+	if config.Width > MaxAvatarDimension || config.Height > MaxAvatarDimension {
+		return nil, Validation("invalid_avatar", "avatar must be at most %dx%d pixels", MaxAvatarDimension, MaxAvatarDimension)
+	}
+	if config.Width == 0 || config.Height == 0 {
+		return nil, Validation("invalid_avatar", "invalid image: empty")
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, Validation("invalid_avatar", "invalid image: %v", err)
+	}
+	return img, nil
+}
+
+// resizeSquare crops the centre square of img and scales it to size x size
+// by averaging the source pixels that fall into each target pixel.
+// Transparent areas are flattened onto white since the output is JPEG.
+func resizeSquare(img image.Image, size int) *image.RGBA {
+	bounds := img.Bounds()
+	side := min(bounds.Dx(), bounds.Dy())
+	crop := image.Rect(0, 0, side, side)
+	offset := image.Pt(bounds.Min.X+(bounds.Dx()-side)/2, bounds.Min.Y+(bounds.Dy()-side)/2)
+
+	src := image.NewRGBA(crop)
+	draw.Draw(src, crop, image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(src, crop, img, offset, draw.Over)
+
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		y0, y1 := sourceSpan(y, size, side)
+		for x := 0; x < size; x++ {
+			x0, x1 := sourceSpan(x, size, side)
+
+			var r, g, b, n int
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					r += int(row[sx*4])
+					g += int(row[sx*4+1])
+					b += int(row[sx*4+2])
+					n++
+				}
+			}
+
+			i := y*dst.Stride + x*4
+			dst.Pix[i] = uint8(r / n)
+			dst.Pix[i+1] = uint8(g / n)
+			dst.Pix[i+2] = uint8(b / n)
+			dst.Pix[i+3] = 0xff
+		}
+	}
+	return dst
+}
+
+// sourceSpan returns the range of source pixels covered by target pixel i
+// when scaling side pixels to size
+func sourceSpan(i, size, side int) (int, int) {
+	start := i * side / size
+	end := (i + 1) * side / size
+	if end <= start {
+		end = start + 1
+	}
+	return start, end
+}
@@ -0,0 +1,181 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+const (
+	BatchCreate = "create"
+	BatchUpdate = "update"
+	BatchDelete = "delete"
+)
+
+const MaxBatchOperations = 100
+
+// BatchOperation is one write in a batch. Data holds the request body of
+// the equivalent single-user endpoint, and Version the If-Match version an
+// update or delete must apply to, if any.
+type BatchOperation struct {
+	Op      string          `json:"op"`
+	ID      int             `json:"id,omitempty"`
+	Version *int            `json:"version,omitempty"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+// BatchRequest lists operations to run in order. Atomic batches run in a
+// single transaction and are rolled back entirely if any operation fails;
+// otherwise every operation succeeds or fails on its own.
+type BatchRequest struct {
+	Atomic     bool             `json:"atomic"`
+	Operations []BatchOperation `json:"operations"`
+}
+
+type BatchResult struct {
+	Index  int      `json:"index"`
+	Op     string   `json:"op"`
+	Status int      `json:"status"`
+	User   *User    `json:"user,omitempty"`
+	Error  *Problem `json:"error,omitempty"`
+
+	// Err is why the operation failed; handlers render it into Error
+	Err error `json:"-"`
+}
+
+type BatchReport struct {
+	Atomic    bool          `json:"atomic"`
+	Succeeded int           `json:"succeeded"`
+	Failed    int           `json:"failed"`
+	Results   []BatchResult `json:"results"`
+}
+
+// errBatchOperationFailed aborts the transaction of an atomic batch
+var errBatchOperationFailed = errors.New("batch operation failed")
+
+// Batch runs create, update and delete operations against users
+func (s *Service) Batch(ctx context.Context, req BatchRequest) (*BatchReport, error) {
+	if len(req.Operations) == 0 {
+		return nil, Validation("invalid_batch", "batch contains no operations")
+	}
+	if len(req.Operations) > MaxBatchOperations {
+		return nil, Validation("invalid_batch", "batch exceeds %d operations", MaxBatchOperations)
+	}
+
+	report := &BatchReport{
+		Atomic:  req.Atomic,
+		Results: make([]BatchResult, len(req.Operations)),
+	}
+	for i, op := range req.Operations {
+		report.Results[i] = BatchResult{Index: i, Op: op.Op}
+	}
+
+	if !req.Atomic {
+		for i, op := range req.Operations {
+			s.runBatchOperation(ctx, op, &report.Results[i])
+		}
+		report.tally()
+		return report, nil
+	}
+
+	failed := -1
+	err := s.withTx(ctx, func(tx *Service) error {
+		for i, op := range req.Operations {
+			if !tx.runBatchOperation(ctx, op, &report.Results[i]) {
+				failed = i
+				return errBatchOperationFailed
+			}
+		}
+		return nil
+	})
+	if err != nil && failed < 0 {
+		return nil, err
+	}
+	if failed >= 0 {
+		rolledBack := Conflict("rolled_back", "rolled back because operation %d failed", failed).WithStatus(http.StatusFailedDependency)
+		for i := range report.Results {
+			if i != failed {
+				report.Results[i] = BatchResult{Index: i, Op: report.Results[i].Op, Err: rolledBack}
+			}
+		}
+	}
+
+	report.tally()
+	return report, nil
+}
+
+// runBatchOperation runs one operation and records its outcome, returning
+// whether it succeeded
+func (s *Service) runBatchOperation(ctx context.Context, op BatchOperation, result *BatchResult) bool {
+	user, status, err := s.applyBatchOperation(ctx, op)
+	if err != nil {
+		result.Err = err
+		return false
+	}
+	result.Status = status
+	result.User = user
+	return true
+}
+
+func (s *Service) applyBatchOperation(ctx context.Context, op BatchOperation) (*User, int, error) {
+	switch op.Op {
+	case BatchCreate:
+		var req CreateUserRequest
+		if err := decodeBatchData(op.Data, &req); err != nil {
+			return nil, 0, err
+		}
+		if err := validate.Struct(req); err != nil {
+			return nil, 0, err
+		}
+		user, err := s.createUser(ctx, req)
+		return user, http.StatusCreated, err
+
+	case BatchUpdate:
+		var req UpdateUserRequest
+		if err := decodeBatchData(op.Data, &req); err != nil {
+			return nil, 0, err
+		}
+		req.ID = op.ID
+		req.ExpectedVersion = op.Version
+		if err := validate.Struct(req); err != nil {
+			return nil, 0, err
+		}
+		response, err := s.UpdateUser(ctx, req)
+		if err != nil {
+			return nil, 0, err
+		}
+		return &response.User, http.StatusOK, nil
+
+	case BatchDelete:
+		req := DeleteUserRequest{ID: op.ID, ExpectedVersion: op.Version}
+		if err := validate.Struct(req); err != nil {
+			return nil, 0, err
+		}
+		_, err := s.DeleteUser(ctx, req)
+		return nil, http.StatusNoContent, err
+	}
+
+	return nil, 0, Validation("invalid_operation", "unknown batch operation %q", op.Op)
+}
+
+func decodeBatchData(data json.RawMessage, v interface{}) error {
+	if len(data) == 0 {
+		return Validation("invalid_operation", "operation data is required")
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return Validation("invalid_operation", "invalid operation data: %v", err)
+	}
+	return nil
+}
+
+func (r *BatchReport) tally() {
+	r.Succeeded, r.Failed = 0, 0
+	for _, result := range r.Results {
+		if result.Err != nil {
+			r.Failed++
+		} else {
+			r.Succeeded++
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+)
+
+type contextKey string
+
+const (
+	claimsContextKey    contextKey = "claims"
+	requestIDContextKey contextKey = "request_id"
+	writesContextKey    contextKey = "writes"
+)
+
+// ContextWithClaims returns a copy of ctx carrying the authenticated user's
+// claims
+func ContextWithClaims(ctx context.Context, claims *Claims) context.Context {
+	return context.WithValue(ctx, claimsContextKey, claims)
+}
+
+// ClaimsFromContext returns the claims of the authenticated user, if any
+func ClaimsFromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(claimsContextKey).(*Claims)
+	return claims, ok
+}
+
+// ContextWithRequestID returns a copy of ctx carrying the request ID
+func ContextWithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDContextKey, requestID)
+}
+
+// RequestIDFromContext returns the request ID, or "" if there is none
+func RequestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDContextKey).(string)
+	return requestID
+}
+
+// ContextWithWriteTracking returns a copy of ctx that remembers whether a
+// store has written with it. Once it has, reads made with ctx go to the
+// primary database so that they see the write despite replication lag.
+func ContextWithWriteTracking(ctx context.Context) context.Context {
+	return context.WithValue(ctx, writesContextKey, new(atomic.Bool))
+}
+
+// ContextWithPrimaryReads returns a copy of ctx whose reads all go to the
+// primary database, for requests that change data: the versions they
+// check before writing must be current.
+func ContextWithPrimaryReads(ctx context.Context) context.Context {
+	ctx = ContextWithWriteTracking(ctx)
+	markWritten(ctx)
+	return ctx
+}
+
+// markWritten records a write made with ctx, if ctx tracks writes
+func markWritten(ctx context.Context) {
+	if written, ok := ctx.Value(writesContextKey).(*atomic.Bool); ok {
+		written.Store(true)
+	}
+}
+
+// hasWritten reports whether a write has been made with ctx
+func hasWritten(ctx context.Context) bool {
+	written, ok := ctx.Value(writesContextKey).(*atomic.Bool)
+	return ok && written.Load()
+}
+
+// ActorFromRequest identifies who is making a request, for change records
+func ActorFromRequest(r *http.Request, source string) Actor {
+	actor := Actor{Source: source, RequestID: RequestIDFromContext(r.Context())}
+	if claims, ok := ClaimsFromContext(r.Context()); ok {
+		actor.UserID = claims.UserID
+		actor.Email = claims.Email
+	}
+	return actor
+}
@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ErrorKind classifies an Error and decides its HTTP status
+type ErrorKind int
+
+const (
+	KindInternal ErrorKind = iota
+	KindValidation
+	KindUnauthorized
+	KindForbidden
+	KindNotFound
+	KindConflict
+	KindPreconditionFailed
+	KindUnavailable
+	// KindCanceled is a request abandoned because the client went away.
+	// Nobody is left to read the response, so none is written.
+	KindCanceled
+)
+
+// StatusClientClosedRequest is the non-standard status recorded for
+// canceled requests, as nginx does
+const StatusClientClosedRequest = 499
+
+var kindStatus = map[ErrorKind]int{
+	KindInternal:           http.StatusInternalServerError,
+	KindValidation:         http.StatusBadRequest,
+	KindUnauthorized:       http.StatusUnauthorized,
+	KindForbidden:          http.StatusForbidden,
+	KindNotFound:           http.StatusNotFound,
+	KindConflict:           http.StatusConflict,
+	KindPreconditionFailed: http.StatusPreconditionFailed,
+	KindUnavailable:        http.StatusServiceUnavailable,
+	KindCanceled:           StatusClientClosedRequest,
+}
+
+// Error is a failure that can be reported to API clients. Code is a stable
+// machine-readable identifier and Message is safe to show to the caller.
+// Err holds the underlying cause, which is logged but never returned.
+type Error struct {
+	Kind    ErrorKind
+	Code    string
+	Message string
+	// Status overrides the status implied by Kind, for protocol-level
+	// failures such as 415 Unsupported Media Type
+	Status int
+	// Fields lists the individual fields that failed validation, if known
+	Fields []FieldError
+	Err    error
+
+	// format and args build Message; the format is the key of its
+	// translations, which are formatted with the same args
+	format string
+	args   []interface{}
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil && e.Kind == KindInternal {
+		return fmt.Sprintf("%s: %v", e.Message, e.Err)
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// HTTPStatus returns the status code the error is reported with
+func (e *Error) HTTPStatus() int {
+	if e.Status != 0 {
+		return e.Status
+	}
+	return kindStatus[e.Kind]
+}
+
+// WithStatus returns a copy of the error reported with another status code
+func (e *Error) WithStatus(status int) *Error {
+	copied := *e
+	copied.Status = status
+	return &copied
+}
+
+func newError(kind ErrorKind, code, format string, args ...interface{}) *Error {
+	return &Error{Kind: kind, Code: code, Message: fmt.Sprintf(format, args...), format: format, args: args}
+}
+
+// Validation reports a request the caller has to correct
+func Validation(code, format string, args ...interface{}) *Error {
+	return newError(KindValidation, code, format, args...)
+}
+
+// Unauthorized reports missing or invalid credentials
+func Unauthorized(code, format string, args ...interface{}) *Error {
+	return newError(KindUnauthorized, code, format, args...)
+}
+
+// Forbidden reports an authenticated caller lacking permission
+func Forbidden(code, format string, args ...interface{}) *Error {
+	return newError(KindForbidden, code, format, args...)
+}
+
+// NotFound reports a missing resource
+func NotFound(code, format string, args ...interface{}) *Error {
+	return newError(KindNotFound, code, format, args...)
+}
+
+// Conflict reports a request that clashes with the resource's current state
+func Conflict(code, format string, args ...interface{}) *Error {
+	return newError(KindConflict, code, format, args...)
+}
+
+// Internal wraps an unexpected failure. Clients only see a generic message.
+func Internal(err error) *Error {
+	return &Error{Kind: KindInternal, Code: "internal_error", Message: "internal server error", Err: err}
+}
+
+// AsError returns err as an *Error, treating untyped errors as internal.
+// Queries that ran out of time are reported as unavailable, and queries
+// abandoned because the client went away as canceled.
+func AsError(err error) *Error {
+	var typed *Error
+	if errors.As(err, &typed) {
+		return typed
+	}
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return &Error{Kind: KindUnavailable, Code: "timeout", Message: "request timed out", Err: err}
+	case errors.Is(err, context.Canceled):
+		return &Error{Kind: KindCanceled, Code: "request_canceled", Message: "request was canceled", Err: err}
+	}
+	return Internal(err)
+}
+
+var (
+	// ErrUserNotFound is returned when no user matches the requested ID or
+	// email
+	ErrUserNotFound = NotFound("user_not_found", "user not found")
+
+	// ErrPreconditionFailed is returned when a write targets a user that
+	// has been modified since the caller last read it
+	ErrPreconditionFailed = &Error{
+		Kind:    KindPreconditionFailed,
+		Code:    "precondition_failed",
+		Message: "user has been modified by another request",
+	}
+
+	// ErrEmailTaken is returned when a write would give a user an email
+	// that another user already has, compared case-insensitively
+	ErrEmailTaken = Conflict("email_taken", "user with this email already exists")
+
+	// ErrInvalidCredentials is returned when a login's email or password
+	// is wrong. Which one is deliberately not revealed.
+	ErrInvalidCredentials = Unauthorized("invalid_credentials", "invalid credentials")
+
+	// ErrInvalidToken is returned for refresh tokens that are invalid,
+	// expired or belong to a user that no longer exists
+	ErrInvalidToken = Unauthorized("invalid_token", "invalid refresh token")
+
+	// ErrInvalidBody is returned when a request body can't be decoded
+	ErrInvalidBody = Validation("invalid_body", "Invalid request body")
+)
@@ -0,0 +1,172 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// IncludeGroups embeds the provisioned groups a user belongs to
+const IncludeGroups = "groups"
+
+// UserFields lists the user fields that can be selected with ?fields=. The
+// password hash and version are not rendered and can't be selected.
+var UserFields = []string{
+	"id", "email", "first_name", "last_name", "country", "language",
+	"is_active", "created_at", "updated_at", "attributes", "avatar_url",
+	"avatar_urls", "deactivated_at", "anonymized_at", "external_id",
+}
+
+// UserIncludes lists the related resources that can be embedded with
+// ?include=
+var UserIncludes = []string{IncludeGroups}
+
+// UserView selects the fields and related resources rendered for users.
+// The zero value renders every field and no relations.
+type UserView struct {
+	Fields  []string
+	Include []string
+}
+
+// RenderedUserPage is a UserPage whose users were rendered through a view
+type RenderedUserPage struct {
+	Data       []map[string]interface{} `json:"data"`
+	NextCursor string                   `json:"next_cursor,omitempty"`
+	PrevCursor string                   `json:"prev_cursor,omitempty"`
+	Total      *int                     `json:"total,omitempty"`
+}
+
+// ParseUserView reads the comma-separated fields and include query
+// parameters, validating them against UserFields and UserIncludes
+func ParseUserView(query url.Values) (UserView, error) {
+	var view UserView
+	var err error
+
+	if v := query.Get("fields"); v != "" {
+		view.Fields, err = parseAllowlist(v, UserFields, "field")
+		if err != nil {
+			return view, err
+		}
+	}
+	if v := query.Get("include"); v != "" {
+		view.Include, err = parseAllowlist(v, UserIncludes, "include")
+		if err != nil {
+			return view, err
+		}
+	}
+
+	return view, nil
+}
+
+func parseAllowlist(list string, allowlist []string, kind string) ([]string, error) {
+	allowed := map[string]bool{}
+	for _, name := range allowlist {
+		allowed[name] = true
+	}
+
+	var names []string
+	seen := map[string]bool{}
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if !allowed[name] {
+			return nil, Validation("invalid_parameter", "unknown %s %q", kind, name)
+		}
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// IsFull reports whether the view renders users unchanged
+func (v UserView) IsFull() bool {
+	return len(v.Fields) == 0 && len(v.Include) == 0
+}
+
+func (v UserView) includes(name string) bool {
+	for _, include := range v.Include {
+		if include == name {
+			return true
+		}
+	}
+	return false
+}
+
+// RenderUsers renders users through a view. Related resources are loaded
+// with one query per include rather than one per user.
+func (s *Service) RenderUsers(ctx context.Context, users []User, view UserView) ([]map[string]interface{}, error) {
+	var groups map[int][]GroupMembership
+	if view.includes(IncludeGroups) && len(users) > 0 {
+		ids := make([]int, len(users))
+		for i := range users {
+			ids[i] = users[i].ID
+		}
+		var err error
+		groups, err = s.repo.GetGroupsForUsers(ctx, ids)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rendered := make([]map[string]interface{}, len(users))
+	for i := range users {
+		record, err := renderUser(&users[i], view.Fields)
+		if err != nil {
+			return nil, err
+		}
+		if groups != nil {
+			record[IncludeGroups] = groups[users[i].ID]
+		}
+		rendered[i] = record
+	}
+	return rendered, nil
+}
+
+// RenderUser renders a single user through a view
+func (s *Service) RenderUser(ctx context.Context, user *User, view UserView) (map[string]interface{}, error) {
+	rendered, err := s.RenderUsers(ctx, []User{*user}, view)
+	if err != nil {
+		return nil, err
+	}
+	return rendered[0], nil
+}
+
+// RenderUserPage renders every user of a page through a view
+func (s *Service) RenderUserPage(ctx context.Context, page *UserPage, view UserView) (*RenderedUserPage, error) {
+	data, err := s.RenderUsers(ctx, page.Data, view)
+	if err != nil {
+		return nil, err
+	}
+	return &RenderedUserPage{
+		Data:       data,
+		NextCursor: page.NextCursor,
+		PrevCursor: page.PrevCursor,
+		Total:      page.Total,
+	}, nil
+}
+
+// renderUser converts a user to its JSON object, keeping only the selected
+// fields. The id is always kept so embedded and sparse records stay
+// addressable. Selected fields that are empty are rendered as null.
+func renderUser(user *User, fields []string) (map[string]interface{}, error) {
+	data, err := json.Marshal(user)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render user: %w", err)
+	}
+	var full map[string]interface{}
+	if err := json.Unmarshal(data, &full); err != nil {
+		return nil, fmt.Errorf("failed to render user: %w", err)
+	}
+	if len(fields) == 0 {
+		return full, nil
+	}
+
+	record := map[string]interface{}{"id": full["id"]}
+	for _, field := range fields {
+		record[field] = full[field]
+	}
+	return record, nil
+}
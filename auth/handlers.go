@@ -2,27 +2,51 @@ package auth
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
 	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+)
+
+const (
+	// MaxRequestSize bounds JSON request bodies such as patches
+	MaxRequestSize = 1 << 20
+	// MaxImportSize bounds an import upload
+	MaxImportSize = 10 << 20
 )
 
 type Handler struct {
 	service   *Service
 	validator *validator.Validate
+	config    HandlerConfig
+}
+
+type HandlerConfig struct {
+	// RequireIfMatch rejects updates and deletes that don't send If-Match
+	RequireIfMatch bool
 }
 
-func NewHandler(service *Service) *Handler {
+func NewHandler(service *Service, config HandlerConfig) *Handler {
 	return &Handler{
 		service:   service,
-		validator: validator.New(),
+		validator: validate,
+		config:    config,
 	}
 }
 
-func (h *Handler) respondWithError(w http.ResponseWriter, code int, message string) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(code)
-	json.NewEncoder(w).Encode(map[string]string{"error": message})
+// respondWithError reports err as a problem+json response
+func (h *Handler) respondWithError(w http.ResponseWriter, r *http.Request, err error) {
+	WriteError(w, r, err)
 }
 
 func (h *Handler) respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
@@ -31,42 +55,58 @@ func (h *Handler) respondWithJSON(w http.ResponseWriter, code int, payload inter
 	json.NewEncoder(w).Encode(payload)
 }
 
+// readBody reads a request body of at most limit bytes. A larger body is
+// rejected with 413 rather than truncated.
+func readBody(w http.ResponseWriter, r *http.Request, limit int64) ([]byte, error) {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return nil, Validation("request_too_large", "request body must be at most %d bytes", limit).WithStatus(http.StatusRequestEntityTooLarge)
+		}
+		return nil, ErrInvalidBody
+	}
+	return data, nil
+}
+
 func (h *Handler) CreateUser(w http.ResponseWriter, r *http.Request) {
 	var req CreateUserRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		h.respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		h.respondWithError(w, r, ErrInvalidBody)
 		return
 	}
 
 	if err := h.validator.Struct(req); err != nil {
-		h.respondWithError(w, http.StatusBadRequest, err.Error())
+		h.respondWithError(w, r, err)
 		return
 	}
 
-	response, err := h.service.CreateUser(req)
+	response, err := h.serviceFor(r).CreateUser(r.Context(), req)
 	if err != nil {
-		h.respondWithError(w, http.StatusBadRequest, err.Error())
+		h.respondWithError(w, r, err)
 		return
 	}
 
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/users/%d", response.User.ID))
+	w.Header().Set("ETag", etag(&response.User))
 	h.respondWithJSON(w, http.StatusCreated, response)
 }
 
 func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 	var req LoginRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		h.respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		h.respondWithError(w, r, ErrInvalidBody)
 		return
 	}
 
 	if err := h.validator.Struct(req); err != nil {
-		h.respondWithError(w, http.StatusBadRequest, err.Error())
+		h.respondWithError(w, r, err)
 		return
 	}
 
-	response, err := h.service.Login(req)
+	response, err := h.service.Login(r.Context(), req)
 	if err != nil {
-		h.respondWithError(w, http.StatusUnauthorized, err.Error())
+		h.respondWithError(w, r, err)
 		return
 	}
 
@@ -76,49 +116,611 @@ func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) UpdateUser(w http.ResponseWriter, r *http.Request) {
 	var req UpdateUserRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		h.respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		h.respondWithError(w, r, ErrInvalidBody)
 		return
 	}
 
 	if err := h.validator.Struct(req); err != nil {
-		h.respondWithError(w, http.StatusBadRequest, err.Error())
+		h.respondWithError(w, r, err)
 		return
 	}
 
-	response, err := h.service.UpdateUser(req)
+	expected, ok := h.expectedVersion(w, r, req.ID)
+	if !ok {
+		return
+	}
+	req.ExpectedVersion = expected
+
+	response, err := h.serviceFor(r).UpdateUser(r.Context(), req)
 	if err != nil {
-		h.respondWithError(w, http.StatusBadRequest, err.Error())
+		h.respondWithError(w, r, err)
 		return
 	}
 
+	w.Header().Set("ETag", etag(&response.User))
 	h.respondWithJSON(w, http.StatusOK, response)
 }
 
+func (h *Handler) GetUser(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		h.respondWithError(w, r, ErrUserNotFound)
+		return
+	}
+
+	view, err := ParseUserView(r.URL.Query())
+	if err != nil {
+		h.respondWithError(w, r, err)
+		return
+	}
+
+	user, err := h.service.GetUser(r.Context(), id)
+	if err != nil {
+		h.respondWithError(w, r, err)
+		return
+	}
+
+	if tag, ok := viewETag(user, view); ok {
+		w.Header().Set("ETag", tag)
+		if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" && etagMatches(ifNoneMatch, tag, true) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	if view.IsFull() {
+		h.respondWithJSON(w, http.StatusOK, user)
+		return
+	}
+
+	rendered, err := h.service.RenderUser(r.Context(), user, view)
+	if err != nil {
+		h.respondWithError(w, r, err)
+		return
+	}
+	h.respondWithJSON(w, http.StatusOK, rendered)
+}
+
+// PatchUser partially updates a user. JSON Merge Patch is assumed for plain
+// application/json bodies.
+func (h *Handler) PatchUser(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		h.respondWithError(w, r, ErrUserNotFound)
+		return
+	}
+
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch contentType {
+	case MergePatchContentType, JSONPatchContentType, "application/json":
+	default:
+		w.Header().Set("Accept-Patch", MergePatchContentType+", "+JSONPatchContentType)
+		h.respondWithError(w, r, Validation("unsupported_media_type", "Unsupported patch content type").WithStatus(http.StatusUnsupportedMediaType))
+		return
+	}
+
+	patch, err := readBody(w, r, MaxRequestSize)
+	if err != nil {
+		h.respondWithError(w, r, err)
+		return
+	}
+
+	expected, ok := h.expectedVersion(w, r, id)
+	if !ok {
+		return
+	}
+
+	user, err := h.service.GetUser(r.Context(), id)
+	if err != nil {
+		h.respondWithError(w, r, err)
+		return
+	}
+	if expected != nil && user.Version != *expected {
+		h.respondWithError(w, r, ErrPreconditionFailed)
+		return
+	}
+
+	req, err := h.service.ApplyPatch(user, contentType, patch)
+	if err != nil {
+		h.respondWithError(w, r, err)
+		return
+	}
+
+	if err := h.validator.Struct(req); err != nil {
+		h.respondWithError(w, r, err)
+		return
+	}
+
+	// Guard against the user changing between reading and writing it
+	req.ExpectedVersion = &user.Version
+
+	response, err := h.serviceFor(r).UpdateUser(r.Context(), req)
+	if err != nil {
+		h.respondWithError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", etag(&response.User))
+	h.respondWithJSON(w, http.StatusOK, response.User)
+}
+
+// PutAvatar replaces the user's avatar with the image uploaded in the
+// "avatar" field of a multipart form
+func (h *Handler) PutAvatar(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		h.respondWithError(w, r, ErrUserNotFound)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, MaxAvatarRequestSize)
+	file, _, err := r.FormFile("avatar")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			h.respondWithError(w, r, Validation("invalid_avatar", "avatar must be at most %d bytes", MaxAvatarSize).WithStatus(http.StatusRequestEntityTooLarge))
+			return
+		}
+		h.respondWithError(w, r, Validation("invalid_avatar", "multipart field avatar is required"))
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, MaxAvatarSize+1))
+	if err != nil {
+		h.respondWithError(w, r, ErrInvalidBody)
+		return
+	}
+
+	expected, ok := h.expectedVersion(w, r, id)
+	if !ok {
+		return
+	}
+
+	user, err := h.service.SetAvatar(r.Context(), id, data, expected)
+	if err != nil {
+		h.respondWithError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", etag(user))
+	h.respondWithJSON(w, http.StatusOK, user)
+}
+
+func (h *Handler) DeleteAvatar(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		h.respondWithError(w, r, ErrUserNotFound)
+		return
+	}
+
+	expected, ok := h.expectedVersion(w, r, id)
+	if !ok {
+		return
+	}
+
+	user, err := h.service.DeleteAvatar(r.Context(), id, expected)
+	if err != nil {
+		h.respondWithError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", etag(user))
+	h.respondWithJSON(w, http.StatusOK, user)
+}
+
+func (h *Handler) DeleteUserByID(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		h.respondWithError(w, r, ErrUserNotFound)
+		return
+	}
+
+	expected, ok := h.expectedVersion(w, r, id)
+	if !ok {
+		return
+	}
+
+	if _, err := h.serviceFor(r).DeleteUser(r.Context(), DeleteUserRequest{ID: id, ExpectedVersion: expected}); err != nil {
+		h.respondWithError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func (h *Handler) GetAllUsers(w http.ResponseWriter, r *http.Request) {
-	response, err := h.service.ViewUsers()
+	response, err := h.service.ViewUsers(r.Context())
 	if err != nil {
-		h.respondWithError(w, http.StatusBadRequest, err.Error())
+		h.respondWithError(w, r, err)
 		return
 	}
 
 	h.respondWithJSON(w, http.StatusOK, response)
 }
 
+func (h *Handler) ListUsers(w http.ResponseWriter, r *http.Request) {
+	params, err := ParseListUsersParams(r.URL.Query())
+	if err != nil {
+		h.respondWithError(w, r, err)
+		return
+	}
+	h.listUsers(w, r, params)
+}
+
+// ListDeactivatedUsers lists deactivated users with the same options as
+// ListUsers
+func (h *Handler) ListDeactivatedUsers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	query.Del("active")
+	params, err := ParseAdminListUsersParams(query)
+	if err != nil {
+		h.respondWithError(w, r, err)
+		return
+	}
+	active := false
+	params.Active = &active
+	h.listUsers(w, r, params)
+}
+
+func (h *Handler) listUsers(w http.ResponseWriter, r *http.Request, params ListUsersParams) {
+	view, err := ParseUserView(r.URL.Query())
+	if err != nil {
+		h.respondWithError(w, r, err)
+		return
+	}
+
+	page, err := h.service.ListUsers(r.Context(), params)
+	if err != nil {
+		h.respondWithError(w, r, err)
+		return
+	}
+
+	var links []string
+	if page.NextCursor != "" {
+		links = append(links, fmt.Sprintf(`<%s>; rel="next"`, pageURL(r, page.NextCursor)))
+	}
+	if page.PrevCursor != "" {
+		links = append(links, fmt.Sprintf(`<%s>; rel="prev"`, pageURL(r, page.PrevCursor)))
+	}
+	if len(links) > 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+
+	if view.IsFull() {
+		h.respondWithJSON(w, http.StatusOK, page)
+		return
+	}
+
+	rendered, err := h.service.RenderUserPage(r.Context(), page, view)
+	if err != nil {
+		h.respondWithError(w, r, err)
+		return
+	}
+	h.respondWithJSON(w, http.StatusOK, rendered)
+}
+
+func (h *Handler) RestoreUser(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		h.respondWithError(w, r, ErrUserNotFound)
+		return
+	}
+
+	user, err := h.serviceFor(r).RestoreUser(r.Context(), id)
+	if err != nil {
+		h.respondWithError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", etag(user))
+	h.respondWithJSON(w, http.StatusOK, user)
+}
+
+// ListUserChanges pages through a user's change history, newest first
+func (h *Handler) ListUserChanges(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		h.respondWithError(w, r, ErrUserNotFound)
+		return
+	}
+
+	limit := 0
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 {
+			h.respondWithError(w, r, Validation("invalid_parameter", "invalid limit %q", v))
+			return
+		}
+	}
+
+	page, err := h.service.ListUserChanges(r.Context(), id, limit, r.URL.Query().Get("cursor"))
+	if err != nil {
+		h.respondWithError(w, r, err)
+		return
+	}
+
+	if page.NextCursor != "" {
+		w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, pageURL(r, page.NextCursor)))
+	}
+	h.respondWithJSON(w, http.StatusOK, page)
+}
+
+type revertUserRequest struct {
+	Version int `json:"version" validate:"required,min=1"`
+}
+
+// RevertUser restores a user's profile to an earlier version from its
+// change history
+func (h *Handler) RevertUser(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		h.respondWithError(w, r, ErrUserNotFound)
+		return
+	}
+
+	var req revertUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondWithError(w, r, ErrInvalidBody)
+		return
+	}
+	if err := h.validator.Struct(req); err != nil {
+		h.respondWithError(w, r, err)
+		return
+	}
+
+	expected, ok := h.expectedVersion(w, r, id)
+	if !ok {
+		return
+	}
+
+	user, err := h.serviceFor(r).RevertUser(r.Context(), id, req.Version, expected)
+	if err != nil {
+		h.respondWithError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", etag(user))
+	h.respondWithJSON(w, http.StatusOK, user)
+}
+
+func (h *Handler) PurgeUser(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		h.respondWithError(w, r, ErrUserNotFound)
+		return
+	}
+
+	if err := h.service.PurgeUser(r.Context(), id); err != nil {
+		h.respondWithError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ExportUserData returns everything stored about a user as a JSON
+// attachment
+func (h *Handler) ExportUserData(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		h.respondWithError(w, r, ErrUserNotFound)
+		return
+	}
+
+	export, err := h.service.ExportUserData(r.Context(), id, requestedBy(r))
+	if err != nil {
+		h.respondWithError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="user-%d.json"`, id))
+	h.respondWithJSON(w, http.StatusOK, export)
+}
+
+// EraseUser anonymizes a user's personal data on a right-to-erasure request
+func (h *Handler) EraseUser(w http.ResponseWriter, r *http.Request) {
+	id, err := pathID(r)
+	if err != nil {
+		h.respondWithError(w, r, ErrUserNotFound)
+		return
+	}
+
+	req, err := h.service.EraseUser(r.Context(), id, requestedBy(r))
+	if err != nil {
+		h.respondWithError(w, r, err)
+		return
+	}
+
+	h.respondWithJSON(w, http.StatusOK, req)
+}
+
+func (h *Handler) GetAttributeSchema(w http.ResponseWriter, r *http.Request) {
+	schema, err := h.service.GetAttributeSchema(r.Context())
+	if err != nil {
+		h.respondWithError(w, r, err)
+		return
+	}
+	if schema == nil {
+		h.respondWithError(w, r, NotFound("attribute_schema_not_found", "no attribute schema has been configured"))
+		return
+	}
+
+	h.respondWithJSON(w, http.StatusOK, schema)
+}
+
+// PutAttributeSchema replaces the JSON Schema that custom attributes are
+// validated against
+func (h *Handler) PutAttributeSchema(w http.ResponseWriter, r *http.Request) {
+	data, err := readBody(w, r, MaxRequestSize)
+	if err != nil {
+		h.respondWithError(w, r, err)
+		return
+	}
+
+	schema, err := h.service.SetAttributeSchema(r.Context(), data)
+	if err != nil {
+		h.respondWithError(w, r, err)
+		return
+	}
+
+	h.respondWithJSON(w, http.StatusOK, schema)
+}
+
+func (h *Handler) SearchUsers(w http.ResponseWriter, r *http.Request) {
+	limit := 0
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			h.respondWithError(w, r, Validation("invalid_parameter", "invalid limit %q", v))
+			return
+		}
+		limit = n
+	}
+
+	results, err := h.service.SearchUsers(r.Context(), r.URL.Query().Get("q"), limit)
+	if err != nil {
+		h.respondWithError(w, r, err)
+		return
+	}
+
+	h.respondWithJSON(w, http.StatusOK, map[string]interface{}{"data": results})
+}
+
+// Batch runs a list of user create, update and delete operations, either
+// in one transaction or independently, and reports the outcome of each
+func (h *Handler) Batch(w http.ResponseWriter, r *http.Request) {
+	var req BatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondWithError(w, r, ErrInvalidBody)
+		return
+	}
+
+	report, err := h.serviceFor(r).Batch(r.Context(), req)
+	if err != nil {
+		h.respondWithError(w, r, err)
+		return
+	}
+
+	for i := range report.Results {
+		result := &report.Results[i]
+		if result.Err != nil {
+			problem := newProblem(r, result.Err)
+			result.Status = problem.Status
+			result.Error = &problem
+		}
+	}
+
+	status := http.StatusOK
+	if report.Atomic && report.Failed > 0 {
+		status = http.StatusUnprocessableEntity
+	}
+	h.respondWithJSON(w, status, report)
+}
+
+// ImportUsers bulk-creates users from a CSV or NDJSON request body
+func (h *Handler) ImportUsers(w http.ResponseWriter, r *http.Request) {
+	format, err := importFormat(r.Header.Get("Content-Type"))
+	if err != nil {
+		h.respondWithError(w, r, err)
+		return
+	}
+
+	opts := ImportOptions{
+		Mode:     ImportMode(r.URL.Query().Get("mode")),
+		Language: translator(r).Locale(),
+	}
+	if v := r.URL.Query().Get("dry_run"); v != "" {
+		if opts.DryRun, err = strconv.ParseBool(v); err != nil {
+			h.respondWithError(w, r, Validation("invalid_parameter", "invalid dry_run %q", v))
+			return
+		}
+	}
+
+	rows, err := ParseImport(format, http.MaxBytesReader(w, r.Body, MaxImportSize))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			err = Validation("invalid_import", "import must be at most %d bytes", MaxImportSize).WithStatus(http.StatusRequestEntityTooLarge)
+		}
+		h.respondWithError(w, r, err)
+		return
+	}
+
+	report, err := h.serviceFor(r).ImportUsers(r.Context(), rows, opts)
+	if err != nil {
+		h.respondWithError(w, r, err)
+		return
+	}
+
+	status := http.StatusOK
+	if report.Mode == ImportAtomic && !report.DryRun && report.Created < report.Total {
+		status = http.StatusUnprocessableEntity
+	}
+	h.respondWithJSON(w, status, report)
+}
+
+// ExportUsers streams users matching the listing filters as CSV or NDJSON
+func (h *Handler) ExportUsers(w http.ResponseWriter, r *http.Request) {
+	params, err := ParseAdminListUsersParams(r.URL.Query())
+	if err != nil {
+		h.respondWithError(w, r, err)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = ExportFormatCSV
+	}
+
+	columns, err := ParseExportColumns(r.URL.Query().Get("columns"))
+	if err != nil {
+		h.respondWithError(w, r, err)
+		return
+	}
+
+	out := &flushWriter{w: w}
+	exporter, err := NewExporter(format, out, columns)
+	if err != nil {
+		h.respondWithError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", ExportContentType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="users.%s"`, format))
+
+	// The stream has no query timeout; the request context stops it when
+	// the client disconnects
+	if err := h.service.ExportUsers(r.Context(), params, exporter); err != nil {
+		if out.written == 0 {
+			h.respondWithError(w, r, err)
+			return
+		}
+		// The status line has already been sent, all we can do is stop
+		log.Printf("user export aborted after %d bytes: %v", out.written, err)
+	}
+}
+
 func (h *Handler) DeleteUser(w http.ResponseWriter, r *http.Request) {
 	var req DeleteUserRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		h.respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		h.respondWithError(w, r, ErrInvalidBody)
 		return
 	}
 
 	if err := h.validator.Struct(req); err != nil {
-		h.respondWithError(w, http.StatusBadRequest, err.Error())
+		h.respondWithError(w, r, err)
 		return
 	}
 
-	response, err := h.service.DeleteUser(req)
+	expected, ok := h.expectedVersion(w, r, req.ID)
+	if !ok {
+		return
+	}
+	req.ExpectedVersion = expected
+
+	response, err := h.serviceFor(r).DeleteUser(r.Context(), req)
 	if err != nil {
-		h.respondWithError(w, http.StatusBadRequest, err.Error())
+		h.respondWithError(w, r, err)
 		return
 	}
 
@@ -128,18 +730,18 @@ func (h *Handler) DeleteUser(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) RefreshToken(w http.ResponseWriter, r *http.Request) {
 	var req RefreshTokenRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		h.respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		h.respondWithError(w, r, ErrInvalidBody)
 		return
 	}
 
 	if err := h.validator.Struct(req); err != nil {
-		h.respondWithError(w, http.StatusBadRequest, err.Error())
+		h.respondWithError(w, r, err)
 		return
 	}
 
-	response, err := h.service.RefreshToken(req)
+	response, err := h.service.RefreshToken(r.Context(), req)
 	if err != nil {
-		h.respondWithError(w, http.StatusUnauthorized, err.Error())
+		h.respondWithError(w, r, err)
 		return
 	}
 
@@ -151,3 +753,229 @@ func (h *Handler) Logout(w http.ResponseWriter, r *http.Request) {
 	// For now, we'll just return success and let the client handle it
 	h.respondWithJSON(w, http.StatusOK, map[string]string{"message": "Logged out successfully"})
 }
+
+// serviceFor returns the service with changes attributed to the caller
+func (h *Handler) serviceFor(r *http.Request) *Service {
+	return h.service.WithActor(ActorFromRequest(r, SourceAPI))
+}
+
+// requestedBy identifies the authenticated caller for audit records
+func requestedBy(r *http.Request) string {
+	if claims, ok := ClaimsFromContext(r.Context()); ok {
+		return claims.Email
+	}
+	return "unknown"
+}
+
+// expectedVersion evaluates the If-Match precondition of a write to the
+// given user and returns the version the write must apply to, or nil when
+// the client sent no If-Match. It responds and returns false when the write
+// must not proceed.
+func (h *Handler) expectedVersion(w http.ResponseWriter, r *http.Request, id int) (*int, bool) {
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		if h.config.RequireIfMatch {
+			h.respondWithError(w, r, Validation("precondition_required", "If-Match header required").WithStatus(http.StatusPreconditionRequired))
+			return nil, false
+		}
+		return nil, true
+	}
+
+	user, err := h.service.GetUser(r.Context(), id)
+	if err != nil {
+		h.respondWithError(w, r, err)
+		return nil, false
+	}
+
+	if !etagMatches(ifMatch, etag(user), false) {
+		h.respondWithError(w, r, ErrPreconditionFailed)
+		return nil, false
+	}
+
+	return &user.Version, true
+}
+
+// etag returns the entity tag of a user's current representation
+func etag(user *User) string {
+	return fmt.Sprintf(`"%d"`, user.Version)
+}
+
+// viewETag returns the entity tag of a user rendered through a view. Sparse
+// views are told apart from each other and from the full representation by
+// their fields. Related resources change without the user's version, so a
+// view that includes them has no tag.
+func viewETag(user *User, view UserView) (string, bool) {
+	if len(view.Include) > 0 {
+		return "", false
+	}
+	if len(view.Fields) == 0 {
+		return etag(user), true
+	}
+
+	fields := append([]string(nil), view.Fields...)
+	sort.Strings(fields)
+	return fmt.Sprintf(`"%d;fields=%s"`, user.Version, strings.Join(fields, ",")), true
+}
+
+// etagMatches reports whether an If-Match or If-None-Match header matches
+// the tag. Weak comparison is used for If-None-Match, strong for If-Match.
+func etagMatches(header, tag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if strings.HasPrefix(candidate, "W/") {
+			if !weak {
+				continue
+			}
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// flushWriter pushes every write to the client so exports stream instead
+// of accumulating in the response buffer
+type flushWriter struct {
+	w       http.ResponseWriter
+	written int
+}
+
+func (f *flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	f.written += n
+	if flusher, ok := f.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return n, err
+}
+
+// importFormat picks the import parser from the request content type
+func importFormat(contentType string) (string, error) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "text/csv":
+		return ImportFormatCSV, nil
+	case "application/x-ndjson", "application/ndjson", "application/jsonl":
+		return ImportFormatNDJSON, nil
+	}
+	return "", Validation("unsupported_media_type", "import requires text/csv or application/x-ndjson").WithStatus(http.StatusUnsupportedMediaType)
+}
+
+// pathID reads the numeric {id} route variable
+func pathID(r *http.Request) (int, error) {
+	return strconv.Atoi(mux.Vars(r)["id"])
+}
+
+// ParseListUsersParams reads the options of the public user listing from
+// the query string. Only active users are listed; active=true is accepted
+// but nothing else, as deactivated users are only listed to admins.
+func ParseListUsersParams(query url.Values) (ListUsersParams, error) {
+	return parseListUsersParams(query, false)
+}
+
+// ParseAdminListUsersParams reads listing options like ParseListUsersParams,
+// but also lists deactivated users if active=false or active=all is given
+func ParseAdminListUsersParams(query url.Values) (ListUsersParams, error) {
+	return parseListUsersParams(query, true)
+}
+
+func parseListUsersParams(query url.Values, admin bool) (ListUsersParams, error) {
+	params := ListUsersParams{
+		Cursor:   query.Get("cursor"),
+		Country:  query.Get("country"),
+		Language: query.Get("language"),
+		Sort:     query.Get("sort"),
+		Order:    strings.ToLower(query.Get("order")),
+	}
+
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 {
+			return params, Validation("invalid_parameter", "invalid limit %q", v)
+		}
+		params.Limit = limit
+	}
+
+	switch v := strings.ToLower(query.Get("active")); v {
+	case "", "true":
+		active := true
+		params.Active = &active
+	case "false":
+		if !admin {
+			return params, Validation("invalid_parameter", "only active users can be listed")
+		}
+		active := false
+		params.Active = &active
+	case "all":
+		if !admin {
+			return params, Validation("invalid_parameter", "only active users can be listed")
+		}
+	default:
+		return params, Validation("invalid_parameter", "invalid active filter %q", v)
+	}
+
+	for name, target := range map[string]**time.Time{
+		"created_after":  &params.CreatedAfter,
+		"created_before": &params.CreatedBefore,
+	} {
+		v := query.Get(name)
+		if v == "" {
+			continue
+		}
+		t, err := parseTime(v)
+		if err != nil {
+			return params, Validation("invalid_parameter", "invalid %s %q", name, v)
+		}
+		*target = &t
+	}
+
+	// Custom attributes are filtered with attr.<name>=<value>
+	for key, values := range query {
+		name, ok := strings.CutPrefix(key, "attr.")
+		if !ok {
+			continue
+		}
+		if !attributeName.MatchString(name) {
+			return params, Validation("invalid_parameter", "invalid attribute name %q", name)
+		}
+		if params.Attributes == nil {
+			params.Attributes = map[string]interface{}{}
+		}
+		params.Attributes[name] = values[0]
+	}
+
+	if v := query.Get("include_total"); v != "" {
+		includeTotal, err := strconv.ParseBool(v)
+		if err != nil {
+			return params, Validation("invalid_parameter", "invalid include_total %q", v)
+		}
+		params.IncludeTotal = includeTotal
+	}
+
+	return params, nil
+}
+
+// parseTime accepts RFC 3339 timestamps or plain dates
+func parseTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
+// pageURL rebuilds the request URL pointing at another page
+func pageURL(r *http.Request, cursor string) string {
+	query := r.URL.Query()
+	query.Set("cursor", cursor)
+
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s%s?%s", scheme, r.Host, r.URL.Path, query.Encode())
+}
@@ -0,0 +1,211 @@
+package auth
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+const (
+	ChangeCreate     = "create"
+	ChangeUpdate     = "update"
+	ChangeDeactivate = "deactivate"
+	ChangeRestore    = "restore"
+	ChangeRevert     = "revert"
+)
+
+const (
+	SourceAPI    = "api"
+	SourceSCIM   = "scim"
+	SourceCLI    = "cli"
+	SourceSystem = "system"
+)
+
+// Actor identifies who made a change
+type Actor struct {
+	UserID    int    `json:"user_id,omitempty"`
+	Email     string `json:"email,omitempty"`
+	Source    string `json:"source"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// UserSnapshot is the state of a user's editable fields at one version
+type UserSnapshot struct {
+	Email      string     `json:"email"`
+	FirstName  string     `json:"first_name"`
+	LastName   string     `json:"last_name"`
+	Country    string     `json:"country"`
+	Language   string     `json:"language"`
+	IsActive   bool       `json:"is_active"`
+	Attributes Attributes `json:"attributes"`
+}
+
+type FieldChange struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+// UserChange is one entry in a user's change history
+type UserChange struct {
+	ID        int64                  `json:"id"`
+	UserID    int                    `json:"user_id"`
+	Version   int                    `json:"version"`
+	Action    string                 `json:"action"`
+	Actor     Actor                  `json:"actor"`
+	Changes   map[string]FieldChange `json:"changes"`
+	Snapshot  UserSnapshot           `json:"-"`
+	CreatedAt time.Time              `json:"created_at"`
+}
+
+type UserChangePage struct {
+	Data       []UserChange `json:"data"`
+	NextCursor string       `json:"next_cursor,omitempty"`
+}
+
+func snapshotOf(user *User) UserSnapshot {
+	return UserSnapshot{
+		Email:      user.Email,
+		FirstName:  user.FirstName,
+		LastName:   user.LastName,
+		Country:    user.Country,
+		Language:   user.Language,
+		IsActive:   user.IsActive,
+		Attributes: user.Attributes.orEmpty(),
+	}
+}
+
+// diffSnapshots returns the fields that differ between two snapshots. A
+// nil before records every field as new.
+func diffSnapshots(before *UserSnapshot, after UserSnapshot) map[string]FieldChange {
+	fields := func(s UserSnapshot) map[string]interface{} {
+		return map[string]interface{}{
+			"email":      s.Email,
+			"first_name": s.FirstName,
+			"last_name":  s.LastName,
+			"country":    s.Country,
+			"language":   s.Language,
+			"is_active":  s.IsActive,
+			"attributes": map[string]interface{}(s.Attributes.orEmpty()),
+		}
+	}
+
+	changes := map[string]FieldChange{}
+	newValues := fields(after)
+	if before == nil {
+		for field, value := range newValues {
+			changes[field] = FieldChange{To: value}
+		}
+		return changes
+	}
+
+	for field, old := range fields(*before) {
+		if !reflect.DeepEqual(old, newValues[field]) {
+			changes[field] = FieldChange{From: old, To: newValues[field]}
+		}
+	}
+	return changes
+}
+
+// WithActor returns a Service that attributes the changes it makes to
+// actor
+func (s *Service) WithActor(actor Actor) *Service {
+	bound := *s
+	bound.actor = actor
+	return &bound
+}
+
+// recordChange stores a history entry for the user's current state. before
+// is the user as it was prior to the change, or nil for a new user.
+func (s *Service) recordChange(ctx context.Context, action string, before *User, id int) error {
+	return s.repo.RecordUserChange(ctx, action, before, id, s.actor)
+}
+
+// ListUserChanges pages through a user's history, newest first
+func (s *Service) ListUserChanges(ctx context.Context, id, limit int, cursorParam string) (*UserChangePage, error) {
+	if _, err := s.repo.GetUserByIDIncludingInactive(ctx, id); err != nil {
+		return nil, err
+	}
+
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+
+	var beforeID int64
+	if cursorParam != "" {
+		c, err := decodeCursor(cursorParam)
+		if err != nil || c.Sort != "history" {
+			return nil, Validation("invalid_cursor", "invalid cursor")
+		}
+		beforeID, err = strconv.ParseInt(c.Value, 10, 64)
+		if err != nil {
+			return nil, Validation("invalid_cursor", "invalid cursor")
+		}
+	}
+
+	changes, err := s.repo.ListUserChanges(ctx, id, limit+1, beforeID)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &UserChangePage{Data: changes}
+	if len(changes) > limit {
+		page.Data = changes[:limit]
+		last := page.Data[limit-1]
+		page.NextCursor = encodeCursor(cursor{Sort: "history", Value: strconv.FormatInt(last.ID, 10)})
+	}
+	return page, nil
+}
+
+// RevertUser restores a user's profile fields to the state recorded at an
+// earlier version. Whether the user is active is not changed; use
+// DeleteUser and RestoreUser for that.
+func (s *Service) RevertUser(ctx context.Context, id, version int, expectedVersion *int) (*User, error) {
+	var reverted *User
+	err := s.withTx(ctx, func(tx *Service) error {
+		user, err := tx.repo.GetUserByIDIncludingInactive(ctx, id)
+		if err != nil {
+			return err
+		}
+		if expectedVersion != nil && user.Version != *expectedVersion {
+			return ErrPreconditionFailed
+		}
+		if user.AnonymizedAt != nil {
+			return Conflict("user_anonymized", "anonymized users cannot be reverted")
+		}
+		if version >= user.Version {
+			return Validation("invalid_version", "version %d is not an earlier version of the user", version)
+		}
+
+		snapshot, err := tx.repo.GetUserSnapshot(ctx, id, version)
+		if err != nil {
+			return err
+		}
+
+		before := *user
+		user.Email = snapshot.Email
+		user.FirstName = snapshot.FirstName
+		user.LastName = snapshot.LastName
+		user.Country = snapshot.Country
+		user.Language = snapshot.Language
+		user.Attributes = snapshot.Attributes
+
+		if err := tx.repo.UpdateUser(ctx, user); err != nil {
+			return err
+		}
+		if err := tx.recordChange(ctx, ChangeRevert, &before, id); err != nil {
+			return err
+		}
+
+		reverted, err = tx.repo.GetUserByIDIncludingInactive(ctx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return reverted, nil
+}
@@ -0,0 +1,165 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/go-playground/locales/de"
+	"github.com/go-playground/locales/en"
+	"github.com/go-playground/locales/es"
+	"github.com/go-playground/locales/fr"
+	ut "github.com/go-playground/universal-translator"
+	"github.com/go-playground/validator/v10"
+	de_translations "github.com/go-playground/validator/v10/translations/de"
+	en_translations "github.com/go-playground/validator/v10/translations/en"
+	es_translations "github.com/go-playground/validator/v10/translations/es"
+	fr_translations "github.com/go-playground/validator/v10/translations/fr"
+	"golang.org/x/text/language"
+)
+
+// translations holds a translator for every supported language, with
+// English as the fallback
+var translations = newTranslations()
+
+func newTranslations() *ut.UniversalTranslator {
+	uni := ut.New(en.New(), en.New(), es.New(), fr.New(), de.New())
+
+	register := map[string]func(*validator.Validate, ut.Translator) error{
+		"en": en_translations.RegisterDefaultTranslations,
+		"es": es_translations.RegisterDefaultTranslations,
+		"fr": fr_translations.RegisterDefaultTranslations,
+		"de": de_translations.RegisterDefaultTranslations,
+	}
+	for locale, registerDefaults := range register {
+		trans, _ := uni.GetTranslator(locale)
+		if err := registerDefaults(validate, trans); err != nil {
+			panic(fmt.Sprintf("auth: failed to register %s validation messages: %v", locale, err))
+		}
+
+		for tag, text := range validationCatalog[locale] {
+			if err := validate.RegisterTranslation(tag, trans, registerText(tag, text), translateField); err != nil {
+				panic(fmt.Sprintf("auth: failed to register %s message for %s: %v", locale, tag, err))
+			}
+		}
+
+		if err := trans.Add(invalidFieldKey, invalidFieldCatalog[locale], false); err != nil {
+			panic(fmt.Sprintf("auth: failed to register %s message for invalid fields: %v", locale, err))
+		}
+
+		for key, text := range messageCatalog[locale] {
+			if verbs := formatVerbs.FindAllString(key, -1); fmt.Sprint(verbs) != fmt.Sprint(formatVerbs.FindAllString(text, -1)) {
+				panic(fmt.Sprintf("auth: %s message %q must use the verbs %v", locale, key, verbs))
+			}
+			if err := trans.Add(key, text, false); err != nil {
+				panic(fmt.Sprintf("auth: failed to register %s message %q: %v", locale, key, err))
+			}
+		}
+	}
+
+	return uni
+}
+
+// formatVerbs matches the fmt verbs of a message format. A translation has
+// to use the same verbs in the same order as its key.
+var formatVerbs = regexp.MustCompile(`%[a-z]`)
+
+func registerText(tag, text string) validator.RegisterTranslationsFunc {
+	return func(trans ut.Translator) error {
+		return trans.Add(tag, text, false)
+	}
+}
+
+func translateField(trans ut.Translator, fe validator.FieldError) string {
+	message, err := trans.T(fe.Tag(), fe.Field())
+	if err != nil {
+		return invalidField(trans, fe.Field())
+	}
+	return message
+}
+
+// invalidFieldKey is the translation key of invalidFieldCatalog
+const invalidFieldKey = "invalid_field"
+
+// invalidField is the message for a failed rule without a message of its
+// own, used instead of the validator's internal description
+func invalidField(trans ut.Translator, field string) string {
+	message, err := trans.T(invalidFieldKey, field)
+	if err != nil {
+		return field + " is invalid"
+	}
+	return message
+}
+
+// fieldMessage describes a failed rule in the translator's language
+func fieldMessage(trans ut.Translator, fe validator.FieldError) string {
+	// Translate falls back to the internal description for rules without
+	// a registered message
+	if message := fe.Translate(trans); message != fe.Error() {
+		return message
+	}
+	return invalidField(trans, fe.Field())
+}
+
+// translator picks the language of a response: the authenticated user's
+// language first, then the Accept-Language header, then English
+func translator(r *http.Request) ut.Translator {
+	var preferred []string
+	if claims, ok := ClaimsFromContext(r.Context()); ok && claims.Language != "" {
+		preferred = append(preferred, baseLanguage(claims.Language))
+	}
+
+	tags, _, _ := language.ParseAcceptLanguage(r.Header.Get("Accept-Language"))
+	for _, tag := range tags {
+		base, _ := tag.Base()
+		preferred = append(preferred, base.String())
+	}
+
+	trans, _ := translations.FindTranslator(preferred...)
+	return trans
+}
+
+func baseLanguage(tag string) string {
+	base, _ := language.Make(tag).Base()
+	return base.String()
+}
+
+// translateMessage translates a message from the catalog, leaving messages
+// without a translation in English
+func translateMessage(trans ut.Translator, message string) string {
+	if translated, err := trans.T(message); err == nil && translated != "" {
+		return translated
+	}
+	return message
+}
+
+// translate returns the error's message in the translator's language.
+// Messages built from a format are translated by their format, so that
+// the arguments don't stop them from being found in the catalog.
+func (e *Error) translate(trans ut.Translator) string {
+	if e.format == "" {
+		return translateMessage(trans, e.Message)
+	}
+	return fmt.Sprintf(translateMessage(trans, e.format), e.args...)
+}
+
+// translateValidationErrors describes each failed rule, with the message
+// translated
+func translateValidationErrors(trans ut.Translator, err error) []FieldError {
+	var validationErrs validator.ValidationErrors
+	if !errors.As(err, &validationErrs) {
+		return nil
+	}
+
+	fields := make([]FieldError, len(validationErrs))
+	for i, fe := range validationErrs {
+		fields[i] = FieldError{
+			Field:   fieldPath(fe),
+			Rule:    fe.Tag(),
+			Param:   fe.Param(),
+			Message: fieldMessage(trans, fe),
+		}
+	}
+	return fields
+}
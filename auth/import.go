@@ -0,0 +1,359 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+
+	ut "github.com/go-playground/universal-translator"
+)
+
+const (
+	ImportFormatCSV    = "csv"
+	ImportFormatNDJSON = "ndjson"
+
+	// MaxImportRows bounds the size of a single import
+	MaxImportRows = 10000
+)
+
+type ImportMode string
+
+const (
+	// ImportAtomic creates every row or none of them
+	ImportAtomic ImportMode = "atomic"
+	// ImportBestEffort creates every valid row independently
+	ImportBestEffort ImportMode = "best_effort"
+)
+
+const (
+	ImportStatusCreated    = "created"
+	ImportStatusValid      = "valid"
+	ImportStatusInvalid    = "invalid"
+	ImportStatusFailed     = "failed"
+	ImportStatusRolledBack = "rolled_back"
+)
+
+type ImportOptions struct {
+	Mode     ImportMode
+	DryRun   bool
+	Language string // Of the row errors; English if empty or unsupported
+}
+
+// ImportRow is one parsed record of an import file
+type ImportRow struct {
+	Row        int
+	Request    CreateUserRequest
+	ParseError string
+}
+
+// ImportRowResult is the outcome of one row. Its errors are described the
+// same way as the fields of a failed request; errors that don't concern a
+// single field, such as a malformed record, have no field.
+type ImportRowResult struct {
+	Row    int          `json:"row"`
+	Email  string       `json:"email,omitempty"`
+	Status string       `json:"status"`
+	UserID int          `json:"user_id,omitempty"`
+	Errors []FieldError `json:"errors,omitempty"`
+}
+
+type ImportReport struct {
+	Mode    ImportMode        `json:"mode"`
+	DryRun  bool              `json:"dry_run"`
+	Total   int               `json:"total"`
+	Created int               `json:"created"`
+	Failed  int               `json:"failed"`
+	Rows    []ImportRowResult `json:"rows"`
+}
+
+// csvColumns maps CSV header names to CreateUserRequest fields
+var csvColumns = map[string]func(*CreateUserRequest, string){
+	"email":      func(r *CreateUserRequest, v string) { r.Email = v },
+	"password":   func(r *CreateUserRequest, v string) { r.Password = v },
+	"first_name": func(r *CreateUserRequest, v string) { r.FirstName = v },
+	"last_name":  func(r *CreateUserRequest, v string) { r.LastName = v },
+	"country":    func(r *CreateUserRequest, v string) { r.Country = v },
+	"language":   func(r *CreateUserRequest, v string) { r.Language = v },
+}
+
+// ParseImport reads users from a CSV file with a header row or from
+// newline-delimited JSON. Malformed records are returned with a ParseError
+// rather than failing the whole import.
+func ParseImport(format string, r io.Reader) ([]ImportRow, error) {
+	switch format {
+	case ImportFormatCSV:
+		return parseImportCSV(r)
+	case ImportFormatNDJSON:
+		return parseImportNDJSON(r)
+	}
+	return nil, Validation("invalid_import", "unsupported import format %q", format)
+}
+
+func parseImportCSV(r io.Reader) ([]ImportRow, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		if err == io.EOF {
+			return nil, Validation("invalid_import", "import file is empty")
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return nil, Validation("invalid_import", "invalid CSV header: %v", parseErr.Err)
+		}
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+
+	setters := make([]func(*CreateUserRequest, string), len(header))
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		setter, ok := csvColumns[name]
+		if !ok {
+			return nil, Validation("invalid_import", "unknown CSV column %q", name)
+		}
+		setters[i] = setter
+	}
+
+	var rows []ImportRow
+	for n := 1; ; n++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if len(rows) >= MaxImportRows {
+			return nil, Validation("invalid_import", "import exceeds %d rows", MaxImportRows)
+		}
+
+		row := ImportRow{Row: n}
+		var parseErr *csv.ParseError
+		switch {
+		case errors.As(err, &parseErr):
+			row.ParseError = parseErr.Err.Error()
+		case err != nil:
+			return nil, fmt.Errorf("failed to read CSV: %w", err)
+		case len(record) != len(header):
+			row.ParseError = fmt.Sprintf("expected %d fields, got %d", len(header), len(record))
+		default:
+			for i, value := range record {
+				setters[i](&row.Request, strings.TrimSpace(value))
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+func parseImportNDJSON(r io.Reader) ([]ImportRow, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var rows []ImportRow
+	for n := 1; scanner.Scan(); n++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if len(rows) >= MaxImportRows {
+			return nil, Validation("invalid_import", "import exceeds %d rows", MaxImportRows)
+		}
+
+		row := ImportRow{Row: n}
+		decoder := json.NewDecoder(bytes.NewReader(line))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&row.Request); err != nil {
+			row.ParseError = fmt.Sprintf("invalid JSON: %v", err)
+		}
+		rows = append(rows, row)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read NDJSON: %w", err)
+	}
+
+	return rows, nil
+}
+
+// ImportUsers creates users from parsed import rows. Every row is validated
+// with the same rules as CreateUserRequest. In atomic mode nothing is
+// created unless every row succeeds; a dry run reports what would happen
+// without writing anything.
+func (s *Service) ImportUsers(ctx context.Context, rows []ImportRow, opts ImportOptions) (*ImportReport, error) {
+	if opts.Mode == "" {
+		opts.Mode = ImportAtomic
+	}
+	if opts.Mode != ImportAtomic && opts.Mode != ImportBestEffort {
+		return nil, Validation("invalid_import", "invalid import mode %q", opts.Mode)
+	}
+	if len(rows) == 0 {
+		return nil, Validation("invalid_import", "import contains no rows")
+	}
+
+	trans, _ := translations.FindTranslator(opts.Language)
+	report := &ImportReport{
+		Mode:   opts.Mode,
+		DryRun: opts.DryRun,
+		Total:  len(rows),
+		Rows:   make([]ImportRowResult, len(rows)),
+	}
+
+	// Validate every row up front, including duplicates within the file
+	seen := map[string]int{}
+	valid := 0
+	for i, row := range rows {
+		result := &report.Rows[i]
+		result.Row = row.Row
+		result.Email = row.Request.Email
+
+		if errs := s.validateImportRow(ctx, trans, row, seen); len(errs) > 0 {
+			result.Status = ImportStatusInvalid
+			result.Errors = errs
+			continue
+		}
+		result.Status = ImportStatusValid
+		seen[NormalizeEmail(row.Request.Email)] = row.Row
+		valid++
+	}
+
+	if opts.DryRun || (opts.Mode == ImportAtomic && valid < len(rows)) {
+		report.tally()
+		return report, nil
+	}
+
+	// Hash passwords before anything is written, so that an atomic import's
+	// transaction only holds its locks for the inserts
+	users := make([]*User, len(rows))
+	for i, row := range rows {
+		if report.Rows[i].Status != ImportStatusValid {
+			continue
+		}
+		user, err := s.newUser(ctx, row.Request)
+		if err != nil {
+			report.Rows[i].Status = ImportStatusFailed
+			report.Rows[i].Errors = importErrors(trans, row, err)
+			valid--
+			continue
+		}
+		users[i] = user
+	}
+
+	if opts.Mode == ImportBestEffort {
+		for i, row := range rows {
+			if users[i] != nil {
+				s.importRow(ctx, trans, row, users[i], &report.Rows[i])
+			}
+		}
+		report.tally()
+		return report, nil
+	}
+	if valid < len(rows) {
+		report.tally()
+		return report, nil
+	}
+
+	err := s.withTx(ctx, func(tx *Service) error {
+		for i, row := range rows {
+			if !tx.importRow(ctx, trans, row, users[i], &report.Rows[i]) {
+				return fmt.Errorf("row %d failed", row.Row)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		for i := range report.Rows {
+			if report.Rows[i].Status == ImportStatusCreated {
+				report.Rows[i].Status = ImportStatusRolledBack
+				report.Rows[i].UserID = 0
+			} else if report.Rows[i].Status == ImportStatusValid {
+				report.Rows[i].Status = ImportStatusRolledBack
+			}
+		}
+	}
+
+	report.tally()
+	return report, nil
+}
+
+func (s *Service) validateImportRow(ctx context.Context, trans ut.Translator, row ImportRow, seen map[string]int) []FieldError {
+	if row.ParseError != "" {
+		return []FieldError{{Rule: "parse", Message: row.ParseError}}
+	}
+
+	var errs []FieldError
+	if err := validate.Struct(row.Request); err != nil {
+		errs = append(errs, importErrors(trans, row, err)...)
+	}
+
+	if first, ok := seen[NormalizeEmail(row.Request.Email)]; ok {
+		errs = append(errs, FieldError{Field: "email", Rule: "duplicate", Param: strconv.Itoa(first),
+			Message: fmt.Sprintf(translateMessage(trans, "duplicate of row %d"), first)})
+	} else if taken, err := s.emailTaken(ctx, row.Request.Email); err != nil {
+		errs = append(errs, importErrors(trans, row, err)...)
+	} else if taken {
+		errs = append(errs, FieldError{Field: "email", Rule: "unique",
+			Message: translateMessage(trans, ErrEmailTaken.Message)})
+	}
+
+	return errs
+}
+
+// emailTaken reports whether any user has email, including deactivated
+// users, whose emails stay reserved until they are anonymized
+func (s *Service) emailTaken(ctx context.Context, email string) (bool, error) {
+	page, err := s.repo.ListUsers(ctx, ListUsersParams{Email: email, Sort: "id", Order: "asc", Limit: 1})
+	if err != nil {
+		return false, err
+	}
+	return len(page.Data) > 0, nil
+}
+
+func (s *Service) importRow(ctx context.Context, trans ut.Translator, row ImportRow, user *User, result *ImportRowResult) bool {
+	if err := s.insertUser(ctx, user); err != nil {
+		result.Status = ImportStatusFailed
+		result.Errors = importErrors(trans, row, err)
+		return false
+	}
+
+	result.Status = ImportStatusCreated
+	result.UserID = user.ID
+	return true
+}
+
+// importErrors describes why a row was rejected, as newProblem would for a
+// request. Unexpected failures are logged and only reported generically.
+func importErrors(trans ut.Translator, row ImportRow, err error) []FieldError {
+	if fields := translateValidationErrors(trans, err); fields != nil {
+		return fields
+	}
+
+	appErr := AsError(err)
+	if len(appErr.Fields) > 0 {
+		return appErr.Fields
+	}
+	if appErr.Kind == KindInternal {
+		log.Printf("import row %d: %v", row.Row, err)
+	}
+	return []FieldError{{Rule: appErr.Code, Message: appErr.translate(trans)}}
+}
+
+func (r *ImportReport) tally() {
+	r.Created, r.Failed = 0, 0
+	for _, row := range r.Rows {
+		switch row.Status {
+		case ImportStatusCreated:
+			r.Created++
+		case ImportStatusInvalid, ImportStatusFailed:
+			r.Failed++
+		}
+	}
+}
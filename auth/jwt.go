@@ -14,8 +14,10 @@ type JWTService struct {
 }
 
 type Claims struct {
-	UserID int    `json:"user_id"`
-	Email  string `json:"email"`
+	UserID     int        `json:"user_id"`
+	Email      string     `json:"email"`
+	Language   string     `json:"language,omitempty"`
+	Attributes Attributes `json:"attributes,omitempty"`
 	jwt.RegisteredClaims
 }
 
@@ -27,11 +29,15 @@ func NewJWTService(secretKey string) *JWTService {
 	}
 }
 
-func (j *JWTService) GenerateTokens(user *User) (string, string, error) {
+// GenerateTokens issues an access and a refresh token for the user. The
+// given attributes are added to the access token's claims.
+func (j *JWTService) GenerateTokens(user *User, attributes Attributes) (string, string, error) {
 	// Generate Access Token
 	accessClaims := &Claims{
-		UserID: user.ID,
-		Email:  user.Email,
+		UserID:     user.ID,
+		Email:      user.Email,
+		Language:   user.Language,
+		Attributes: attributes,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(j.accessTokenTTL)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
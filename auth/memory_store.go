@@ -0,0 +1,767 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStore is a UserStore that keeps its data in process memory, for
+// development and tests. Everything is lost when the process exits.
+//
+// Group memberships are provisioned through SCIM, whose group store only
+// exists in Postgres, so users in a MemoryStore belong to no groups.
+// Sorting by text columns compares bytes rather than using a collation.
+type MemoryStore struct {
+	mu   *sync.Mutex // nil when the store is bound to a transaction
+	data *memoryData
+}
+
+type memoryData struct {
+	users           map[int]*User
+	changes         []memoryChange
+	privacyRequests []PrivacyRequest
+	attributeSchema []byte
+
+	lastUserID    int
+	lastChangeID  int64
+	lastRequestID int
+}
+
+// memoryChange is a history entry with its changes and snapshot kept as
+// JSON, as in the user_changes table
+type memoryChange struct {
+	UserChange
+	changes  []byte
+	snapshot []byte
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		mu:   &sync.Mutex{},
+		data: &memoryData{users: map[int]*User{}},
+	}
+}
+
+// WithTx runs fn against a copy of the data, which replaces the store's
+// data if fn succeeds. Transactions are serialized with every other call.
+func (s *MemoryStore) WithTx(ctx context.Context, fn func(UserStore) error) error {
+	if s.mu == nil {
+		return fn(s)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx := &MemoryStore{data: s.data.clone()}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	s.data = tx.data
+	return nil
+}
+
+// lock acquires the store for a single call; it is a no-op inside a
+// transaction, which already holds the lock
+func (s *MemoryStore) lock() (unlock func()) {
+	if s.mu == nil {
+		return func() {}
+	}
+	s.mu.Lock()
+	return s.mu.Unlock
+}
+
+func (s *MemoryStore) CreateUser(ctx context.Context, user *User) error {
+	defer s.lock()()
+
+	user.Email = NormalizeEmail(user.Email)
+	if s.data.emailTaken(user.Email, 0) {
+		return ErrEmailTaken
+	}
+
+	now := storeNow()
+	user.CreatedAt = now
+	user.UpdatedAt = now
+	user.IsActive = true
+	if user.Language == "" {
+		user.Language = "en"
+	}
+
+	s.data.lastUserID++
+	user.ID = s.data.lastUserID
+	user.Version = 1
+
+	stored := cloneUser(user)
+	stored.AvatarURLs = nil
+	stored.DeactivatedAt = nil
+	stored.AnonymizedAt = nil
+	s.data.users[user.ID] = stored
+	return nil
+}
+
+func (s *MemoryStore) GetUserByID(ctx context.Context, id int) (*User, error) {
+	defer s.lock()()
+
+	user, ok := s.data.users[id]
+	if !ok || !user.IsActive {
+		return nil, ErrUserNotFound
+	}
+	return cloneUser(user), nil
+}
+
+func (s *MemoryStore) GetUserByIDIncludingInactive(ctx context.Context, id int) (*User, error) {
+	defer s.lock()()
+	return s.data.getUser(id)
+}
+
+// GetUserByEmail finds an active user by email, ignoring case
+func (s *MemoryStore) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	defer s.lock()()
+
+	email = NormalizeEmail(email)
+	for _, user := range s.data.sortedUsers() {
+		if user.IsActive && strings.EqualFold(user.Email, email) {
+			return cloneUser(user), nil
+		}
+	}
+	return nil, ErrUserNotFound
+}
+
+func (s *MemoryStore) GetAllUsers(ctx context.Context) ([]User, error) {
+	defer s.lock()()
+
+	var users []User
+	for _, user := range s.data.sortedUsers() {
+		if user.IsActive {
+			users = append(users, *cloneUser(user))
+		}
+	}
+	return users, nil
+}
+
+func (s *MemoryStore) ListUsers(ctx context.Context, params ListUsersParams) (*UserPage, error) {
+	defer s.lock()()
+
+	cur, err := params.pageCursor()
+	if err != nil {
+		return nil, err
+	}
+
+	var matched []*User
+	for _, user := range s.data.sortedUsers() {
+		if matchesListing(user, params) {
+			matched = append(matched, user)
+		}
+	}
+	total := len(matched)
+
+	descending := params.Order == "desc"
+	if cur != nil && cur.Backwards {
+		descending = !descending
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		c := compareUsers(matched[i], matched[j], params.Sort)
+		if descending {
+			return c > 0
+		}
+		return c < 0
+	})
+
+	var users []User
+	skipped := 0
+	for _, user := range matched {
+		if cur != nil {
+			c, err := compareToCursor(user, cur)
+			if err != nil {
+				return nil, err
+			}
+			if (descending && c >= 0) || (!descending && c <= 0) {
+				continue
+			}
+		}
+		if skipped < params.Offset {
+			skipped++
+			continue
+		}
+		users = append(users, *cloneUser(user))
+		if len(users) > params.Limit {
+			break
+		}
+	}
+
+	page := newUserPage(users, params, cur)
+	if params.IncludeTotal {
+		page.Total = &total
+	}
+	return page, nil
+}
+
+// StreamUsers calls fn for every user matching the listing filters, in ID
+// order. The users are copied out first, so fn may use the store.
+func (s *MemoryStore) StreamUsers(ctx context.Context, params ListUsersParams, fn func(*User) error) error {
+	unlock := s.lock()
+	var users []*User
+	for _, user := range s.data.sortedUsers() {
+		if matchesListing(user, params) {
+			users = append(users, cloneUser(user))
+		}
+	}
+	unlock()
+
+	for _, user := range users {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(user); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SearchUsers finds active users matching the query as ranked by
+// searchRank
+func (s *MemoryStore) SearchUsers(ctx context.Context, query string, terms []string, limit int) ([]UserSearchResult, error) {
+	defer s.lock()()
+
+	results := []UserSearchResult{}
+	for _, user := range s.data.sortedUsers() {
+		if !user.IsActive {
+			continue
+		}
+
+		rank, ok := searchRank(user.FirstName, user.LastName, user.Email, query, terms)
+		if !ok {
+			continue
+		}
+		results = append(results, UserSearchResult{User: *cloneUser(user), Rank: rank})
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Rank > results[j].Rank
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+func (s *MemoryStore) DeleteUser(ctx context.Context, id, version int) error {
+	defer s.lock()()
+
+	user, ok := s.data.users[id]
+	if !ok || user.Version != version {
+		return ErrPreconditionFailed
+	}
+
+	now := storeNow()
+	user.IsActive = false
+	user.DeactivatedAt = &now
+	touch(user, now)
+	return nil
+}
+
+func (s *MemoryStore) UpdateUser(ctx context.Context, user *User) error {
+	defer s.lock()()
+
+	stored, ok := s.data.users[user.ID]
+	if !ok || stored.Version != user.Version {
+		return ErrPreconditionFailed
+	}
+
+	user.Email = NormalizeEmail(user.Email)
+	if s.data.emailTaken(user.Email, user.ID) {
+		return ErrEmailTaken
+	}
+
+	stored.Email = user.Email
+	stored.FirstName = user.FirstName
+	stored.LastName = user.LastName
+	stored.Country = user.Country
+	stored.Language = user.Language
+	stored.Attributes = cloneAttributes(user.Attributes)
+	stored.ExternalID = user.ExternalID
+	touch(stored, storeNow())
+
+	user.Version = stored.Version
+	user.UpdatedAt = stored.UpdatedAt
+	return nil
+}
+
+func (s *MemoryStore) SetUserActive(ctx context.Context, id int, active bool) error {
+	defer s.lock()()
+
+	user, ok := s.data.users[id]
+	if !ok {
+		return nil
+	}
+
+	now := storeNow()
+	user.IsActive = active
+	if active {
+		user.DeactivatedAt = nil
+	} else if user.DeactivatedAt == nil {
+		user.DeactivatedAt = &now
+	}
+	touch(user, now)
+	return nil
+}
+
+// PurgeUser permanently deletes a deactivated user along with its history.
+// Privacy requests are kept without the user ID.
+func (s *MemoryStore) PurgeUser(ctx context.Context, id int) error {
+	defer s.lock()()
+
+	user, ok := s.data.users[id]
+	if !ok || user.IsActive {
+		return ErrUserNotFound
+	}
+	s.data.deleteUsers(id)
+	return nil
+}
+
+func (s *MemoryStore) AnonymizeUser(ctx context.Context, id int) error {
+	defer s.lock()()
+
+	user, ok := s.data.users[id]
+	if !ok || user.IsActive {
+		return ErrUserNotFound
+	}
+	anonymize(user, storeNow())
+	return nil
+}
+
+func (s *MemoryStore) PurgeDeactivatedBefore(ctx context.Context, cutoff time.Time) ([]int, error) {
+	defer s.lock()()
+
+	var ids []int
+	for _, user := range s.data.sortedUsers() {
+		if !user.IsActive && user.DeactivatedAt != nil && user.DeactivatedAt.Before(cutoff) {
+			ids = append(ids, user.ID)
+		}
+	}
+	s.data.deleteUsers(ids...)
+	return ids, nil
+}
+
+func (s *MemoryStore) AnonymizeDeactivatedBefore(ctx context.Context, cutoff time.Time) ([]int, error) {
+	defer s.lock()()
+
+	now := storeNow()
+	var ids []int
+	for _, user := range s.data.sortedUsers() {
+		if !user.IsActive && user.DeactivatedAt != nil && user.DeactivatedAt.Before(cutoff) &&
+			user.AnonymizedAt == nil {
+			anonymize(user, now)
+			ids = append(ids, user.ID)
+		}
+	}
+	return ids, nil
+}
+
+func (s *MemoryStore) SetUserAvatar(ctx context.Context, id, version int, urls AvatarURLs) error {
+	defer s.lock()()
+
+	user, ok := s.data.users[id]
+	if !ok || user.Version != version {
+		return ErrPreconditionFailed
+	}
+	user.AvatarURLs = cloneAvatarURLs(urls)
+	touch(user, storeNow())
+	return nil
+}
+
+func (s *MemoryStore) UpdatePassword(ctx context.Context, id int, passwordHash string) error {
+	defer s.lock()()
+
+	user, ok := s.data.users[id]
+	if !ok {
+		return nil
+	}
+	user.Password = passwordHash
+	touch(user, storeNow())
+	return nil
+}
+
+func (s *MemoryStore) GetUserGroups(ctx context.Context, id int) ([]GroupMembership, error) {
+	return []GroupMembership{}, nil
+}
+
+func (s *MemoryStore) GetGroupsForUsers(ctx context.Context, ids []int) (map[int][]GroupMembership, error) {
+	groups := make(map[int][]GroupMembership, len(ids))
+	for _, id := range ids {
+		groups[id] = []GroupMembership{}
+	}
+	return groups, nil
+}
+
+func (s *MemoryStore) RemoveUserFromGroups(ctx context.Context, id int) error {
+	return nil
+}
+
+func (s *MemoryStore) CreatePrivacyRequest(ctx context.Context, req *PrivacyRequest) error {
+	defer s.lock()()
+
+	if req.Kind != PrivacyRequestExport && req.Kind != PrivacyRequestErasure {
+		return fmt.Errorf("failed to record privacy request: invalid kind %q", req.Kind)
+	}
+	if _, ok := s.data.users[req.UserID]; !ok {
+		return fmt.Errorf("failed to record privacy request: user %d does not exist", req.UserID)
+	}
+
+	s.data.lastRequestID++
+	req.ID = s.data.lastRequestID
+	req.CreatedAt = storeNow()
+	s.data.privacyRequests = append(s.data.privacyRequests, *req)
+	return nil
+}
+
+func (s *MemoryStore) GetPrivacyRequests(ctx context.Context, userID int) ([]PrivacyRequest, error) {
+	defer s.lock()()
+
+	requests := []PrivacyRequest{}
+	for _, req := range s.data.privacyRequests {
+		if req.UserID == userID {
+			requests = append(requests, req)
+		}
+	}
+	return requests, nil
+}
+
+// RecordUserChange stores a history entry for the user's current state,
+// diffed against before. before is nil for a new user.
+func (s *MemoryStore) RecordUserChange(ctx context.Context, action string, before *User, id int, actor Actor) error {
+	defer s.lock()()
+
+	after, err := s.data.getUser(id)
+	if err != nil {
+		return err
+	}
+
+	snapshot := snapshotOf(after)
+	var previous *UserSnapshot
+	if before != nil {
+		snap := snapshotOf(before)
+		previous = &snap
+	}
+
+	changes, err := json.Marshal(diffSnapshots(previous, snapshot))
+	if err != nil {
+		return fmt.Errorf("failed to encode changes: %w", err)
+	}
+	snapshotJSON, err := json.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("failed to encode snapshot: %w", err)
+	}
+
+	if actor.Source == "" {
+		actor.Source = SourceSystem
+	}
+
+	s.data.lastChangeID++
+	s.data.changes = append(s.data.changes, memoryChange{
+		UserChange: UserChange{
+			ID:        s.data.lastChangeID,
+			UserID:    id,
+			Version:   after.Version,
+			Action:    action,
+			Actor:     actor,
+			CreatedAt: storeNow(),
+		},
+		changes:  changes,
+		snapshot: snapshotJSON,
+	})
+	return nil
+}
+
+// ListUserChanges returns up to limit history entries for the user, newest
+// first, starting below beforeID when it is non-zero
+func (s *MemoryStore) ListUserChanges(ctx context.Context, userID, limit int, beforeID int64) ([]UserChange, error) {
+	defer s.lock()()
+
+	changes := []UserChange{}
+	for i := len(s.data.changes) - 1; i >= 0 && len(changes) < limit; i-- {
+		stored := s.data.changes[i]
+		if stored.UserID != userID || (beforeID != 0 && stored.ID >= beforeID) {
+			continue
+		}
+
+		change := stored.UserChange
+		if err := json.Unmarshal(stored.changes, &change.Changes); err != nil {
+			return nil, fmt.Errorf("invalid change: %w", err)
+		}
+		if err := json.Unmarshal(stored.snapshot, &change.Snapshot); err != nil {
+			return nil, fmt.Errorf("invalid snapshot: %w", err)
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+// GetUserSnapshot returns the user's state as of the given version, taken
+// from the latest change at or before it
+func (s *MemoryStore) GetUserSnapshot(ctx context.Context, userID, version int) (*UserSnapshot, error) {
+	defer s.lock()()
+
+	var latest *memoryChange
+	for i := range s.data.changes {
+		change := &s.data.changes[i]
+		if change.UserID != userID || change.Version > version {
+			continue
+		}
+		if latest == nil || change.Version >= latest.Version {
+			latest = change
+		}
+	}
+	if latest == nil {
+		return nil, NotFound("version_not_found", "no history recorded for version %d", version)
+	}
+
+	snapshot := &UserSnapshot{}
+	if err := json.Unmarshal(latest.snapshot, snapshot); err != nil {
+		return nil, fmt.Errorf("invalid snapshot: %w", err)
+	}
+	return snapshot, nil
+}
+
+// DeleteUserChanges removes the history of the given users, which holds
+// their previous personal data
+func (s *MemoryStore) DeleteUserChanges(ctx context.Context, ids ...int) error {
+	defer s.lock()()
+	s.data.deleteChanges(ids...)
+	return nil
+}
+
+// GetAttributeSchema returns the stored attribute schema, or nil if none
+// has been saved
+func (s *MemoryStore) GetAttributeSchema(ctx context.Context) ([]byte, error) {
+	defer s.lock()()
+
+	if s.data.attributeSchema == nil {
+		return nil, nil
+	}
+	return append([]byte(nil), s.data.attributeSchema...), nil
+}
+
+func (s *MemoryStore) SaveAttributeSchema(ctx context.Context, schema []byte) error {
+	defer s.lock()()
+
+	s.data.attributeSchema = append([]byte{}, schema...)
+	return nil
+}
+
+func (d *memoryData) clone() *memoryData {
+	c := *d
+	c.users = make(map[int]*User, len(d.users))
+	for id, user := range d.users {
+		c.users[id] = cloneUser(user)
+	}
+	// Entries are never modified in place, only added and removed
+	c.changes = append([]memoryChange(nil), d.changes...)
+	c.privacyRequests = append([]PrivacyRequest(nil), d.privacyRequests...)
+	return &c
+}
+
+func (d *memoryData) getUser(id int) (*User, error) {
+	user, ok := d.users[id]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	return cloneUser(user), nil
+}
+
+// sortedUsers returns the stored users in ID order
+func (d *memoryData) sortedUsers() []*User {
+	users := make([]*User, 0, len(d.users))
+	for _, user := range d.users {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return users
+}
+
+// emailTaken reports whether a user other than exceptID has the email
+func (d *memoryData) emailTaken(email string, exceptID int) bool {
+	for _, user := range d.users {
+		if user.ID != exceptID && strings.EqualFold(user.Email, email) {
+			return true
+		}
+	}
+	return false
+}
+
+// deleteUsers removes users and their history, and detaches their privacy
+// requests
+func (d *memoryData) deleteUsers(ids ...int) {
+	for _, id := range ids {
+		delete(d.users, id)
+	}
+	d.deleteChanges(ids...)
+
+	deleted := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		deleted[id] = true
+	}
+	requests := make([]PrivacyRequest, len(d.privacyRequests))
+	for i, req := range d.privacyRequests {
+		if deleted[req.UserID] {
+			req.UserID = 0
+		}
+		requests[i] = req
+	}
+	d.privacyRequests = requests
+}
+
+func (d *memoryData) deleteChanges(ids ...int) {
+	deleted := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		deleted[id] = true
+	}
+
+	var kept []memoryChange
+	for _, change := range d.changes {
+		if !deleted[change.UserID] {
+			kept = append(kept, change)
+		}
+	}
+	d.changes = kept
+}
+
+// touch records an update of a stored user
+func touch(user *User, now time.Time) {
+	user.UpdatedAt = now
+	user.Version++
+}
+
+// anonymize replaces a stored user's personal data like anonymizeAssignments
+func anonymize(user *User, now time.Time) {
+	user.Email = fmt.Sprintf("deleted-%d@anonymized.invalid", user.ID)
+	user.FirstName = "Deleted"
+	user.LastName = "User"
+	user.Password = ""
+	user.Attributes = Attributes{}
+	user.AvatarURLs = nil
+	user.ExternalID = ""
+	user.AnonymizedAt = &now
+	touch(user, now)
+}
+
+// cloneUser copies a user so that stored users can't be changed through
+// the values handed to callers
+func cloneUser(user *User) *User {
+	c := *user
+	c.Attributes = cloneAttributes(user.Attributes)
+	c.AvatarURLs = cloneAvatarURLs(user.AvatarURLs)
+	c.AvatarURL = c.AvatarURLs[DefaultAvatarSize]
+	if user.DeactivatedAt != nil {
+		t := *user.DeactivatedAt
+		c.DeactivatedAt = &t
+	}
+	if user.AnonymizedAt != nil {
+		t := *user.AnonymizedAt
+		c.AnonymizedAt = &t
+	}
+	return &c
+}
+
+// cloneAttributes deep-copies attributes through JSON, which also gives
+// values the types they have when read back from the database
+func cloneAttributes(attrs Attributes) Attributes {
+	c := Attributes{}
+	data, err := json.Marshal(attrs.orEmpty())
+	if err == nil {
+		json.Unmarshal(data, &c)
+	}
+	return c
+}
+
+func cloneAvatarURLs(urls AvatarURLs) AvatarURLs {
+	if urls == nil {
+		return nil
+	}
+	c := make(AvatarURLs, len(urls))
+	for size, url := range urls {
+		c[size] = url
+	}
+	return c
+}
+
+// matchesListing applies the listing filters of userFilterClause
+func matchesListing(user *User, params ListUsersParams) bool {
+	if params.Active != nil && user.IsActive != *params.Active {
+		return false
+	}
+	if params.Email != "" && !strings.EqualFold(user.Email, NormalizeEmail(params.Email)) {
+		return false
+	}
+	if params.ExternalID != "" && user.ExternalID != params.ExternalID {
+		return false
+	}
+	if params.Country != "" && user.Country != params.Country {
+		return false
+	}
+	if params.Language != "" && user.Language != params.Language {
+		return false
+	}
+	if params.CreatedAfter != nil && user.CreatedAt.Before(*params.CreatedAfter) {
+		return false
+	}
+	if params.CreatedBefore != nil && !user.CreatedAt.Before(*params.CreatedBefore) {
+		return false
+	}
+	if len(params.Attributes) > 0 {
+		want := cloneAttributes(params.Attributes)
+		if !jsonContains(map[string]interface{}(user.Attributes), map[string]interface{}(want)) {
+			return false
+		}
+	}
+	return true
+}
+
+// compareUsers orders users by the sort column, then by ID
+func compareUsers(a, b *User, sortBy string) int {
+	var c int
+	switch sortBy {
+	case "created_at":
+		c = a.CreatedAt.Compare(b.CreatedAt)
+	case "email", "first_name", "last_name":
+		c = strings.Compare(cursorValue(a, sortBy), cursorValue(b, sortBy))
+	}
+	if c != 0 {
+		return c
+	}
+	return compareInts(a.ID, b.ID)
+}
+
+// compareToCursor orders a user relative to the position of a cursor
+func compareToCursor(user *User, cur *cursor) (int, error) {
+	var c int
+	switch cur.Sort {
+	case "created_at":
+		t, err := time.Parse(time.RFC3339Nano, cur.Value)
+		if err != nil {
+			return 0, Validation("invalid_cursor", "invalid cursor")
+		}
+		c = user.CreatedAt.Compare(t)
+	case "email", "first_name", "last_name":
+		c = strings.Compare(cursorValue(user, cur.Sort), cur.Value)
+	}
+	if c != 0 {
+		return c, nil
+	}
+	return compareInts(user.ID, cur.ID), nil
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,14 @@
+package auth_test
+
+import (
+	"testing"
+
+	"goAPI/auth"
+	"goAPI/auth/storetest"
+)
+
+func TestMemoryStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) auth.UserStore {
+		return auth.NewMemoryStore()
+	})
+}
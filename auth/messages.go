@@ -0,0 +1,264 @@
+package auth
+
+// validationCatalog translates the messages of the custom validation tags;
+// {0} is the field name
+var validationCatalog = map[string]map[string]string{
+	"en": {
+		"iso_country":  "{0} must be an ISO 3166-1 alpha-2 country code",
+		"iso_language": "{0} must be a language code such as en or pt-BR",
+	},
+	"es": {
+		"iso_country":  "{0} debe ser un código de país ISO 3166-1 alfa-2",
+		"iso_language": "{0} debe ser un código de idioma como en o pt-BR",
+	},
+	"fr": {
+		"iso_country":  "{0} doit être un code pays ISO 3166-1 alpha-2",
+		"iso_language": "{0} doit être un code de langue tel que en ou pt-BR",
+	},
+	"de": {
+		"iso_country":  "{0} muss ein Ländercode nach ISO 3166-1 Alpha-2 sein",
+		"iso_language": "{0} muss ein Sprachcode wie en oder pt-BR sein",
+	},
+}
+
+// invalidFieldCatalog describes a failed rule that has no message of its
+// own; {0} is the field name
+var invalidFieldCatalog = map[string]string{
+	"en": "{0} is invalid",
+	"es": "{0} no es válido",
+	"fr": "{0} n'est pas valide",
+	"de": "{0} ist ungültig",
+}
+
+// messageCatalog translates API error messages, keyed by the English
+// message. Messages that aren't listed are returned in English; that is
+// deliberate for messages passed through as "%v" (JSON Patch and attribute
+// schema errors) and the field messages of attribute validation, whose
+// text comes from the document being checked.
+var messageCatalog = map[string]map[string]string{
+	"es": {
+		"internal server error":                                        "error interno del servidor",
+		"request timed out":                                            "la solicitud ha excedido el tiempo de espera",
+		"Authorization header required":                                "se requiere la cabecera Authorization",
+		"Invalid token":                                                "token no válido",
+		"Admin access required":                                        "se requiere acceso de administrador",
+		"Validation failed":                                            "La validación ha fallado",
+		"Invalid request body":                                         "Cuerpo de la solicitud no válido",
+		"user not found":                                               "usuario no encontrado",
+		"invalid credentials":                                          "credenciales no válidas",
+		"invalid refresh token":                                        "token de actualización no válido",
+		"If-Match header required":                                     "se requiere la cabecera If-Match",
+		"Unsupported patch content type":                               "tipo de contenido de parche no admitido",
+		"user has been modified by another request":                    "el usuario ha sido modificado por otra solicitud",
+		"user with this email already exists":                          "ya existe un usuario con este correo electrónico",
+		"user is already active":                                       "el usuario ya está activo",
+		"anonymized users cannot be restored":                          "los usuarios anonimizados no se pueden restaurar",
+		"only deactivated users can be purged":                         "solo se pueden eliminar usuarios desactivados",
+		"user has already been erased":                                 "el usuario ya ha sido borrado",
+		"invalid cursor":                                               "cursor no válido",
+		"search query must contain letters or digits":                  "la búsqueda debe contener letras o dígitos",
+		"multipart field avatar is required":                           "se requiere el campo multipart avatar",
+		"no attribute schema has been configured":                      "no se ha configurado ningún esquema de atributos",
+		"duplicate of row %d":                                          "duplicado de la fila %d",
+		"attribute %s cannot be used as a filter":                      "el atributo %s no se puede usar como filtro",
+		"attribute %s must be a boolean":                               "el atributo %s debe ser un booleano",
+		"attribute %s must be a number":                                "el atributo %s debe ser un número",
+		"avatar must be at most %d bytes":                              "el avatar debe tener como máximo %d bytes",
+		"avatar must be at most %dx%d pixels":                          "el avatar debe tener como máximo %dx%d píxeles",
+		"batch exceeds %d operations":                                  "el lote supera las %d operaciones",
+		"import exceeds %d rows":                                       "la importación supera las %d filas",
+		"import must be at most %d bytes":                              "la importación debe tener como máximo %d bytes",
+		"invalid %s %q":                                                "%s no válido: %q",
+		"invalid CSV header: %v":                                       "cabecera CSV no válida: %v",
+		"invalid active filter %q":                                     "filtro active no válido: %q",
+		"invalid attribute name %q":                                    "nombre de atributo no válido: %q",
+		"invalid attributes: %s":                                       "atributos no válidos: %s",
+		"invalid dry_run %q":                                           "dry_run no válido: %q",
+		"invalid image: %v":                                            "imagen no válida: %v",
+		"invalid import mode %q":                                       "modo de importación no válido: %q",
+		"invalid include_total %q":                                     "include_total no válido: %q",
+		"invalid limit %q":                                             "límite no válido: %q",
+		"invalid operation data: %v":                                   "datos de operación no válidos: %v",
+		"invalid sort field %q":                                        "campo de ordenación no válido: %q",
+		"invalid sort order %q":                                        "orden no válido: %q",
+		"no history recorded for version %d":                           "no hay historial registrado para la versión %d",
+		"search query must be at least %d characters":                  "la búsqueda debe tener al menos %d caracteres",
+		"unknown %s %q":                                                "%s desconocido: %q",
+		"unknown CSV column %q":                                        "columna CSV desconocida: %q",
+		"unknown attribute %q":                                         "atributo desconocido: %q",
+		"unknown batch operation %q":                                   "operación de lote desconocida: %q",
+		"unknown export column %q":                                     "columna de exportación desconocida: %q",
+		"unsupported avatar type %s":                                   "tipo de avatar no admitido: %s",
+		"unsupported export format %q":                                 "formato de exportación no admitido: %q",
+		"unsupported import format %q":                                 "formato de importación no admitido: %q",
+		"version %d is not an earlier version of the user":             "la versión %d no es una versión anterior del usuario",
+		"Access to this user is not allowed":                           "No se permite el acceso a este usuario",
+		"Idempotency-Key must be 1 to 255 printable characters":        "Idempotency-Key debe tener entre 1 y 255 caracteres imprimibles",
+		"Idempotency-Key requires a bearer token":                      "Idempotency-Key requiere un token bearer",
+		"Idempotency-Key was already used for a different request":     "Idempotency-Key ya se usó para otra solicitud",
+		"Invalid authorization header format":                          "Formato de la cabecera Authorization no válido",
+		"a request with this Idempotency-Key is still being processed": "una solicitud con esta Idempotency-Key aún se está procesando",
+		"anonymized users cannot be reverted":                          "los usuarios anonimizados no se pueden revertir",
+		"batch contains no operations":                                 "el lote no contiene operaciones",
+		"created_after must be before created_before":                  "created_after debe ser anterior a created_before",
+		"cursor does not match the requested sort":                     "el cursor no coincide con el orden solicitado",
+		"import contains no rows":                                      "la importación no contiene filas",
+		"import file is empty":                                         "el archivo de importación está vacío",
+		"import requires text/csv or application/x-ndjson":             "la importación requiere text/csv o application/x-ndjson",
+		"invalid image: empty":                                         "imagen no válida: vacía",
+		"only active users can be listed":                              "solo se pueden listar usuarios activos",
+		"operation data is required":                                   "los datos de la operación son obligatorios",
+		"request body must be at most %d bytes":                        "el cuerpo de la solicitud no puede superar los %d bytes",
+		"rolled back because operation %d failed":                      "revertida porque la operación %d falló",
+	},
+	"fr": {
+		"internal server error":                                        "erreur interne du serveur",
+		"request timed out":                                            "la requête a expiré",
+		"Authorization header required":                                "en-tête Authorization requis",
+		"Invalid token":                                                "jeton invalide",
+		"Admin access required":                                        "accès administrateur requis",
+		"Validation failed":                                            "La validation a échoué",
+		"Invalid request body":                                         "Corps de requête invalide",
+		"user not found":                                               "utilisateur introuvable",
+		"invalid credentials":                                          "identifiants invalides",
+		"invalid refresh token":                                        "jeton de rafraîchissement invalide",
+		"If-Match header required":                                     "l'en-tête If-Match est requis",
+		"Unsupported patch content type":                               "type de contenu de patch non pris en charge",
+		"user has been modified by another request":                    "l'utilisateur a été modifié par une autre requête",
+		"user with this email already exists":                          "un utilisateur avec cette adresse e-mail existe déjà",
+		"user is already active":                                       "l'utilisateur est déjà actif",
+		"anonymized users cannot be restored":                          "les utilisateurs anonymisés ne peuvent pas être restaurés",
+		"only deactivated users can be purged":                         "seuls les utilisateurs désactivés peuvent être purgés",
+		"user has already been erased":                                 "l'utilisateur a déjà été effacé",
+		"invalid cursor":                                               "curseur invalide",
+		"search query must contain letters or digits":                  "la recherche doit contenir des lettres ou des chiffres",
+		"multipart field avatar is required":                           "le champ multipart avatar est requis",
+		"no attribute schema has been configured":                      "aucun schéma d'attributs n'a été configuré",
+		"duplicate of row %d":                                          "doublon de la ligne %d",
+		"attribute %s cannot be used as a filter":                      "l'attribut %s ne peut pas servir de filtre",
+		"attribute %s must be a boolean":                               "l'attribut %s doit être un booléen",
+		"attribute %s must be a number":                                "l'attribut %s doit être un nombre",
+		"avatar must be at most %d bytes":                              "l'avatar doit faire au plus %d octets",
+		"avatar must be at most %dx%d pixels":                          "l'avatar doit faire au plus %dx%d pixels",
+		"batch exceeds %d operations":                                  "le lot dépasse %d opérations",
+		"import exceeds %d rows":                                       "l'import dépasse %d lignes",
+		"import must be at most %d bytes":                              "l'import doit faire au plus %d octets",
+		"invalid %s %q":                                                "%s invalide : %q",
+		"invalid CSV header: %v":                                       "en-tête CSV invalide : %v",
+		"invalid active filter %q":                                     "filtre active invalide : %q",
+		"invalid attribute name %q":                                    "nom d'attribut invalide : %q",
+		"invalid attributes: %s":                                       "attributs invalides : %s",
+		"invalid dry_run %q":                                           "dry_run invalide : %q",
+		"invalid image: %v":                                            "image invalide : %v",
+		"invalid import mode %q":                                       "mode d'import invalide : %q",
+		"invalid include_total %q":                                     "include_total invalide : %q",
+		"invalid limit %q":                                             "limite invalide : %q",
+		"invalid operation data: %v":                                   "données d'opération invalides : %v",
+		"invalid sort field %q":                                        "champ de tri invalide : %q",
+		"invalid sort order %q":                                        "ordre de tri invalide : %q",
+		"no history recorded for version %d":                           "aucun historique enregistré pour la version %d",
+		"search query must be at least %d characters":                  "la recherche doit contenir au moins %d caractères",
+		"unknown %s %q":                                                "%s inconnu : %q",
+		"unknown CSV column %q":                                        "colonne CSV inconnue : %q",
+		"unknown attribute %q":                                         "attribut inconnu : %q",
+		"unknown batch operation %q":                                   "opération de lot inconnue : %q",
+		"unknown export column %q":                                     "colonne d'export inconnue : %q",
+		"unsupported avatar type %s":                                   "type d'avatar non pris en charge : %s",
+		"unsupported export format %q":                                 "format d'export non pris en charge : %q",
+		"unsupported import format %q":                                 "format d'import non pris en charge : %q",
+		"version %d is not an earlier version of the user":             "la version %d n'est pas une version antérieure de l'utilisateur",
+		"Access to this user is not allowed":                           "L'accès à cet utilisateur n'est pas autorisé",
+		"Idempotency-Key must be 1 to 255 printable characters":        "Idempotency-Key doit contenir de 1 à 255 caractères imprimables",
+		"Idempotency-Key requires a bearer token":                      "Idempotency-Key nécessite un jeton bearer",
+		"Idempotency-Key was already used for a different request":     "Idempotency-Key a déjà été utilisée pour une autre requête",
+		"Invalid authorization header format":                          "Format de l'en-tête Authorization invalide",
+		"a request with this Idempotency-Key is still being processed": "une requête avec cette Idempotency-Key est encore en cours de traitement",
+		"anonymized users cannot be reverted":                          "les utilisateurs anonymisés ne peuvent pas être rétablis",
+		"batch contains no operations":                                 "le lot ne contient aucune opération",
+		"created_after must be before created_before":                  "created_after doit précéder created_before",
+		"cursor does not match the requested sort":                     "le curseur ne correspond pas au tri demandé",
+		"import contains no rows":                                      "l'importation ne contient aucune ligne",
+		"import file is empty":                                         "le fichier d'importation est vide",
+		"import requires text/csv or application/x-ndjson":             "l'importation nécessite text/csv ou application/x-ndjson",
+		"invalid image: empty":                                         "image invalide : vide",
+		"only active users can be listed":                              "seuls les utilisateurs actifs peuvent être listés",
+		"operation data is required":                                   "les données de l'opération sont obligatoires",
+		"request body must be at most %d bytes":                        "le corps de la requête ne doit pas dépasser %d octets",
+		"rolled back because operation %d failed":                      "annulée car l'opération %d a échoué",
+	},
+	"de": {
+		"internal server error":                                        "interner Serverfehler",
+		"request timed out":                                            "Zeitüberschreitung der Anfrage",
+		"Authorization header required":                                "Authorization-Header erforderlich",
+		"Invalid token":                                                "ungültiges Token",
+		"Admin access required":                                        "Administratorzugriff erforderlich",
+		"Validation failed":                                            "Validierung fehlgeschlagen",
+		"Invalid request body":                                         "Ungültiger Anfrageinhalt",
+		"user not found":                                               "Benutzer nicht gefunden",
+		"invalid credentials":                                          "ungültige Anmeldedaten",
+		"invalid refresh token":                                        "ungültiges Aktualisierungstoken",
+		"If-Match header required":                                     "If-Match-Header erforderlich",
+		"Unsupported patch content type":                               "nicht unterstützter Patch-Inhaltstyp",
+		"user has been modified by another request":                    "der Benutzer wurde durch eine andere Anfrage geändert",
+		"user with this email already exists":                          "ein Benutzer mit dieser E-Mail-Adresse existiert bereits",
+		"user is already active":                                       "der Benutzer ist bereits aktiv",
+		"anonymized users cannot be restored":                          "anonymisierte Benutzer können nicht wiederhergestellt werden",
+		"only deactivated users can be purged":                         "nur deaktivierte Benutzer können endgültig gelöscht werden",
+		"user has already been erased":                                 "der Benutzer wurde bereits gelöscht",
+		"invalid cursor":                                               "ungültiger Cursor",
+		"search query must contain letters or digits":                  "die Suche muss Buchstaben oder Ziffern enthalten",
+		"multipart field avatar is required":                           "das Multipart-Feld avatar ist erforderlich",
+		"no attribute schema has been configured":                      "es wurde kein Attributschema konfiguriert",
+		"duplicate of row %d":                                          "Duplikat von Zeile %d",
+		"attribute %s cannot be used as a filter":                      "das Attribut %s kann nicht als Filter verwendet werden",
+		"attribute %s must be a boolean":                               "das Attribut %s muss ein boolescher Wert sein",
+		"attribute %s must be a number":                                "das Attribut %s muss eine Zahl sein",
+		"avatar must be at most %d bytes":                              "der Avatar darf höchstens %d Bytes groß sein",
+		"avatar must be at most %dx%d pixels":                          "der Avatar darf höchstens %dx%d Pixel groß sein",
+		"batch exceeds %d operations":                                  "der Stapel überschreitet %d Operationen",
+		"import exceeds %d rows":                                       "der Import überschreitet %d Zeilen",
+		"import must be at most %d bytes":                              "der Import darf höchstens %d Bytes groß sein",
+		"invalid %s %q":                                                "ungültiges %s: %q",
+		"invalid CSV header: %v":                                       "ungültige CSV-Kopfzeile: %v",
+		"invalid active filter %q":                                     "ungültiger active-Filter: %q",
+		"invalid attribute name %q":                                    "ungültiger Attributname: %q",
+		"invalid attributes: %s":                                       "ungültige Attribute: %s",
+		"invalid dry_run %q":                                           "ungültiges dry_run: %q",
+		"invalid image: %v":                                            "ungültiges Bild: %v",
+		"invalid import mode %q":                                       "ungültiger Importmodus: %q",
+		"invalid include_total %q":                                     "ungültiges include_total: %q",
+		"invalid limit %q":                                             "ungültiges Limit: %q",
+		"invalid operation data: %v":                                   "ungültige Operationsdaten: %v",
+		"invalid sort field %q":                                        "ungültiges Sortierfeld: %q",
+		"invalid sort order %q":                                        "ungültige Sortierreihenfolge: %q",
+		"no history recorded for version %d":                           "für Version %d ist kein Verlauf gespeichert",
+		"search query must be at least %d characters":                  "die Suche muss mindestens %d Zeichen lang sein",
+		"unknown %s %q":                                                "unbekanntes %s: %q",
+		"unknown CSV column %q":                                        "unbekannte CSV-Spalte: %q",
+		"unknown attribute %q":                                         "unbekanntes Attribut: %q",
+		"unknown batch operation %q":                                   "unbekannte Stapeloperation: %q",
+		"unknown export column %q":                                     "unbekannte Exportspalte: %q",
+		"unsupported avatar type %s":                                   "nicht unterstützter Avatartyp: %s",
+		"unsupported export format %q":                                 "nicht unterstütztes Exportformat: %q",
+		"unsupported import format %q":                                 "nicht unterstütztes Importformat: %q",
+		"version %d is not an earlier version of the user":             "Version %d ist keine frühere Version des Benutzers",
+		"Access to this user is not allowed":                           "Der Zugriff auf diesen Benutzer ist nicht erlaubt",
+		"Idempotency-Key must be 1 to 255 printable characters":        "Idempotency-Key muss aus 1 bis 255 druckbaren Zeichen bestehen",
+		"Idempotency-Key requires a bearer token":                      "Idempotency-Key erfordert ein Bearer-Token",
+		"Idempotency-Key was already used for a different request":     "Idempotency-Key wurde bereits für eine andere Anfrage verwendet",
+		"Invalid authorization header format":                          "Ungültiges Format des Authorization-Headers",
+		"a request with this Idempotency-Key is still being processed": "eine Anfrage mit diesem Idempotency-Key wird noch verarbeitet",
+		"anonymized users cannot be reverted":                          "anonymisierte Benutzer können nicht zurückgesetzt werden",
+		"batch contains no operations":                                 "der Stapel enthält keine Operationen",
+		"created_after must be before created_before":                  "created_after muss vor created_before liegen",
+		"cursor does not match the requested sort":                     "der Cursor passt nicht zur angeforderten Sortierung",
+		"import contains no rows":                                      "der Import enthält keine Zeilen",
+		"import file is empty":                                         "die Importdatei ist leer",
+		"import requires text/csv or application/x-ndjson":             "der Import erfordert text/csv oder application/x-ndjson",
+		"invalid image: empty":                                         "ungültiges Bild: leer",
+		"only active users can be listed":                              "nur aktive Benutzer können aufgelistet werden",
+		"operation data is required":                                   "die Daten der Operation sind erforderlich",
+		"request body must be at most %d bytes":                        "der Anfrageinhalt darf höchstens %d Bytes groß sein",
+		"rolled back because operation %d failed":                      "zurückgesetzt, weil Operation %d fehlgeschlagen ist",
+	},
+}
@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"strings"
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
@@ -17,6 +18,14 @@ type User struct {
 	IsActive  bool      `json:"is_active" db:"is_active"`
 	CreatedAt time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+	Version   int       `json:"-" db:"version"` // Exposed as the ETag header
+
+	Attributes    Attributes `json:"attributes" db:"attributes"`
+	AvatarURL     string     `json:"avatar_url,omitempty" db:"-"` // The DefaultAvatarSize rendition
+	AvatarURLs    AvatarURLs `json:"avatar_urls,omitempty" db:"avatar_urls"`
+	DeactivatedAt *time.Time `json:"deactivated_at,omitempty" db:"deactivated_at"`
+	AnonymizedAt  *time.Time `json:"anonymized_at,omitempty" db:"anonymized_at"`
+	ExternalID    string     `json:"external_id,omitempty" db:"external_id"` // Set by SCIM clients
 }
 
 type LoginRequest struct {
@@ -29,8 +38,10 @@ type CreateUserRequest struct {
 	Password  string `json:"password" validate:"required,min=8"`
 	FirstName string `json:"first_name" validate:"required"`
 	LastName  string `json:"last_name" validate:"required"`
-	Country   string `json:"country" validate:"required"`
-	Language  string `json:"language"`
+	Country   string `json:"country" validate:"required,iso_country"`
+	Language  string `json:"language" validate:"omitempty,iso_language"`
+
+	Attributes Attributes `json:"attributes,omitempty"`
 }
 
 type AuthResponse struct {
@@ -43,17 +54,58 @@ type RefreshTokenRequest struct {
 	RefreshToken string `json:"refresh_token" validate:"required"`
 }
 
+// UpdateUserRequest is a partial update: fields left out of the request
+// (nil) keep their current value
 type UpdateUserRequest struct {
-	ID        int    `json:"id" validate:"required"`
-	Email     string `json:"email"`
-	FirstName string `json:"first_name"`
-	LastName  string `json:"last_name"`
-	Country   string `json:"country"`
-	Language  string `json:"language"`
+	ID              int     `json:"id" validate:"required"`
+	Email           *string `json:"email" validate:"omitnil,email"`
+	FirstName       *string `json:"first_name" validate:"omitnil,min=1"`
+	LastName        *string `json:"last_name" validate:"omitnil,min=1"`
+	Country         *string `json:"country" validate:"omitnil,iso_country"`
+	Language        *string `json:"language" validate:"omitnil,iso_language"`
+	ExpectedVersion *int    `json:"-"` // From If-Match; nil skips the check
+
+	// Attributes replaces all custom attributes; nil leaves them unchanged
+	Attributes Attributes `json:"attributes"`
 }
 
 type DeleteUserRequest struct {
-	ID int `json:"id" validate:"required"`
+	ID              int  `json:"id" validate:"required"`
+	ExpectedVersion *int `json:"-"`
+}
+
+// ListUsersParams controls filtering, sorting and keyset pagination of
+// user listings
+type ListUsersParams struct {
+	Limit         int
+	Cursor        string
+	Country       string
+	Language      string
+	Active        *bool // nil lists active and deactivated users
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	Sort          string
+	Order         string
+	IncludeTotal  bool
+	Email         string // Exact match, ignoring case
+	ExternalID    string
+	Offset        int // Rows to skip, for clients that page by position
+
+	// Attributes filters on custom attribute values by exact match
+	Attributes map[string]interface{}
+}
+
+type UserPage struct {
+	Data       []User `json:"data"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	PrevCursor string `json:"prev_cursor,omitempty"`
+	Total      *int   `json:"total,omitempty"`
+}
+
+type UserSearchResult struct {
+	User       User              `json:"user"`
+	Rank       float64           `json:"rank"`
+	Highlights map[string]string `json:"highlights,omitempty"`
 }
 
 // HashPassword hashes a plain text password
@@ -71,3 +123,34 @@ func (u *User) CheckPassword(password string) bool {
 	err := bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(password))
 	return err == nil
 }
+
+// NormalizeEmail returns the form emails are stored and compared in
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+const (
+	PrivacyRequestExport  = "export"
+	PrivacyRequestErasure = "erasure"
+)
+
+// PrivacyRequest records a data subject request made for a user
+type PrivacyRequest struct {
+	ID          int       `json:"id" db:"id"`
+	UserID      int       `json:"user_id" db:"user_id"`
+	Kind        string    `json:"kind" db:"kind"`
+	RequestedBy string    `json:"requested_by" db:"requested_by"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+}
+
+// GroupMembership is a group the user belongs to
+type GroupMembership struct {
+	ID          int    `json:"id"`
+	DisplayName string `json:"display_name"`
+}
+
+// storeNow returns the current time rounded to the microsecond precision
+// databases store timestamps with
+func storeNow() time.Time {
+	return time.Now().Round(time.Microsecond)
+}
@@ -0,0 +1,144 @@
+package auth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"time"
+)
+
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+// sortColumns maps the sort options accepted by the API to table columns
+var sortColumns = map[string]string{
+	"id":         "id",
+	"created_at": "created_at",
+	"email":      "email",
+	"first_name": "first_name",
+	"last_name":  "last_name",
+}
+
+// cursor marks a position in a sorted listing. It records the sort it was
+// produced for so that it cannot be replayed against a different ordering.
+type cursor struct {
+	Sort      string `json:"s"`
+	Order     string `json:"o"`
+	Value     string `json:"v"`
+	ID        int    `json:"id"`
+	Backwards bool   `json:"b,omitempty"`
+}
+
+func encodeCursor(c cursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (*cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, Validation("invalid_cursor", "invalid cursor")
+	}
+	var c cursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, Validation("invalid_cursor", "invalid cursor")
+	}
+	return &c, nil
+}
+
+// pageCursor decodes the cursor of a listing, checking that it was
+// produced for the requested sort. It returns nil for the first page.
+func (p ListUsersParams) pageCursor() (*cursor, error) {
+	if p.Cursor == "" {
+		return nil, nil
+	}
+	c, err := decodeCursor(p.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	if c.Sort != p.Sort || c.Order != p.Order {
+		return nil, Validation("invalid_cursor", "cursor does not match the requested sort")
+	}
+	return c, nil
+}
+
+// newUserPage builds a page from up to Limit+1 users read after cur, in
+// the order they were read. Backwards cursors read the listing in reverse,
+// so their rows are flipped back into listing order.
+func newUserPage(users []User, params ListUsersParams, cur *cursor) *UserPage {
+	if users == nil {
+		users = []User{}
+	}
+	backwards := cur != nil && cur.Backwards
+
+	hasMore := len(users) > params.Limit
+	if hasMore {
+		users = users[:params.Limit]
+	}
+	if backwards {
+		for i, j := 0, len(users)-1; i < j; i, j = i+1, j-1 {
+			users[i], users[j] = users[j], users[i]
+		}
+	}
+
+	page := &UserPage{Data: users}
+	if len(users) > 0 {
+		first, last := &users[0], &users[len(users)-1]
+		if backwards || hasMore {
+			page.NextCursor = encodeCursor(cursor{Sort: params.Sort, Order: params.Order,
+				Value: cursorValue(last, params.Sort), ID: last.ID})
+		}
+		if (backwards && hasMore) || (!backwards && cur != nil) {
+			page.PrevCursor = encodeCursor(cursor{Sort: params.Sort, Order: params.Order,
+				Value: cursorValue(first, params.Sort), ID: first.ID, Backwards: true})
+		}
+	}
+	return page
+}
+
+// cursorValue returns the value of the sort column for a user in the form
+// stored in cursors
+func cursorValue(user *User, sort string) string {
+	switch sort {
+	case "created_at":
+		return user.CreatedAt.UTC().Format(time.RFC3339Nano)
+	case "email":
+		return user.Email
+	case "first_name":
+		return user.FirstName
+	case "last_name":
+		return user.LastName
+	}
+	return ""
+}
+
+// normalize applies defaults and validates the listing parameters
+func (p *ListUsersParams) normalize() error {
+	if p.Limit <= 0 {
+		p.Limit = DefaultPageSize
+	}
+	if p.Limit > MaxPageSize {
+		p.Limit = MaxPageSize
+	}
+
+	if p.Sort == "" {
+		p.Sort = "id"
+	}
+	if _, ok := sortColumns[p.Sort]; !ok {
+		return Validation("invalid_parameter", "invalid sort field %q", p.Sort)
+	}
+
+	if p.Order == "" {
+		p.Order = "asc"
+	}
+	if p.Order != "asc" && p.Order != "desc" {
+		return Validation("invalid_parameter", "invalid sort order %q", p.Order)
+	}
+
+	if p.CreatedAfter != nil && p.CreatedBefore != nil && !p.CreatedAfter.Before(*p.CreatedBefore) {
+		return Validation("invalid_parameter", "created_after must be before created_before")
+	}
+
+	return nil
+}
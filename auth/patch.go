@@ -0,0 +1,347 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const (
+	MergePatchContentType = "application/merge-patch+json"
+	JSONPatchContentType  = "application/json-patch+json"
+)
+
+// editableFields are the string fields of a user that can be changed
+// through PATCH. Custom attributes can be changed as well.
+var editableFields = []string{"email", "first_name", "last_name", "country", "language"}
+
+type jsonPatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from"`
+	Value json.RawMessage `json:"value"`
+}
+
+// patchDocument returns the editable fields of a user as a JSON object
+func patchDocument(user *User) map[string]interface{} {
+	return map[string]interface{}{
+		"email":      user.Email,
+		"first_name": user.FirstName,
+		"last_name":  user.LastName,
+		"country":    user.Country,
+		"language":   user.Language,
+		"attributes": deepCopy(map[string]interface{}(user.Attributes.orEmpty())),
+	}
+}
+
+// updateRequestFromDocument turns a patched document back into an update
+// request, rejecting unknown, removed or non-string fields
+func updateRequestFromDocument(id int, doc interface{}) (UpdateUserRequest, error) {
+	req := UpdateUserRequest{ID: id}
+
+	obj, ok := doc.(map[string]interface{})
+	if !ok {
+		return req, fmt.Errorf("patched user must be a JSON object")
+	}
+
+	allowed := map[string]bool{"attributes": true}
+	for _, field := range editableFields {
+		allowed[field] = true
+	}
+	for key := range obj {
+		if !allowed[key] {
+			return req, fmt.Errorf("field %s cannot be modified", key)
+		}
+	}
+
+	targets := map[string]**string{
+		"email":      &req.Email,
+		"first_name": &req.FirstName,
+		"last_name":  &req.LastName,
+		"country":    &req.Country,
+		"language":   &req.Language,
+	}
+	for _, field := range editableFields {
+		value, ok := obj[field]
+		if !ok || value == nil {
+			return req, fmt.Errorf("field %s cannot be removed", field)
+		}
+		s, ok := value.(string)
+		if !ok {
+			return req, fmt.Errorf("field %s must be a string", field)
+		}
+		*targets[field] = &s
+	}
+
+	// Removing the attributes object clears every attribute
+	switch attrs := obj["attributes"].(type) {
+	case nil:
+		req.Attributes = Attributes{}
+	case map[string]interface{}:
+		req.Attributes = attrs
+	default:
+		return req, fmt.Errorf("field attributes must be an object")
+	}
+
+	return req, nil
+}
+
+// applyMergePatch applies an RFC 7396 JSON Merge Patch
+func applyMergePatch(doc interface{}, patch []byte) (interface{}, error) {
+	var p interface{}
+	if err := json.Unmarshal(patch, &p); err != nil {
+		return nil, fmt.Errorf("invalid merge patch: %w", err)
+	}
+	return mergePatch(doc, p), nil
+}
+
+func mergePatch(target, patch interface{}) interface{} {
+	patchObj, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	targetObj, ok := target.(map[string]interface{})
+	if !ok {
+		targetObj = map[string]interface{}{}
+	}
+
+	for key, value := range patchObj {
+		if value == nil {
+			delete(targetObj, key)
+			continue
+		}
+		targetObj[key] = mergePatch(targetObj[key], value)
+	}
+	return targetObj
+}
+
+// applyJSONPatch applies an RFC 6902 JSON Patch
+func applyJSONPatch(doc interface{}, patch []byte) (interface{}, error) {
+	var ops []jsonPatchOperation
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return nil, fmt.Errorf("invalid JSON patch: %w", err)
+	}
+
+	for i, op := range ops {
+		var err error
+		doc, err = applyJSONPatchOperation(doc, op)
+		if err != nil {
+			return nil, fmt.Errorf("patch operation %d (%s %s): %w", i, op.Op, op.Path, err)
+		}
+	}
+	return doc, nil
+}
+
+func applyJSONPatchOperation(doc interface{}, op jsonPatchOperation) (interface{}, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var value interface{}
+	if op.Op == "add" || op.Op == "replace" || op.Op == "test" {
+		if op.Value == nil {
+			return nil, fmt.Errorf("value is required")
+		}
+		if err := json.Unmarshal(op.Value, &value); err != nil {
+			return nil, fmt.Errorf("invalid value: %w", err)
+		}
+	}
+
+	switch op.Op {
+	case "add":
+		return pointerAdd(doc, path, value)
+	case "remove":
+		return pointerRemove(doc, path)
+	case "replace":
+		if _, err := pointerGet(doc, path); err != nil {
+			return nil, err
+		}
+		if len(path) == 0 {
+			return value, nil
+		}
+		doc, err = pointerRemove(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		return pointerAdd(doc, path, value)
+	case "move", "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		value, err := pointerGet(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		if op.Op == "move" {
+			if strings.HasPrefix(op.Path+"/", op.From+"/") && op.Path != op.From {
+				return nil, fmt.Errorf("cannot move a value into one of its children")
+			}
+			doc, err = pointerRemove(doc, from)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			value = deepCopy(value)
+		}
+		return pointerAdd(doc, path, value)
+	case "test":
+		current, err := pointerGet(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(current, value) {
+			return nil, fmt.Errorf("test failed")
+		}
+		return doc, nil
+	}
+
+	return nil, fmt.Errorf("unsupported operation %q", op.Op)
+}
+
+// parsePointer splits an RFC 6901 JSON Pointer into unescaped tokens
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("invalid JSON pointer %q", pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+func pointerGet(doc interface{}, path []string) (interface{}, error) {
+	node := doc
+	for _, token := range path {
+		switch n := node.(type) {
+		case map[string]interface{}:
+			child, ok := n[token]
+			if !ok {
+				return nil, fmt.Errorf("path not found")
+			}
+			node = child
+		case []interface{}:
+			i, err := arrayIndex(token, len(n)-1)
+			if err != nil {
+				return nil, err
+			}
+			node = n[i]
+		default:
+			return nil, fmt.Errorf("path not found")
+		}
+	}
+	return node, nil
+}
+
+func pointerAdd(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	return modifyAt(doc, path, func(container interface{}, key string) (interface{}, error) {
+		switch c := container.(type) {
+		case map[string]interface{}:
+			c[key] = value
+			return c, nil
+		case []interface{}:
+			i := len(c)
+			if key != "-" {
+				var err error
+				if i, err = arrayIndex(key, len(c)); err != nil {
+					return nil, err
+				}
+			}
+			c = append(c, nil)
+			copy(c[i+1:], c[i:])
+			c[i] = value
+			return c, nil
+		}
+		return nil, fmt.Errorf("path not found")
+	})
+}
+
+func pointerRemove(doc interface{}, path []string) (interface{}, error) {
+	if len(path) == 0 {
+		return nil, fmt.Errorf("cannot remove the whole document")
+	}
+	return modifyAt(doc, path, func(container interface{}, key string) (interface{}, error) {
+		switch c := container.(type) {
+		case map[string]interface{}:
+			if _, ok := c[key]; !ok {
+				return nil, fmt.Errorf("path not found")
+			}
+			delete(c, key)
+			return c, nil
+		case []interface{}:
+			i, err := arrayIndex(key, len(c)-1)
+			if err != nil {
+				return nil, err
+			}
+			return append(c[:i], c[i+1:]...), nil
+		}
+		return nil, fmt.Errorf("path not found")
+	})
+}
+
+// modifyAt walks to the parent of the last path token and lets fn change
+// it, storing the (possibly reallocated) container back into its parent
+func modifyAt(node interface{}, path []string, fn func(container interface{}, key string) (interface{}, error)) (interface{}, error) {
+	if len(path) == 1 {
+		return fn(node, path[0])
+	}
+
+	switch n := node.(type) {
+	case map[string]interface{}:
+		child, ok := n[path[0]]
+		if !ok {
+			return nil, fmt.Errorf("path not found")
+		}
+		updated, err := modifyAt(child, path[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+		n[path[0]] = updated
+		return n, nil
+	case []interface{}:
+		i, err := arrayIndex(path[0], len(n)-1)
+		if err != nil {
+			return nil, err
+		}
+		updated, err := modifyAt(n[i], path[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+		n[i] = updated
+		return n, nil
+	}
+	return nil, fmt.Errorf("path not found")
+}
+
+func arrayIndex(token string, max int) (int, error) {
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || i > max || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	return i, nil
+}
+
+func deepCopy(value interface{}) interface{} {
+	data, _ := json.Marshal(value)
+	var out interface{}
+	json.Unmarshal(data, &out)
+	return out
+}
+
+// invalidPatch reports a well-formed patch request that can't be applied
+func invalidPatch(err error) *Error {
+	return Validation("invalid_patch", "%v", err).WithStatus(http.StatusUnprocessableEntity)
+}
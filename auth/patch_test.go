@@ -0,0 +1,203 @@
+package auth
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const patchTestDocument = `{"a": {"b": "c"}, "list": ["x", "y"], "a/b": 1, "m~n": 2}`
+
+func decodeJSON(t *testing.T, data string) interface{} {
+	t.Helper()
+	var v interface{}
+	if err := json.Unmarshal([]byte(data), &v); err != nil {
+		t.Fatalf("decode %s: %v", data, err)
+	}
+	return v
+}
+
+func TestApplyJSONPatch(t *testing.T) {
+	tests := []struct {
+		name  string
+		patch string
+		want  string
+	}{
+		{
+			name:  "add member",
+			patch: `[{"op": "add", "path": "/a/d", "value": "e"}]`,
+			want:  `{"a": {"b": "c", "d": "e"}, "list": ["x", "y"], "a/b": 1, "m~n": 2}`,
+		},
+		{
+			name:  "add replaces existing member",
+			patch: `[{"op": "add", "path": "/a/b", "value": {"nested": true}}]`,
+			want:  `{"a": {"b": {"nested": true}}, "list": ["x", "y"], "a/b": 1, "m~n": 2}`,
+		},
+		{
+			name:  "add array element inserts",
+			patch: `[{"op": "add", "path": "/list/1", "value": "z"}]`,
+			want:  `{"a": {"b": "c"}, "list": ["x", "z", "y"], "a/b": 1, "m~n": 2}`,
+		},
+		{
+			name:  "add at end of array",
+			patch: `[{"op": "add", "path": "/list/-", "value": "z"}]`,
+			want:  `{"a": {"b": "c"}, "list": ["x", "y", "z"], "a/b": 1, "m~n": 2}`,
+		},
+		{
+			name:  "add at array length",
+			patch: `[{"op": "add", "path": "/list/2", "value": "z"}]`,
+			want:  `{"a": {"b": "c"}, "list": ["x", "y", "z"], "a/b": 1, "m~n": 2}`,
+		},
+		{
+			name:  "add whole document",
+			patch: `[{"op": "add", "path": "", "value": {"new": 1}}]`,
+			want:  `{"new": 1}`,
+		},
+		{
+			name:  "remove member",
+			patch: `[{"op": "remove", "path": "/a/b"}]`,
+			want:  `{"a": {}, "list": ["x", "y"], "a/b": 1, "m~n": 2}`,
+		},
+		{
+			name:  "remove array element",
+			patch: `[{"op": "remove", "path": "/list/0"}]`,
+			want:  `{"a": {"b": "c"}, "list": ["y"], "a/b": 1, "m~n": 2}`,
+		},
+		{
+			name:  "replace",
+			patch: `[{"op": "replace", "path": "/a/b", "value": "d"}]`,
+			want:  `{"a": {"b": "d"}, "list": ["x", "y"], "a/b": 1, "m~n": 2}`,
+		},
+		{
+			name:  "replace array element",
+			patch: `[{"op": "replace", "path": "/list/1", "value": "z"}]`,
+			want:  `{"a": {"b": "c"}, "list": ["x", "z"], "a/b": 1, "m~n": 2}`,
+		},
+		{
+			name:  "replace with null",
+			patch: `[{"op": "replace", "path": "/a/b", "value": null}]`,
+			want:  `{"a": {"b": null}, "list": ["x", "y"], "a/b": 1, "m~n": 2}`,
+		},
+		{
+			name:  "move",
+			patch: `[{"op": "move", "from": "/a/b", "path": "/d"}]`,
+			want:  `{"a": {}, "d": "c", "list": ["x", "y"], "a/b": 1, "m~n": 2}`,
+		},
+		{
+			name:  "move array element",
+			patch: `[{"op": "move", "from": "/list/0", "path": "/list/-"}]`,
+			want:  `{"a": {"b": "c"}, "list": ["y", "x"], "a/b": 1, "m~n": 2}`,
+		},
+		{
+			name:  "copy",
+			patch: `[{"op": "copy", "from": "/a", "path": "/copied"}]`,
+			want:  `{"a": {"b": "c"}, "copied": {"b": "c"}, "list": ["x", "y"], "a/b": 1, "m~n": 2}`,
+		},
+		{
+			name: "copy is independent of its source",
+			patch: `[{"op": "copy", "from": "/a", "path": "/copied"},
+				{"op": "replace", "path": "/copied/b", "value": "d"}]`,
+			want: `{"a": {"b": "c"}, "copied": {"b": "d"}, "list": ["x", "y"], "a/b": 1, "m~n": 2}`,
+		},
+		{
+			name:  "test passes",
+			patch: `[{"op": "test", "path": "/list", "value": ["x", "y"]}]`,
+			want:  patchTestDocument,
+		},
+		{
+			name:  "escaped slash",
+			patch: `[{"op": "replace", "path": "/a~1b", "value": 3}]`,
+			want:  `{"a": {"b": "c"}, "list": ["x", "y"], "a/b": 3, "m~n": 2}`,
+		},
+		{
+			name:  "escaped tilde",
+			patch: `[{"op": "remove", "path": "/m~0n"}]`,
+			want:  `{"a": {"b": "c"}, "list": ["x", "y"], "a/b": 1}`,
+		},
+		{
+			name:  "operations apply in order",
+			patch: `[{"op": "add", "path": "/n", "value": 1}, {"op": "test", "path": "/n", "value": 1}, {"op": "remove", "path": "/n"}]`,
+			want:  patchTestDocument,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := applyJSONPatch(decodeJSON(t, patchTestDocument), []byte(tt.patch))
+			if err != nil {
+				t.Fatalf("applyJSONPatch: %v", err)
+			}
+			if want := decodeJSON(t, tt.want); !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestApplyJSONPatchErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		patch string
+		err   string
+	}{
+		{"test fails", `[{"op": "test", "path": "/a/b", "value": "d"}]`, "test failed"},
+		{"test of missing path", `[{"op": "test", "path": "/missing", "value": 1}]`, "path not found"},
+		{"later test fails", `[{"op": "add", "path": "/n", "value": 1}, {"op": "test", "path": "/n", "value": 2}]`, "patch operation 1"},
+		{"remove missing member", `[{"op": "remove", "path": "/missing"}]`, "path not found"},
+		{"remove whole document", `[{"op": "remove", "path": ""}]`, "cannot remove the whole document"},
+		{"replace missing member", `[{"op": "replace", "path": "/missing", "value": 1}]`, "path not found"},
+		{"add below missing member", `[{"op": "add", "path": "/missing/b", "value": 1}]`, "path not found"},
+		{"add past end of array", `[{"op": "add", "path": "/list/3", "value": "z"}]`, "invalid array index"},
+		{"remove end of array", `[{"op": "remove", "path": "/list/-"}]`, "invalid array index"},
+		{"leading zero index", `[{"op": "replace", "path": "/list/01", "value": "z"}]`, "invalid array index"},
+		{"move into child", `[{"op": "move", "from": "/a", "path": "/a/b/c"}]`, "cannot move a value into one of its children"},
+		{"move from missing path", `[{"op": "move", "from": "/missing", "path": "/d"}]`, "path not found"},
+		{"missing value", `[{"op": "add", "path": "/d"}]`, "value is required"},
+		{"invalid pointer", `[{"op": "remove", "path": "a"}]`, "invalid JSON pointer"},
+		{"unsupported op", `[{"op": "merge", "path": "/a"}]`, "unsupported operation"},
+		{"not an array", `{"op": "remove", "path": "/a"}`, "invalid JSON patch"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := applyJSONPatch(decodeJSON(t, patchTestDocument), []byte(tt.patch))
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("got error %v, want one containing %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestApplyMergePatch(t *testing.T) {
+	tests := []struct {
+		name  string
+		patch string
+		want  string
+	}{
+		{"set member", `{"a": {"d": "e"}}`, `{"a": {"b": "c", "d": "e"}, "list": ["x", "y"]}`},
+		{"null removes member", `{"a": {"b": null}}`, `{"a": {}, "list": ["x", "y"]}`},
+		{"null removes object", `{"a": null}`, `{"list": ["x", "y"]}`},
+		{"null for missing member", `{"missing": null}`, `{"a": {"b": "c"}, "list": ["x", "y"]}`},
+		{"arrays are replaced", `{"list": ["z"]}`, `{"a": {"b": "c"}, "list": ["z"]}`},
+		{"object replaces scalar", `{"list": {"k": null, "v": 1}}`, `{"a": {"b": "c"}, "list": {"v": 1}}`},
+		{"non-object replaces document", `["z"]`, `["z"]`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := decodeJSON(t, `{"a": {"b": "c"}, "list": ["x", "y"]}`)
+			got, err := applyMergePatch(doc, []byte(tt.patch))
+			if err != nil {
+				t.Fatalf("applyMergePatch: %v", err)
+			}
+			if want := decodeJSON(t, tt.want); !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+	}
+
+	if _, err := applyMergePatch(map[string]interface{}{}, []byte(`{`)); err == nil {
+		t.Error("invalid merge patch was applied")
+	}
+}
@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"context"
+	"log"
+	"math"
+	"time"
+)
+
+// UserDataExport is everything stored about a user, as returned for a data
+// subject access request. Sessions are not included because tokens are
+// stateless JWTs and nothing about them is stored.
+type UserDataExport struct {
+	GeneratedAt     time.Time         `json:"generated_at"`
+	Profile         *User             `json:"profile"`
+	Groups          []GroupMembership `json:"groups"`
+	History         []UserChange      `json:"history"`
+	PrivacyRequests []PrivacyRequest  `json:"privacy_requests"`
+}
+
+// ExportUserData collects everything stored about a user, including
+// deactivated ones, and records the request
+func (s *Service) ExportUserData(ctx context.Context, id int, requestedBy string) (*UserDataExport, error) {
+	var export *UserDataExport
+	err := s.withTx(ctx, func(tx *Service) error {
+		user, err := tx.repo.GetUserByIDIncludingInactive(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		err = tx.repo.CreatePrivacyRequest(ctx, &PrivacyRequest{
+			UserID:      user.ID,
+			Kind:        PrivacyRequestExport,
+			RequestedBy: requestedBy,
+		})
+		if err != nil {
+			return err
+		}
+
+		groups, err := tx.repo.GetUserGroups(ctx, user.ID)
+		if err != nil {
+			return err
+		}
+
+		history, err := tx.repo.ListUserChanges(ctx, user.ID, math.MaxInt32, 0)
+		if err != nil {
+			return err
+		}
+
+		requests, err := tx.repo.GetPrivacyRequests(ctx, user.ID)
+		if err != nil {
+			return err
+		}
+
+		export = &UserDataExport{
+			GeneratedAt:     time.Now().UTC(),
+			Profile:         user,
+			Groups:          groups,
+			History:         history,
+			PrivacyRequests: requests,
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return export, nil
+}
+
+// EraseUser carries out a right-to-erasure request. The user is deactivated,
+// their personal data is anonymized and their avatar, change history and
+// group memberships are removed.
+// The user row itself is kept so that references to it stay valid, and the
+// request is recorded.
+func (s *Service) EraseUser(ctx context.Context, id int, requestedBy string) (*PrivacyRequest, error) {
+	req := &PrivacyRequest{Kind: PrivacyRequestErasure, RequestedBy: requestedBy}
+	err := s.withTx(ctx, func(tx *Service) error {
+		user, err := tx.repo.GetUserByIDIncludingInactive(ctx, id)
+		if err != nil {
+			return err
+		}
+		if user.AnonymizedAt != nil {
+			return Conflict("user_erased", "user has already been erased")
+		}
+
+		if err := tx.repo.SetUserActive(ctx, user.ID, false); err != nil {
+			return err
+		}
+		if err := tx.repo.AnonymizeUser(ctx, user.ID); err != nil {
+			return err
+		}
+		if err := tx.repo.RemoveUserFromGroups(ctx, user.ID); err != nil {
+			return err
+		}
+		if err := tx.repo.DeleteUserChanges(ctx, user.ID); err != nil {
+			return err
+		}
+
+		req.UserID = user.ID
+		return tx.repo.CreatePrivacyRequest(ctx, req)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Files can't be restored by a rollback, so they are only removed once
+	// the erasure is committed. The avatar URLs are already cleared, so a
+	// file left behind is unreachable and only logged.
+	if err := s.deleteAvatarFiles(req.UserID); err != nil {
+		log.Printf("erase user %d: failed to delete avatar files: %v", req.UserID, err)
+	}
+
+	return req, nil
+}
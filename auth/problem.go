@@ -0,0 +1,79 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// ProblemContentType is the media type of RFC 7807 error responses
+const ProblemContentType = "application/problem+json"
+
+// Problem is an RFC 7807 problem details body. Code identifies the problem
+// and doesn't change between releases or languages, unlike Detail.
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	Code      string       `json:"code"`
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+}
+
+// WriteError reports err as a problem+json response, translated into the
+// caller's language where a translation exists. Internal errors are logged
+// and replaced with a generic message. Canceled requests are neither logged
+// nor answered beyond their status, as the client has gone away.
+func WriteError(w http.ResponseWriter, r *http.Request, err error) {
+	if appErr := AsError(err); appErr.Kind == KindCanceled {
+		w.WriteHeader(appErr.HTTPStatus())
+		return
+	}
+
+	trans := translator(r)
+	problem := newProblem(r, err)
+	problem.Instance = r.URL.Path
+
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.Header().Set("Content-Language", trans.Locale())
+	w.Header().Add("Vary", "Accept-Language")
+	w.WriteHeader(problem.Status)
+	json.NewEncoder(w).Encode(problem)
+}
+
+// newProblem describes err in the language of the request
+func newProblem(r *http.Request, err error) Problem {
+	trans := translator(r)
+	requestID := RequestIDFromContext(r.Context())
+
+	var problem Problem
+	var validationErrs validator.ValidationErrors
+	if errors.As(err, &validationErrs) {
+		problem = Problem{
+			Status: http.StatusBadRequest,
+			Code:   "validation_failed",
+			Detail: translateMessage(trans, "Validation failed"),
+			Errors: translateValidationErrors(trans, validationErrs),
+		}
+	} else {
+		appErr := AsError(err)
+		if appErr.Kind == KindInternal || appErr.Kind == KindUnavailable {
+			log.Printf("request %s: %s %s: %v", requestID, r.Method, r.URL.Path, err)
+		}
+		problem = Problem{
+			Status: appErr.HTTPStatus(),
+			Code:   appErr.Code,
+			Detail: appErr.translate(trans),
+			Errors: appErr.Fields,
+		}
+	}
+	problem.Type = "about:blank"
+	problem.Title = http.StatusText(problem.Status)
+	problem.RequestID = requestID
+	return problem
+}
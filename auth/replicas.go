@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// ReplicaSet spreads reads over read replicas of the primary database.
+// Replicas that fail a health check or a query are skipped until they pass
+// a health check again; with no healthy replica, reads go to the primary.
+type ReplicaSet struct {
+	replicas []*replica
+	next     atomic.Uint64
+}
+
+type replica struct {
+	index   int
+	db      *sql.DB
+	healthy atomic.Bool
+}
+
+// NewReplicaSet returns a set of the given replica pools, which log
+// messages number from 1 in order. Every replica starts out healthy.
+func NewReplicaSet(dbs ...*sql.DB) *ReplicaSet {
+	s := &ReplicaSet{}
+	for i, db := range dbs {
+		rep := &replica{index: i + 1, db: db}
+		rep.healthy.Store(true)
+		s.replicas = append(s.replicas, rep)
+	}
+	return s
+}
+
+// pick returns the next healthy replica in turn, or nil if there is none
+func (s *ReplicaSet) pick() *replica {
+	n := uint64(len(s.replicas))
+	start := s.next.Add(1)
+	for i := uint64(0); i < n; i++ {
+		rep := s.replicas[(start+i)%n]
+		if rep.healthy.Load() {
+			return rep
+		}
+	}
+	return nil
+}
+
+// setHealthy records the outcome of a check or query against a replica,
+// logging when its state changes
+func (rep *replica) setHealthy(healthy bool, err error) {
+	if rep.healthy.Swap(healthy) == healthy {
+		return
+	}
+	if healthy {
+		log.Printf("read replica %d is healthy again", rep.index)
+	} else {
+		log.Printf("read replica %d is unhealthy: %v", rep.index, err)
+	}
+}
+
+// CheckHealth pings every replica, each for at most timeout
+func (s *ReplicaSet) CheckHealth(ctx context.Context, timeout time.Duration) {
+	for _, rep := range s.replicas {
+		pingCtx, cancel := withTimeout(ctx, timeout)
+		err := rep.db.PingContext(pingCtx)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		rep.setHealthy(err == nil, err)
+	}
+}
+
+// StartHealthChecks checks the replicas immediately and then on every
+// interval until the returned stop function is called
+func (s *ReplicaSet) StartHealthChecks(interval, timeout time.Duration) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		s.CheckHealth(ctx, timeout)
+		for {
+			select {
+			case <-ticker.C:
+				s.CheckHealth(ctx, timeout)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return cancel
+}
+
+// Close closes the replica pools
+func (s *ReplicaSet) Close() error {
+	var firstErr error
+	for _, rep := range s.replicas {
+		if err := rep.db.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,160 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"goAPI/migrations"
+)
+
+func openTestDB(t *testing.T) *sql.DB {
+	db, err := OpenSQLite(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestReplicaRouting(t *testing.T) {
+	primary, replica := openTestDB(t), openTestDB(t)
+	replicas := NewReplicaSet(replica)
+	repo := NewRepository(primary, time.Second).WithReplicas(replicas)
+
+	// readFrom reports which database a read made with ctx is sent to
+	readFrom := func(ctx context.Context) dbtx {
+		var used dbtx
+		repo.read(ctx, func(db dbtx) error {
+			used = db
+			return nil
+		})
+		return used
+	}
+
+	ctx := ContextWithWriteTracking(context.Background())
+	if readFrom(ctx) != replica {
+		t.Error("read before any write did not use the replica")
+	}
+	markWritten(ctx)
+	if readFrom(ctx) != primary {
+		t.Error("read after a write did not use the primary")
+	}
+
+	untracked := context.Background()
+	markWritten(untracked)
+	if readFrom(untracked) != replica {
+		t.Error("read with a context that doesn't track writes did not use the replica")
+	}
+
+	err := repo.WithTx(untracked, func(tx UserStore) error {
+		var used dbtx
+		tx.(*Repository).read(untracked, func(db dbtx) error {
+			used = db
+			return nil
+		})
+		if used == replica {
+			t.Error("read inside a transaction used the replica")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReplicaFallback(t *testing.T) {
+	primary, replica := openTestDB(t), openTestDB(t)
+	replicas := NewReplicaSet(replica)
+	repo := NewRepository(primary, time.Second).WithReplicas(replicas)
+
+	var used []dbtx
+	err := repo.read(context.Background(), func(db dbtx) error {
+		used = append(used, db)
+		if db == replica {
+			return errors.New("connection refused")
+		}
+		return nil
+	})
+	if err != nil || len(used) != 2 || used[1] != primary {
+		t.Fatalf("failed replica read: err %v, used %v, want a retry on the primary", err, used)
+	}
+	if replicas.pick() != nil {
+		t.Error("replica that failed a read is still picked")
+	}
+
+	// A missing row is an answer, not a failure of the replica
+	replicas.CheckHealth(context.Background(), time.Second)
+	used = nil
+	err = repo.read(context.Background(), func(db dbtx) error {
+		used = append(used, db)
+		return sql.ErrNoRows
+	})
+	if err != sql.ErrNoRows || len(used) != 1 || replicas.pick() == nil {
+		t.Errorf("missing row: err %v, used %d databases, want sql.ErrNoRows from the replica", err, len(used))
+	}
+
+	replica.Close()
+	replicas.CheckHealth(context.Background(), time.Second)
+	if replicas.pick() != nil {
+		t.Error("replica that failed its health check is still picked")
+	}
+}
+
+// migratedTestDB returns a SQLite database with the schema applied. The
+// Postgres repository's lookups and updates run on it unchanged.
+func migratedTestDB(t *testing.T) *sql.DB {
+	db := openTestDB(t)
+	migrator, err := migrations.NewSQLite(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestWriteRequestsReadFromPrimary(t *testing.T) {
+	primary, replica := migratedTestDB(t), migratedTestDB(t)
+
+	// The replica still has the user as created; the primary has seen an
+	// update since
+	for _, db := range []*sql.DB{primary, replica} {
+		err := NewSQLiteStore(db, time.Second).CreateUser(t.Context(), &User{
+			Email: "alice@example.com", Password: "hash", FirstName: "Alice",
+			LastName: "Smith", Country: "US", Language: "en"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := primary.Exec(`UPDATE users SET last_name = 'Jones'`); err != nil {
+		t.Fatal(err)
+	}
+	current, err := NewSQLiteStore(primary, time.Second).GetUserByID(t.Context(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo := NewRepository(primary, time.Second).WithReplicas(NewReplicaSet(replica))
+	handler := NewHandler(NewService(repo, NewJWTService("secret"), nil), HandlerConfig{})
+
+	req := httptest.NewRequest(http.MethodPatch, "/api/v1/users/1", strings.NewReader(`{"first_name":"Alicia"}`))
+	req.Header.Set("Content-Type", MergePatchContentType)
+	req.Header.Set("If-Match", etag(current))
+	req = mux.SetURLVars(req, map[string]string{"id": "1"})
+	req = req.WithContext(ContextWithPrimaryReads(req.Context()))
+
+	rec := httptest.NewRecorder()
+	handler.PatchUser(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("PATCH with the primary's ETag = %d %s, want 200", rec.Code, rec.Body)
+	}
+}
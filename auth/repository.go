@@ -1,26 +1,85 @@
 package auth
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
+
+	"github.com/lib/pq"
 )
 
+// dbtx is satisfied by both *sql.DB and *sql.Tx, so the same queries can
+// run inside or outside a transaction
+type dbtx interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// Repository is the Postgres UserStore
 type Repository struct {
-	db *sql.DB
+	db       dbtx
+	conn     *sql.DB       // nil when the repository is bound to a transaction
+	timeout  time.Duration // Bounds each call; zero leaves it to the caller's context
+	replicas *ReplicaSet   // Serves lookups and listings when set
+}
+
+func NewRepository(db *sql.DB, timeout time.Duration) *Repository {
+	return &Repository{db: db, conn: db, timeout: timeout}
+}
+
+// WithReplicas returns a copy of the repository that sends user lookups,
+// listings and searches to the replicas, unless the context has already
+// been used for a write or comes from ContextWithPrimaryReads. Everything
+// else, and every call inside a transaction, uses the primary.
+func (r *Repository) WithReplicas(replicas *ReplicaSet) *Repository {
+	copied := *r
+	copied.replicas = replicas
+	return &copied
 }
 
-func NewRepository(db *sql.DB) *Repository {
-	return &Repository{db: db}
+// WithTx runs fn with a Repository bound to a single transaction, which is
+// committed if fn succeeds and rolled back otherwise. Calls on a repository
+// that is already in a transaction join it. The transaction is rolled back
+// if ctx is cancelled first.
+func (r *Repository) WithTx(ctx context.Context, fn func(UserStore) error) error {
+	if r.conn == nil {
+		return fn(r)
+	}
+
+	markWritten(ctx)
+	tx, err := r.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	if err := fn(&Repository{db: tx, timeout: r.timeout}); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
 }
 
-func (r *Repository) CreateUser(user *User) error {
+func (r *Repository) CreateUser(ctx context.Context, user *User) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	markWritten(ctx)
+
 	query := `
-		INSERT INTO users (email, password_hash, first_name, last_name, country, language, is_active, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
-		RETURNING id`
+		INSERT INTO users (email, password_hash, first_name, last_name, country, language, is_active, created_at, updated_at, attributes, external_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		RETURNING id, version`
 
 	now := time.Now()
+	user.Email = NormalizeEmail(user.Email)
 	user.CreatedAt = now
 	user.UpdatedAt = now
 	user.IsActive = true
@@ -29,94 +88,732 @@ func (r *Repository) CreateUser(user *User) error {
 		user.Language = "en"
 	}
 
-	err := r.db.QueryRow(query, user.Email, user.Password, user.FirstName,
+	err := r.db.QueryRowContext(ctx, query, user.Email, user.Password, user.FirstName,
 		user.LastName, user.Country, user.Language, user.IsActive,
-		user.CreatedAt, user.UpdatedAt).Scan(&user.ID)
+		user.CreatedAt, user.UpdatedAt, user.Attributes, user.ExternalID).Scan(&user.ID, &user.Version)
 
 	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrEmailTaken
+		}
 		return fmt.Errorf("failed to create user: %w", err)
 	}
 	return nil
 }
 
-func (r *Repository) GetAllUsers() ([]User, error) {
+func (r *Repository) GetAllUsers(ctx context.Context) ([]User, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
 	query := `
-		SELECT id, email, first_name, last_name, country, language, is_active, created_at, updated_at
+		SELECT ` + userColumns + `
 		FROM users 
 		WHERE is_active = true`
 
-	rows, err := r.db.Query(query)
+	var users []User
+	err := r.read(ctx, func(db dbtx) error {
+		var err error
+		users, err = queryUsers(ctx, db, query)
+		return err
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to get users: %w", err)
 	}
+
+	return users, nil
+}
+
+// ListUsers returns one page of users using keyset pagination, so the cost
+// of a page does not grow with its position in the listing
+func (r *Repository) ListUsers(ctx context.Context, params ListUsersParams) (*UserPage, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	where, args := userFilterClause(params)
+
+	cur, err := params.pageCursor()
+	if err != nil {
+		return nil, err
+	}
+
+	column := sortColumns[params.Sort]
+	descending := params.Order == "desc"
+	backwards := cur != nil && cur.Backwards
+	if backwards {
+		descending = !descending
+	}
+
+	if cur != nil {
+		op := ">"
+		if descending {
+			op = "<"
+		}
+		if column == "id" {
+			args = append(args, cur.ID)
+			where = append(where, fmt.Sprintf("id %s $%d", op, len(args)))
+		} else {
+			cast := ""
+			if column == "created_at" {
+				cast = "::timestamptz"
+			}
+			args = append(args, cur.Value, cur.ID)
+			where = append(where, fmt.Sprintf("(%s, id) %s ($%d%s, $%d)", column, op, len(args)-1, cast, len(args)))
+		}
+	}
+
+	direction := "ASC"
+	if descending {
+		direction = "DESC"
+	}
+	orderBy := fmt.Sprintf("%s %s, id %s", column, direction, direction)
+	if column == "id" {
+		orderBy = "id " + direction
+	}
+
+	args = append(args, params.Limit+1, params.Offset)
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM users
+		%s
+		ORDER BY %s
+		LIMIT $%d OFFSET $%d`, userColumns, whereSQL(where), orderBy, len(args)-1, len(args))
+
+	var page *UserPage
+	err = r.read(ctx, func(db dbtx) error {
+		users, err := queryUsers(ctx, db, query, args...)
+		if err != nil {
+			return fmt.Errorf("failed to list users: %w", err)
+		}
+
+		page = newUserPage(users, params, cur)
+
+		if params.IncludeTotal {
+			where, args := userFilterClause(params)
+			var total int
+			query := fmt.Sprintf(`SELECT COUNT(*) FROM users %s`, whereSQL(where))
+			if err := db.QueryRowContext(ctx, query, args...).Scan(&total); err != nil {
+				return fmt.Errorf("failed to count users: %w", err)
+			}
+			page.Total = &total
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return page, nil
+}
+
+// StreamUsers calls fn for every user matching the listing filters, in ID
+// order, reading rows from the database as it goes. It is exempt from the
+// query timeout because a full export can outlast it; ctx alone bounds the
+// stream, so callers must pass one that ends, such as the request's, which
+// is canceled when the client disconnects.
+func (r *Repository) StreamUsers(ctx context.Context, params ListUsersParams, fn func(*User) error) error {
+	where, args := userFilterClause(params)
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM users
+		%s
+		ORDER BY id`, userColumns, whereSQL(where))
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to query users: %w", err)
+	}
 	defer rows.Close()
 
-	var users []User
 	for rows.Next() {
-		var user User
-		err := rows.Scan(&user.ID, &user.Email, &user.FirstName, &user.LastName,
-			&user.Country, &user.Language, &user.IsActive, &user.CreatedAt, &user.UpdatedAt)
+		user, err := scanUser(rows)
 		if err != nil {
-			return nil, fmt.Errorf("failed to scan user: %w", err)
+			return fmt.Errorf("failed to scan user: %w", err)
+		}
+		if err := fn(user); err != nil {
+			return err
 		}
-		users = append(users, user)
 	}
 
 	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("failed to iterate rows: %w", err)
+		return fmt.Errorf("failed to iterate rows: %w", err)
 	}
 
-	return users, nil
+	return nil
+}
+
+// SearchUsers finds active users whose name or email matches the query,
+// combining prefix full-text matches with trigram similarity for typos
+func (r *Repository) SearchUsers(ctx context.Context, query string, terms []string, limit int) ([]UserSearchResult, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	sqlQuery := `
+		SELECT ` + userColumns + `,
+		       ts_rank(to_tsvector('simple', first_name || ' ' || last_name || ' ' || email),
+		               to_tsquery('simple', $2))
+		       + GREATEST(similarity(first_name, $1), similarity(last_name, $1), similarity(email, $1)) AS rank
+		FROM users
+		WHERE is_active = true AND (
+		      to_tsvector('simple', first_name || ' ' || last_name || ' ' || email) @@ to_tsquery('simple', $2)
+		      OR first_name ILIKE $3 OR last_name ILIKE $3 OR email ILIKE $3
+		      OR first_name % $1 OR last_name % $1 OR email % $1)
+		ORDER BY rank DESC, id
+		LIMIT $4`
+
+	var results []UserSearchResult
+	err := r.read(ctx, func(db dbtx) error {
+		rows, err := db.QueryContext(ctx, sqlQuery, query, prefixTSQuery(terms), likePattern(query), limit)
+		if err != nil {
+			return fmt.Errorf("failed to search users: %w", err)
+		}
+		defer rows.Close()
+
+		results = []UserSearchResult{}
+		for rows.Next() {
+			var result UserSearchResult
+			user, err := scanUser(rows, &result.Rank)
+			if err != nil {
+				return fmt.Errorf("failed to scan user: %w", err)
+			}
+			result.User = *user
+			results = append(results, result)
+		}
+
+		if err = rows.Err(); err != nil {
+			return fmt.Errorf("failed to iterate rows: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
 }
 
-func (r *Repository) DeleteUser(id int) error {
+// DeleteUser deactivates the user, provided it is still at the given
+// version
+func (r *Repository) DeleteUser(ctx context.Context, id, version int) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	markWritten(ctx)
+
 	query := `
 		UPDATE users 
-		SET is_active = false, updated_at = $1
-		WHERE id = $2`
+		SET is_active = false, deactivated_at = $1, updated_at = $1
+		WHERE id = $2 AND version = $3`
 
-	_, err := r.db.Exec(query, time.Now(), id)
+	result, err := r.db.ExecContext(ctx, query, time.Now(), id, version)
 	if err != nil {
 		return fmt.Errorf("failed to delete user: %w", err)
 	}
 
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrPreconditionFailed
+	}
+
 	return nil
 }
 
-func (r *Repository) UpdateUser(user *User) error {
+// UpdateUser saves the user's profile. The update only applies if the row
+// is still at user.Version, so concurrent edits cannot overwrite each other.
+func (r *Repository) UpdateUser(ctx context.Context, user *User) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	markWritten(ctx)
+
 	query := `
 		UPDATE users 
 		SET email = $1, first_name = $2, last_name = $3, 
-		    country = $4, language = $5, updated_at = $6
-		WHERE id = $7`
+		    country = $4, language = $5, attributes = $6, external_id = $7, updated_at = $8
+		WHERE id = $9 AND version = $10
+		RETURNING version, updated_at`
 
-	_, err := r.db.Exec(query, user.Email, user.FirstName,
-		user.LastName, user.Country, user.Language,
-		time.Now(), user.ID)
+	user.Email = NormalizeEmail(user.Email)
+	err := r.db.QueryRowContext(ctx, query, user.Email, user.FirstName,
+		user.LastName, user.Country, user.Language, user.Attributes, user.ExternalID,
+		time.Now(), user.ID, user.Version).Scan(&user.Version, &user.UpdatedAt)
 
 	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrPreconditionFailed
+		}
+		if isUniqueViolation(err) {
+			return ErrEmailTaken
+		}
 		return fmt.Errorf("failed to update user: %w", err)
 	}
 	return nil
 }
 
-func (r *Repository) GetUserByEmail(email string) (*User, error) {
-	user := &User{}
+// SetUserActive activates or deactivates a user without touching other fields
+func (r *Repository) SetUserActive(ctx context.Context, id int, active bool) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	markWritten(ctx)
+
 	query := `
-		SELECT id, email, password_hash, first_name, last_name, country, 
-		       language, is_active, created_at, updated_at
-		FROM users 
-		WHERE email = $1 AND is_active = true`
+		UPDATE users 
+		SET is_active = $1, updated_at = $2,
+		    deactivated_at = CASE WHEN $1 THEN NULL ELSE COALESCE(deactivated_at, $2) END
+		WHERE id = $3`
 
-	err := r.db.QueryRow(query, email).Scan(
-		&user.ID, &user.Email, &user.Password, &user.FirstName,
-		&user.LastName, &user.Country, &user.Language, &user.IsActive,
-		&user.CreatedAt, &user.UpdatedAt,
-	)
+	_, err := r.db.ExecContext(ctx, query, active, time.Now(), id)
+	if err != nil {
+		return fmt.Errorf("failed to update user status: %w", err)
+	}
 
+	return nil
+}
+
+// PurgeUser permanently deletes a deactivated user
+func (r *Repository) PurgeUser(ctx context.Context, id int) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	markWritten(ctx)
+
+	result, err := r.db.ExecContext(ctx, `DELETE FROM users WHERE id = $1 AND is_active = false`, id)
+	if err != nil {
+		return fmt.Errorf("failed to purge user: %w", err)
+	}
+
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+// AnonymizeUser replaces the personal data of a deactivated user with
+// placeholders, keeping the row so references to it stay valid. The email
+// is rewritten to a unique placeholder, which frees the original address.
+func (r *Repository) AnonymizeUser(ctx context.Context, id int) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	markWritten(ctx)
+
+	query := `
+		UPDATE users 
+		SET ` + anonymizeAssignments + `
+		WHERE id = $2 AND is_active = false`
+
+	result, err := r.db.ExecContext(ctx, query, time.Now(), id)
+	if err != nil {
+		return fmt.Errorf("failed to anonymize user: %w", err)
+	}
+
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+// PurgeDeactivatedBefore permanently deletes users deactivated before the
+// cutoff and returns their IDs
+func (r *Repository) PurgeDeactivatedBefore(ctx context.Context, cutoff time.Time) ([]int, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	markWritten(ctx)
+
+	ids, err := queryIDs(ctx, r.db, `
+		DELETE FROM users
+		WHERE is_active = false AND deactivated_at < $1
+		RETURNING id`, cutoff)
+	if err != nil {
+		return nil, fmt.Errorf("failed to purge users: %w", err)
+	}
+
+	return ids, nil
+}
+
+// AnonymizeDeactivatedBefore anonymizes users deactivated before the cutoff
+// that have not been anonymized yet and returns their IDs
+func (r *Repository) AnonymizeDeactivatedBefore(ctx context.Context, cutoff time.Time) ([]int, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	markWritten(ctx)
+
+	query := `
+		UPDATE users 
+		SET ` + anonymizeAssignments + `
+		WHERE is_active = false AND deactivated_at < $2 AND anonymized_at IS NULL
+		RETURNING id`
+
+	ids, err := queryIDs(ctx, r.db, query, time.Now(), cutoff)
+	if err != nil {
+		return nil, fmt.Errorf("failed to anonymize users: %w", err)
+	}
+
+	return ids, nil
+}
+
+// SetUserAvatar stores the URLs of the user's avatar renditions; nil
+// removes the avatar. The update only applies if the row is still at
+// version.
+func (r *Repository) SetUserAvatar(ctx context.Context, id, version int, urls AvatarURLs) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	markWritten(ctx)
+
+	query := `
+		UPDATE users 
+		SET avatar_urls = $1, updated_at = $2
+		WHERE id = $3 AND version = $4`
+
+	result, err := r.db.ExecContext(ctx, query, urls, time.Now(), id, version)
+	if err != nil {
+		return fmt.Errorf("failed to update avatar: %w", err)
+	}
+
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrPreconditionFailed
+	}
+
+	return nil
+}
+
+// GetUserGroups returns the provisioned groups the user is a member of
+func (r *Repository) GetUserGroups(ctx context.Context, id int) ([]GroupMembership, error) {
+	groups, err := r.GetGroupsForUsers(ctx, []int{id})
+	if err != nil {
+		return nil, err
+	}
+	return groups[id], nil
+}
+
+// GetGroupsForUsers returns the provisioned groups of several users at
+// once, keyed by user ID. Every requested user has an entry, even if empty.
+func (r *Repository) GetGroupsForUsers(ctx context.Context, ids []int) (map[int][]GroupMembership, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT m.user_id, g.id, g.display_name
+		FROM scim_groups g
+		JOIN scim_group_members m ON m.group_id = g.id
+		WHERE m.user_id = ANY($1)
+		ORDER BY m.user_id, g.id`
+
+	rows, err := r.db.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get groups: %w", err)
+	}
+	defer rows.Close()
+
+	groups := make(map[int][]GroupMembership, len(ids))
+	for _, id := range ids {
+		groups[id] = []GroupMembership{}
+	}
+	for rows.Next() {
+		var userID int
+		var group GroupMembership
+		if err := rows.Scan(&userID, &group.ID, &group.DisplayName); err != nil {
+			return nil, fmt.Errorf("failed to scan group: %w", err)
+		}
+		groups[userID] = append(groups[userID], group)
+	}
+
+	return groups, rows.Err()
+}
+
+// RemoveUserFromGroups deletes every group membership of the user
+func (r *Repository) RemoveUserFromGroups(ctx context.Context, id int) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	markWritten(ctx)
+
+	_, err := r.db.ExecContext(ctx, `DELETE FROM scim_group_members WHERE user_id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to remove group memberships: %w", err)
+	}
+
+	return nil
+}
+
+func (r *Repository) CreatePrivacyRequest(ctx context.Context, req *PrivacyRequest) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	markWritten(ctx)
+
+	query := `
+		INSERT INTO privacy_requests (user_id, kind, requested_by, created_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id`
+
+	req.CreatedAt = time.Now()
+	err := r.db.QueryRowContext(ctx, query, req.UserID, req.Kind, req.RequestedBy,
+		req.CreatedAt).Scan(&req.ID)
+	if err != nil {
+		return fmt.Errorf("failed to record privacy request: %w", err)
+	}
+
+	return nil
+}
+
+func (r *Repository) GetPrivacyRequests(ctx context.Context, userID int) ([]PrivacyRequest, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT id, user_id, kind, requested_by, created_at
+		FROM privacy_requests
+		WHERE user_id = $1
+		ORDER BY id`
+
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get privacy requests: %w", err)
+	}
+	defer rows.Close()
+
+	requests := []PrivacyRequest{}
+	for rows.Next() {
+		var req PrivacyRequest
+		if err := rows.Scan(&req.ID, &req.UserID, &req.Kind,
+			&req.RequestedBy, &req.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan privacy request: %w", err)
+		}
+		requests = append(requests, req)
+	}
+
+	return requests, rows.Err()
+}
+
+// RecordUserChange stores a history entry for the user's current state,
+// diffed against before. before is nil for a new user.
+func (r *Repository) RecordUserChange(ctx context.Context, action string, before *User, id int, actor Actor) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	markWritten(ctx)
+
+	after, err := r.GetUserByIDIncludingInactive(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	snapshot := snapshotOf(after)
+	var previous *UserSnapshot
+	if before != nil {
+		s := snapshotOf(before)
+		previous = &s
+	}
+
+	changes, err := json.Marshal(diffSnapshots(previous, snapshot))
+	if err != nil {
+		return fmt.Errorf("failed to encode changes: %w", err)
+	}
+	snapshotJSON, err := json.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("failed to encode snapshot: %w", err)
+	}
+
+	if actor.Source == "" {
+		actor.Source = SourceSystem
+	}
+
+	query := `
+		INSERT INTO user_changes (user_id, version, action, actor_id, actor_email,
+		                          source, request_id, changes, snapshot, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
+
+	_, err = r.db.ExecContext(ctx, query, id, after.Version, action, nullInt(actor.UserID),
+		nullString(actor.Email), actor.Source, nullString(actor.RequestID),
+		string(changes), string(snapshotJSON), time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to record change: %w", err)
+	}
+
+	return nil
+}
+
+// ListUserChanges returns up to limit history entries for the user, newest
+// first, starting below beforeID when it is non-zero
+func (r *Repository) ListUserChanges(ctx context.Context, userID, limit int, beforeID int64) ([]UserChange, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT id, user_id, version, action, actor_id, actor_email, source,
+		       request_id, changes, snapshot, created_at
+		FROM user_changes
+		WHERE user_id = $1 AND ($2 = 0 OR id < $2)
+		ORDER BY id DESC
+		LIMIT $3`
+
+	rows, err := r.db.QueryContext(ctx, query, userID, beforeID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user history: %w", err)
+	}
+	defer rows.Close()
+
+	changes := []UserChange{}
+	for rows.Next() {
+		change, err := scanUserChange(rows)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, *change)
+	}
+
+	return changes, rows.Err()
+}
+
+// GetUserSnapshot returns the user's state as of the given version, taken
+// from the latest change at or before it
+func (r *Repository) GetUserSnapshot(ctx context.Context, userID, version int) (*UserSnapshot, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT snapshot
+		FROM user_changes
+		WHERE user_id = $1 AND version <= $2
+		ORDER BY version DESC, id DESC
+		LIMIT 1`
+
+	var data []byte
+	err := r.db.QueryRowContext(ctx, query, userID, version).Scan(&data)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, NotFound("version_not_found", "no history recorded for version %d", version)
+		}
+		return nil, fmt.Errorf("failed to get user history: %w", err)
+	}
+
+	snapshot := &UserSnapshot{}
+	if err := json.Unmarshal(data, snapshot); err != nil {
+		return nil, fmt.Errorf("invalid snapshot: %w", err)
+	}
+	return snapshot, nil
+}
+
+// DeleteUserChanges removes the history of the given users, which holds
+// their previous personal data
+func (r *Repository) DeleteUserChanges(ctx context.Context, ids ...int) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	markWritten(ctx)
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	_, err := r.db.ExecContext(ctx, `DELETE FROM user_changes WHERE user_id = ANY($1)`, pq.Array(ids))
+	if err != nil {
+		return fmt.Errorf("failed to delete user history: %w", err)
+	}
+
+	return nil
+}
+
+func scanUserChange(row rowScanner) (*UserChange, error) {
+	change := &UserChange{}
+	var actorID sql.NullInt64
+	var actorEmail, requestID sql.NullString
+	var changes, snapshot []byte
+
+	err := row.Scan(&change.ID, &change.UserID, &change.Version, &change.Action,
+		&actorID, &actorEmail, &change.Actor.Source, &requestID,
+		&changes, &snapshot, &change.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan change: %w", err)
+	}
+
+	change.Actor.UserID = int(actorID.Int64)
+	change.Actor.Email = actorEmail.String
+	change.Actor.RequestID = requestID.String
+	if err := json.Unmarshal(changes, &change.Changes); err != nil {
+		return nil, fmt.Errorf("invalid change: %w", err)
+	}
+	if err := json.Unmarshal(snapshot, &change.Snapshot); err != nil {
+		return nil, fmt.Errorf("invalid snapshot: %w", err)
+	}
+	return change, nil
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+func nullInt(i int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(i), Valid: i != 0}
+}
+
+// GetAttributeSchema returns the stored attribute schema, or nil if none
+// has been saved
+func (r *Repository) GetAttributeSchema(ctx context.Context) ([]byte, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	var schema []byte
+	err := r.db.QueryRowContext(ctx, `SELECT schema FROM user_attribute_schema WHERE id = 1`).Scan(&schema)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get attribute schema: %w", err)
+	}
+
+	return schema, nil
+}
+
+func (r *Repository) SaveAttributeSchema(ctx context.Context, schema []byte) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	markWritten(ctx)
+
+	query := `
+		INSERT INTO user_attribute_schema (id, schema, updated_at)
+		VALUES (1, $1, $2)
+		ON CONFLICT (id) DO UPDATE SET schema = EXCLUDED.schema, updated_at = EXCLUDED.updated_at`
+
+	_, err := r.db.ExecContext(ctx, query, string(schema), time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to save attribute schema: %w", err)
+	}
+
+	return nil
+}
+
+// UpdatePassword stores a new password hash for the user
+func (r *Repository) UpdatePassword(ctx context.Context, id int, passwordHash string) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	markWritten(ctx)
+
+	query := `
+		UPDATE users 
+		SET password_hash = $1, updated_at = $2
+		WHERE id = $3`
+
+	_, err := r.db.ExecContext(ctx, query, passwordHash, time.Now(), id)
+	if err != nil {
+		return fmt.Errorf("failed to update password: %w", err)
+	}
+
+	return nil
+}
+
+// GetUserByEmail finds an active user by email, ignoring case
+func (r *Repository) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT ` + userColumns + `
+		FROM users 
+		WHERE lower(email) = lower($1) AND is_active = true`
+
+	var user *User
+	err := r.read(ctx, func(db dbtx) error {
+		var err error
+		user, err = scanUser(db.QueryRowContext(ctx, query, email))
+		return err
+	})
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("user not found")
+			return nil, ErrUserNotFound
 		}
 		return nil, fmt.Errorf("failed to get user: %w", err)
 	}
@@ -124,26 +821,214 @@ func (r *Repository) GetUserByEmail(email string) (*User, error) {
 	return user, nil
 }
 
-func (r *Repository) GetUserByID(id int) (*User, error) {
-	user := &User{}
+func (r *Repository) GetUserByID(ctx context.Context, id int) (*User, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
 	query := `
-		SELECT id, email, password_hash, first_name, last_name, country, 
-		       language, is_active, created_at, updated_at
+		SELECT ` + userColumns + `
 		FROM users 
 		WHERE id = $1 AND is_active = true`
 
-	err := r.db.QueryRow(query, id).Scan(
-		&user.ID, &user.Email, &user.Password, &user.FirstName,
-		&user.LastName, &user.Country, &user.Language, &user.IsActive,
-		&user.CreatedAt, &user.UpdatedAt,
-	)
+	var user *User
+	err := r.read(ctx, func(db dbtx) error {
+		var err error
+		user, err = scanUser(db.QueryRowContext(ctx, query, id))
+		return err
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrUserNotFound
+		}
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	return user, nil
+}
+
+// GetUserByIDIncludingInactive looks a user up by ID regardless of is_active
+func (r *Repository) GetUserByIDIncludingInactive(ctx context.Context, id int) (*User, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT ` + userColumns + `
+		FROM users 
+		WHERE id = $1`
 
+	user, err := scanUser(r.db.QueryRowContext(ctx, query, id))
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("user not found")
+			return nil, ErrUserNotFound
 		}
 		return nil, fmt.Errorf("failed to get user: %w", err)
 	}
 
 	return user, nil
 }
+
+// anonymizeAssignments overwrites personal data; $1 is the current time
+const anonymizeAssignments = `email = 'deleted-' || id || '@anonymized.invalid',
+		    first_name = 'Deleted', last_name = 'User', password_hash = '',
+		    attributes = '{}', avatar_urls = NULL, external_id = '',
+		    anonymized_at = $1, updated_at = $1`
+
+// userColumns is the column list read by scanUser
+const userColumns = `id, email, password_hash, first_name, last_name, country, language,
+		       is_active, created_at, updated_at, version, deactivated_at, anonymized_at,
+		       attributes, avatar_urls, external_id`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanUser reads a row selected with userColumns, followed by any extra
+// columns into extra
+func scanUser(row rowScanner, extra ...interface{}) (*User, error) {
+	user := &User{}
+	dest := append([]interface{}{
+		&user.ID, &user.Email, &user.Password, &user.FirstName,
+		&user.LastName, &user.Country, &user.Language, &user.IsActive,
+		&user.CreatedAt, &user.UpdatedAt, &user.Version,
+		&user.DeactivatedAt, &user.AnonymizedAt, &user.Attributes,
+		&user.AvatarURLs, &user.ExternalID,
+	}, extra...)
+
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	user.AvatarURL = user.AvatarURLs[DefaultAvatarSize]
+	return user, nil
+}
+
+// read runs fn against a healthy replica if the repository has any and ctx
+// hasn't been used for a write, and against the primary otherwise. A
+// replica that fails is marked unhealthy and fn is retried on the primary.
+func (r *Repository) read(ctx context.Context, fn func(db dbtx) error) error {
+	if r.replicas == nil || r.conn == nil || hasWritten(ctx) {
+		return fn(r.db)
+	}
+	rep := r.replicas.pick()
+	if rep == nil {
+		return fn(r.db)
+	}
+
+	err := fn(rep.db)
+	if err == nil || err == sql.ErrNoRows || ctx.Err() != nil {
+		return err
+	}
+	rep.setHealthy(false, err)
+	return fn(r.db)
+}
+
+func (r *Repository) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withTimeout(ctx, r.timeout)
+}
+
+// withTimeout bounds the statements of one store call; a zero timeout only
+// inherits the caller's deadline
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// queryUsers runs a query selecting userColumns and collects the rows
+func queryUsers(ctx context.Context, db dbtx, query string, args ...interface{}) ([]User, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan user: %w", err)
+		}
+		users = append(users, *user)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate rows: %w", err)
+	}
+
+	return users, nil
+}
+
+// queryIDs runs a query returning a single id column
+func queryIDs(ctx context.Context, db dbtx, query string, args ...interface{}) ([]int, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// userFilterClause builds the WHERE conditions shared by user listings
+func userFilterClause(params ListUsersParams) ([]string, []interface{}) {
+	var where []string
+	var args []interface{}
+
+	if params.Active != nil {
+		args = append(args, *params.Active)
+		where = append(where, fmt.Sprintf("is_active = $%d", len(args)))
+	}
+	if params.Email != "" {
+		args = append(args, NormalizeEmail(params.Email))
+		where = append(where, fmt.Sprintf("lower(email) = $%d", len(args)))
+	}
+	if params.ExternalID != "" {
+		args = append(args, params.ExternalID)
+		where = append(where, fmt.Sprintf("external_id = $%d", len(args)))
+	}
+	if params.Country != "" {
+		args = append(args, params.Country)
+		where = append(where, fmt.Sprintf("country = $%d", len(args)))
+	}
+	if params.Language != "" {
+		args = append(args, params.Language)
+		where = append(where, fmt.Sprintf("language = $%d", len(args)))
+	}
+	if params.CreatedAfter != nil {
+		args = append(args, *params.CreatedAfter)
+		where = append(where, fmt.Sprintf("created_at >= $%d", len(args)))
+	}
+	if params.CreatedBefore != nil {
+		args = append(args, *params.CreatedBefore)
+		where = append(where, fmt.Sprintf("created_at < $%d", len(args)))
+	}
+	if len(params.Attributes) > 0 {
+		args = append(args, Attributes(params.Attributes))
+		where = append(where, fmt.Sprintf("attributes @> $%d", len(args)))
+	}
+
+	return where, args
+}
+
+func whereSQL(conditions []string) string {
+	if len(conditions) == 0 {
+		return ""
+	}
+	return "WHERE " + strings.Join(conditions, " AND ")
+}
+
+// isUniqueViolation reports whether err is a Postgres unique constraint
+// violation
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
@@ -0,0 +1,47 @@
+package auth_test
+
+import (
+	"database/sql"
+	"os"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+
+	"goAPI/auth"
+	"goAPI/auth/storetest"
+	"goAPI/migrations"
+)
+
+// TestRepository runs the store suite against the database named by
+// TEST_DATABASE_URL, after migrating it. Its tables are emptied before
+// every test.
+func TestRepository(t *testing.T) {
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	migrator, err := migrations.NewPostgres(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(t.Context()); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+
+	storetest.Run(t, func(t *testing.T) auth.UserStore {
+		_, err := db.Exec(`TRUNCATE users, user_changes, privacy_requests, user_attribute_schema,
+			scim_groups, scim_group_members RESTART IDENTITY CASCADE`)
+		if err != nil {
+			t.Fatalf("failed to reset database: %v", err)
+		}
+		return auth.NewRepository(db, 5*time.Second)
+	})
+}
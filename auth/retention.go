@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+type RetentionMode string
+
+const (
+	// RetentionAnonymize scrubs personal data but keeps the account row
+	RetentionAnonymize RetentionMode = "anonymize"
+	// RetentionDelete removes the account row entirely
+	RetentionDelete RetentionMode = "delete"
+)
+
+// Valid reports whether m is one of the retention modes
+func (m RetentionMode) Valid() bool {
+	return m == RetentionAnonymize || m == RetentionDelete
+}
+
+// RetentionPolicy decides what happens to accounts that have been
+// deactivated for longer than After
+type RetentionPolicy struct {
+	After time.Duration
+	Mode  RetentionMode
+}
+
+// ApplyRetention anonymizes or deletes every user that has been deactivated
+// for longer than the policy allows and returns how many were affected
+func (s *Service) ApplyRetention(ctx context.Context, policy RetentionPolicy) (int64, error) {
+	if policy.After <= 0 {
+		return 0, fmt.Errorf("retention period must be positive")
+	}
+
+	cutoff := time.Now().Add(-policy.After)
+	var ids []int
+	var err error
+	switch policy.Mode {
+	case RetentionAnonymize:
+		// The change history holds the personal data being removed
+		err = s.withTx(ctx, func(tx *Service) error {
+			if ids, err = tx.repo.AnonymizeDeactivatedBefore(ctx, cutoff); err != nil {
+				return err
+			}
+			return tx.repo.DeleteUserChanges(ctx, ids...)
+		})
+	case RetentionDelete:
+		ids, err = s.repo.PurgeDeactivatedBefore(ctx, cutoff)
+	default:
+		return 0, fmt.Errorf("invalid retention mode %q", policy.Mode)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if err := s.deleteAvatarFiles(ids...); err != nil {
+		return 0, err
+	}
+	return int64(len(ids)), nil
+}
+
+// StartRetentionJob applies the policy immediately and then on every
+// interval until the returned stop function is called, which also aborts a
+// run that is still in progress
+func (s *Service) StartRetentionJob(policy RetentionPolicy, interval time.Duration) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	run := func() {
+		n, err := s.ApplyRetention(ctx, policy)
+		if err != nil {
+			log.Printf("retention job failed: %v", err)
+			return
+		}
+		if n > 0 {
+			log.Printf("retention job: %s %d deactivated users", policy.Mode, n)
+		}
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		run()
+		for {
+			select {
+			case <-ticker.C:
+				run()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return cancel
+}
@@ -0,0 +1,165 @@
+package auth
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+const minSearchLength = 2
+
+// searchTerms splits a search query into lower-cased words
+func searchTerms(query string) []string {
+	return strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// prefixTSQuery builds a to_tsquery expression matching every term as a
+// prefix, e.g. "jo smi" becomes "jo:* & smi:*"
+func prefixTSQuery(terms []string) string {
+	parts := make([]string, len(terms))
+	for i, term := range terms {
+		parts[i] = term + ":*"
+	}
+	return strings.Join(parts, " & ")
+}
+
+// likePattern escapes LIKE wildcards and wraps the query for a substring match
+func likePattern(query string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return "%" + replacer.Replace(query) + "%"
+}
+
+// highlight HTML-escapes value and wraps every occurrence of the search
+// terms in <mark> tags. It returns false when no term occurs in value.
+func highlight(value string, terms []string) (string, bool) {
+	if len(terms) == 0 {
+		return "", false
+	}
+
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = regexp.QuoteMeta(term)
+	}
+	re := regexp.MustCompile("(?i)" + strings.Join(quoted, "|"))
+
+	matches := re.FindAllStringIndex(value, -1)
+	if len(matches) == 0 {
+		return "", false
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, m := range matches {
+		b.WriteString(html.EscapeString(value[last:m[0]]))
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(value[m[0]:m[1]]))
+		b.WriteString("</mark>")
+		last = m[1]
+	}
+	b.WriteString(html.EscapeString(value[last:]))
+	return b.String(), true
+}
+
+func validateSearchQuery(query string) ([]string, error) {
+	query = strings.TrimSpace(query)
+	if len([]rune(query)) < minSearchLength {
+		return nil, Validation("invalid_search_query", "search query must be at least %d characters", minSearchLength)
+	}
+
+	terms := searchTerms(query)
+	if len(terms) == 0 {
+		return nil, Validation("invalid_search_query", "search query must contain letters or digits")
+	}
+	return terms, nil
+}
+
+// searchRank scores a user's name and email against a search the way
+// Repository.SearchUsers does, for stores without full-text and trigram
+// indexes. A user matches if every term starts a word, the query occurs
+// in a field, or a field is similar to the query; ok is false otherwise.
+// The ranks approximate those of Postgres.
+func searchRank(firstName, lastName, email, query string, terms []string) (rank float64, ok bool) {
+	fields := []string{firstName, lastName, email}
+	needle := strings.ToLower(query)
+	contains := false
+	for _, field := range fields {
+		if sim := trigramSimilarity(field, query); sim > rank {
+			rank = sim
+		}
+		if strings.Contains(strings.ToLower(field), needle) {
+			contains = true
+		}
+	}
+	prefixed := len(terms) > 0 && matchesAllPrefixes(fields, terms)
+
+	if !prefixed && !contains && rank < trigramThreshold {
+		return 0, false
+	}
+	if prefixed {
+		rank += 0.1
+	}
+	return rank, true
+}
+
+// matchesAllPrefixes reports whether every term starts a word of the
+// fields, like the prefix tsquery of SearchUsers. The email is one word.
+func matchesAllPrefixes(fields []string, terms []string) bool {
+	var words []string
+	for i, field := range fields {
+		if i == len(fields)-1 {
+			words = append(words, strings.ToLower(field))
+		} else {
+			words = append(words, searchTerms(field)...)
+		}
+	}
+
+	for _, term := range terms {
+		found := false
+		for _, word := range words {
+			if strings.HasPrefix(word, term) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// trigramThreshold is the default pg_trgm similarity threshold of the %
+// operator
+const trigramThreshold = 0.3
+
+// trigramSimilarity computes pg_trgm's similarity(a, b)
+func trigramSimilarity(a, b string) float64 {
+	ta, tb := trigrams(a), trigrams(b)
+	if len(ta) == 0 || len(tb) == 0 {
+		return 0
+	}
+
+	shared := 0
+	for t := range ta {
+		if tb[t] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(ta)+len(tb)-shared)
+}
+
+// trigrams returns the trigrams of each word of s, padded the way pg_trgm
+// pads them
+func trigrams(s string) map[string]bool {
+	set := map[string]bool{}
+	for _, word := range searchTerms(s) {
+		padded := []rune("  " + word + " ")
+		for i := 0; i+3 <= len(padded); i++ {
+			set[string(padded[i:i+3])] = true
+		}
+	}
+	return set
+}
@@ -1,49 +1,45 @@
 package auth
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"strings"
+
+	"goAPI/storage"
 )
 
 type Service struct {
-	repo       *Repository
+	repo       UserStore
 	jwtService *JWTService
+	blobs      storage.BlobStore // nil disables avatar uploads
+	actor      Actor             // Who recorded changes are attributed to
 }
 
-func NewService(repo *Repository, jwtService *JWTService) *Service {
+func NewService(repo UserStore, jwtService *JWTService, blobs storage.BlobStore) *Service {
 	return &Service{
 		repo:       repo,
 		jwtService: jwtService,
+		blobs:      blobs,
 	}
 }
 
-func (s *Service) CreateUser(req CreateUserRequest) (*AuthResponse, error) {
-	// Check if user already exists
-	_, err := s.repo.GetUserByEmail(req.Email)
-	if err == nil {
-		return nil, fmt.Errorf("user with email %s already exists", req.Email)
-	}
-
-	// Create new user
-	user := &User{
-		Email:     req.Email,
-		FirstName: req.FirstName,
-		LastName:  req.LastName,
-		Country:   req.Country,
-		Language:  req.Language,
-	}
-
-	// Hash password
-	if err := user.HashPassword(req.Password); err != nil {
-		return nil, fmt.Errorf("failed to hash password: %w", err)
-	}
+// withTx runs fn with a Service whose store is bound to a single
+// transaction
+func (s *Service) withTx(ctx context.Context, fn func(*Service) error) error {
+	return s.repo.WithTx(ctx, func(repo UserStore) error {
+		return fn(&Service{repo: repo, jwtService: s.jwtService, blobs: s.blobs, actor: s.actor})
+	})
+}
 
-	// Save user
-	if err := s.repo.CreateUser(user); err != nil {
-		return nil, fmt.Errorf("failed to create user: %w", err)
+func (s *Service) CreateUser(ctx context.Context, req CreateUserRequest) (*AuthResponse, error) {
+	user, err := s.createUser(ctx, req)
+	if err != nil {
+		return nil, err
 	}
 
 	// Generate tokens
-	accessToken, refreshToken, err := s.jwtService.GenerateTokens(user)
+	accessToken, refreshToken, err := s.generateTokens(ctx, user)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate tokens: %w", err)
 	}
@@ -55,16 +51,75 @@ func (s *Service) CreateUser(req CreateUserRequest) (*AuthResponse, error) {
 	}, nil
 }
 
-func (s *Service) DeleteUser(req DeleteUserRequest) (*AuthResponse, error) {
+// createUser saves a new user. Email uniqueness is left to the database so
+// concurrent signups with the same email can't both succeed.
+func (s *Service) createUser(ctx context.Context, req CreateUserRequest) (*User, error) {
+	user, err := s.newUser(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.insertUser(ctx, user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// newUser builds the user a create request describes, validating its
+// attributes and hashing its password
+func (s *Service) newUser(ctx context.Context, req CreateUserRequest) (*User, error) {
+	if err := s.validateAttributes(ctx, req.Attributes); err != nil {
+		return nil, err
+	}
+
+	user := &User{
+		Email:      req.Email,
+		FirstName:  req.FirstName,
+		LastName:   req.LastName,
+		Country:    req.Country,
+		Language:   req.Language,
+		Attributes: req.Attributes,
+	}
+
+	// Hash password
+	if err := user.HashPassword(req.Password); err != nil {
+		return nil, fmt.Errorf("failed to hash password: %w", err)
+	}
+	return user, nil
+}
+
+// insertUser saves a user built by newUser and records its creation
+func (s *Service) insertUser(ctx context.Context, user *User) error {
+	return s.withTx(ctx, func(tx *Service) error {
+		if err := tx.repo.CreateUser(ctx, user); err != nil {
+			if errors.Is(err, ErrEmailTaken) {
+				return err
+			}
+			return fmt.Errorf("failed to create user: %w", err)
+		}
+		return tx.recordChange(ctx, ChangeCreate, nil, user.ID)
+	})
+}
+
+func (s *Service) DeleteUser(ctx context.Context, req DeleteUserRequest) (*AuthResponse, error) {
 	// Get user by ID
-	user, err := s.repo.GetUserByID(req.ID)
+	user, err := s.repo.GetUserByID(ctx, req.ID)
 	if err != nil {
-		return nil, fmt.Errorf("user not found")
+		return nil, err
+	}
+
+	if req.ExpectedVersion != nil && user.Version != *req.ExpectedVersion {
+		return nil, ErrPreconditionFailed
 	}
 
 	// Delete user
-	if err := s.repo.DeleteUser(user.ID); err != nil {
-		return nil, fmt.Errorf("failed to delete user: %w", err)
+	err = s.withTx(ctx, func(tx *Service) error {
+		if err := tx.repo.DeleteUser(ctx, user.ID, user.Version); err != nil {
+			return fmt.Errorf("failed to delete user: %w", err)
+		}
+		return tx.recordChange(ctx, ChangeDeactivate, user, user.ID)
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	return &AuthResponse{
@@ -74,20 +129,70 @@ func (s *Service) DeleteUser(req DeleteUserRequest) (*AuthResponse, error) {
 	}, nil
 }
 
-func (s *Service) Login(req LoginRequest) (*AuthResponse, error) {
+// RestoreUser reactivates a deactivated user. Anonymized users can't be
+// restored because their personal data is gone.
+func (s *Service) RestoreUser(ctx context.Context, id int) (*User, error) {
+	user, err := s.repo.GetUserByIDIncludingInactive(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.IsActive {
+		return nil, Conflict("user_active", "user is already active")
+	}
+	if user.AnonymizedAt != nil {
+		return nil, Conflict("user_anonymized", "anonymized users cannot be restored")
+	}
+
+	err = s.withTx(ctx, func(tx *Service) error {
+		if err := tx.repo.SetUserActive(ctx, user.ID, true); err != nil {
+			return fmt.Errorf("failed to restore user: %w", err)
+		}
+		return tx.recordChange(ctx, ChangeRestore, user, user.ID)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.repo.GetUserByID(ctx, user.ID)
+}
+
+// PurgeUser permanently deletes a user. Only deactivated users can be
+// purged, so an account must always be deactivated first.
+func (s *Service) PurgeUser(ctx context.Context, id int) error {
+	user, err := s.repo.GetUserByIDIncludingInactive(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if user.IsActive {
+		return Conflict("user_active", "only deactivated users can be purged")
+	}
+
+	if err := s.repo.PurgeUser(ctx, user.ID); err != nil {
+		return fmt.Errorf("failed to purge user: %w", err)
+	}
+
+	return s.deleteAvatarFiles(user.ID)
+}
+
+func (s *Service) Login(ctx context.Context, req LoginRequest) (*AuthResponse, error) {
 	// Get user by email
-	user, err := s.repo.GetUserByEmail(req.Email)
+	user, err := s.repo.GetUserByEmail(ctx, req.Email)
 	if err != nil {
-		return nil, fmt.Errorf("invalid credentials")
+		if errors.Is(err, ErrUserNotFound) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
 	}
 
 	// Check password
 	if !user.CheckPassword(req.Password) {
-		return nil, fmt.Errorf("invalid credentials")
+		return nil, ErrInvalidCredentials
 	}
 
 	// Generate tokens
-	accessToken, refreshToken, err := s.jwtService.GenerateTokens(user)
+	accessToken, refreshToken, err := s.generateTokens(ctx, user)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate tokens: %w", err)
 	}
@@ -99,27 +204,57 @@ func (s *Service) Login(req LoginRequest) (*AuthResponse, error) {
 	}, nil
 }
 
-func (s *Service) UpdateUser(req UpdateUserRequest) (*AuthResponse, error) {
+func (s *Service) UpdateUser(ctx context.Context, req UpdateUserRequest) (*AuthResponse, error) {
 	// Get user by ID
-	user, err := s.repo.GetUserByID(req.ID)
+	user, err := s.repo.GetUserByID(ctx, req.ID)
 	if err != nil {
-		return nil, fmt.Errorf("user not found")
+		return nil, err
 	}
 
-	// Update user fields
-	user.Email = req.Email
-	user.FirstName = req.FirstName
-	user.LastName = req.LastName
-	user.Country = req.Country
-	user.Language = req.Language
+	if req.ExpectedVersion != nil && user.Version != *req.ExpectedVersion {
+		return nil, ErrPreconditionFailed
+	}
+	before := *user
+
+	// Update only the fields present in the request
+	if req.Email != nil {
+		user.Email = *req.Email
+	}
+	if req.FirstName != nil {
+		user.FirstName = *req.FirstName
+	}
+	if req.LastName != nil {
+		user.LastName = *req.LastName
+	}
+	if req.Country != nil {
+		user.Country = *req.Country
+	}
+	if req.Language != nil {
+		user.Language = *req.Language
+	}
+	if req.Attributes != nil {
+		if err := s.validateAttributes(ctx, req.Attributes); err != nil {
+			return nil, err
+		}
+		user.Attributes = req.Attributes
+	}
 
 	// Save updated user
-	if err := s.repo.UpdateUser(user); err != nil {
-		return nil, fmt.Errorf("failed to update user: %w", err)
+	err = s.withTx(ctx, func(tx *Service) error {
+		if err := tx.repo.UpdateUser(ctx, user); err != nil {
+			if errors.Is(err, ErrEmailTaken) {
+				return err
+			}
+			return fmt.Errorf("failed to update user: %w", err)
+		}
+		return tx.recordChange(ctx, ChangeUpdate, &before, user.ID)
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	// Generate tokens
-	accessToken, refreshToken, err := s.jwtService.GenerateTokens(user)
+	accessToken, refreshToken, err := s.generateTokens(ctx, user)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate tokens: %w", err)
 	}
@@ -131,8 +266,44 @@ func (s *Service) UpdateUser(req UpdateUserRequest) (*AuthResponse, error) {
 	}, nil
 }
 
-func (s *Service) ViewUsers() ([]User, error) {
-	users, err := s.repo.GetAllUsers()
+func (s *Service) GetUser(ctx context.Context, id int) (*User, error) {
+	user, err := s.repo.GetUserByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// ApplyPatch applies a JSON Merge Patch or JSON Patch document to the
+// user's editable fields and returns the resulting update request
+func (s *Service) ApplyPatch(user *User, contentType string, patch []byte) (UpdateUserRequest, error) {
+	var err error
+	var doc interface{} = patchDocument(user)
+	switch contentType {
+	case JSONPatchContentType:
+		doc, err = applyJSONPatch(doc, patch)
+	default:
+		doc, err = applyMergePatch(doc, patch)
+	}
+	if err != nil {
+		return UpdateUserRequest{}, invalidPatch(err)
+	}
+
+	req, err := updateRequestFromDocument(user.ID, doc)
+	if err != nil {
+		return req, invalidPatch(err)
+	}
+
+	// Only revalidate attributes when the patch actually changed them
+	if sameAttributes(req.Attributes, user.Attributes) {
+		req.Attributes = nil
+	}
+	return req, nil
+}
+
+func (s *Service) ViewUsers(ctx context.Context) ([]User, error) {
+	users, err := s.repo.GetAllUsers(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get users: %w", err)
 	}
@@ -140,21 +311,76 @@ func (s *Service) ViewUsers() ([]User, error) {
 	return users, nil
 }
 
-func (s *Service) RefreshToken(req RefreshTokenRequest) (*AuthResponse, error) {
+func (s *Service) ListUsers(ctx context.Context, params ListUsersParams) (*UserPage, error) {
+	if err := params.normalize(); err != nil {
+		return nil, err
+	}
+	if err := s.typeAttributeFilters(ctx, &params); err != nil {
+		return nil, err
+	}
+
+	page, err := s.repo.ListUsers(ctx, params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+
+	return page, nil
+}
+
+func (s *Service) SearchUsers(ctx context.Context, query string, limit int) ([]UserSearchResult, error) {
+	terms, err := validateSearchQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+
+	results, err := s.repo.SearchUsers(ctx, strings.TrimSpace(query), terms, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search users: %w", err)
+	}
+
+	for i := range results {
+		user := &results[i].User
+		highlights := map[string]string{}
+		for field, value := range map[string]string{
+			"first_name": user.FirstName,
+			"last_name":  user.LastName,
+			"email":      user.Email,
+		} {
+			if marked, ok := highlight(value, terms); ok {
+				highlights[field] = marked
+			}
+		}
+		results[i].Highlights = highlights
+	}
+
+	return results, nil
+}
+
+func (s *Service) RefreshToken(ctx context.Context, req RefreshTokenRequest) (*AuthResponse, error) {
 	// Validate refresh token
 	claims, err := s.jwtService.ValidateToken(req.RefreshToken)
 	if err != nil {
-		return nil, fmt.Errorf("invalid refresh token")
+		return nil, ErrInvalidToken
 	}
 
 	// Get user
-	user, err := s.repo.GetUserByID(claims.UserID)
+	user, err := s.repo.GetUserByID(ctx, claims.UserID)
 	if err != nil {
-		return nil, fmt.Errorf("user not found")
+		if errors.Is(err, ErrUserNotFound) {
+			return nil, ErrInvalidToken
+		}
+		return nil, err
 	}
 
 	// Generate new tokens
-	accessToken, refreshToken, err := s.jwtService.GenerateTokens(user)
+	accessToken, refreshToken, err := s.generateTokens(ctx, user)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate tokens: %w", err)
 	}
@@ -0,0 +1,8 @@
+//go:build cgo
+
+package auth
+
+// SQLiteSupported reports whether OpenSQLite can work in this binary.
+// go-sqlite3 is a cgo package; without cgo it builds as a stub that fails
+// on first use.
+const SQLiteSupported = true
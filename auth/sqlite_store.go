@@ -0,0 +1,820 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// sqliteDriver is go-sqlite3 with the functions the SQLite store's queries
+// use in place of Postgres operators
+const sqliteDriver = "sqlite3_goapi"
+
+func init() {
+	sql.Register(sqliteDriver, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			// json_contains(a, b) is a @> b on JSON text
+			err := conn.RegisterFunc("json_contains", func(have, want string) (bool, error) {
+				var h, w interface{}
+				if err := json.Unmarshal([]byte(have), &h); err != nil {
+					return false, err
+				}
+				if err := json.Unmarshal([]byte(want), &w); err != nil {
+					return false, err
+				}
+				return jsonContains(h, w), nil
+			}, true)
+			if err != nil {
+				return err
+			}
+
+			// search_rank returns the searchRank of a row, or -1 if it
+			// doesn't match. terms are separated by spaces.
+			return conn.RegisterFunc("search_rank", func(firstName, lastName, email, query, terms string) float64 {
+				rank, ok := searchRank(firstName, lastName, email, query, strings.Fields(terms))
+				if !ok {
+					return -1
+				}
+				return rank
+			}, true)
+		},
+	})
+}
+
+// sqliteTimeFormat keeps timestamps at a fixed width so that they sort as
+// text in time order
+const sqliteTimeFormat = "2006-01-02T15:04:05.000000Z"
+
+func sqliteTime(t time.Time) string {
+	return t.UTC().Format(sqliteTimeFormat)
+}
+
+// OpenSQLite opens the SQLite database at path, creating the file if
+// needed; its schema is managed by migrations.NewSQLite. ":memory:" opens a
+// private in-memory database.
+func OpenSQLite(path string) (*sql.DB, error) {
+	db, err := sql.Open(sqliteDriver, "file:"+path+"?_foreign_keys=on&_busy_timeout=5000&_journal_mode=WAL")
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite allows a single writer, and an in-memory database exists only
+	// on its connection
+	db.SetMaxOpenConns(1)
+	return db, nil
+}
+
+// SQLiteStore is the SQLite UserStore, for small deployments and local
+// development. SCIM groups are only stored in Postgres, so users belong to
+// no groups.
+type SQLiteStore struct {
+	db      dbtx
+	conn    *sql.DB       // nil when the store is bound to a transaction
+	timeout time.Duration // Bounds each call; zero leaves it to the caller's context
+}
+
+func NewSQLiteStore(db *sql.DB, timeout time.Duration) *SQLiteStore {
+	return &SQLiteStore{db: db, conn: db, timeout: timeout}
+}
+
+// WithTx runs fn with a store bound to a single transaction, which is
+// committed if fn succeeds and rolled back otherwise. Calls on a store that
+// is already in a transaction join it.
+func (s *SQLiteStore) WithTx(ctx context.Context, fn func(UserStore) error) error {
+	if s.conn == nil {
+		return fn(s)
+	}
+
+	tx, err := s.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	if err := fn(&SQLiteStore{db: tx, timeout: s.timeout}); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) CreateUser(ctx context.Context, user *User) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		INSERT INTO users (email, password_hash, first_name, last_name, country, language, is_active, created_at, updated_at, attributes, external_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		RETURNING id, version`
+
+	now := storeNow()
+	user.Email = NormalizeEmail(user.Email)
+	user.CreatedAt = now
+	user.UpdatedAt = now
+	user.IsActive = true
+
+	if user.Language == "" {
+		user.Language = "en"
+	}
+
+	err := s.db.QueryRowContext(ctx, query, user.Email, user.Password, user.FirstName,
+		user.LastName, user.Country, user.Language, user.IsActive,
+		sqliteTime(now), sqliteTime(now), user.Attributes, user.ExternalID).Scan(&user.ID, &user.Version)
+
+	if err != nil {
+		if isSQLiteUniqueViolation(err) {
+			return ErrEmailTaken
+		}
+		return fmt.Errorf("failed to create user: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) GetUserByID(ctx context.Context, id int) (*User, error) {
+	return s.getUser(ctx, `WHERE id = ? AND is_active = TRUE`, id)
+}
+
+func (s *SQLiteStore) GetUserByIDIncludingInactive(ctx context.Context, id int) (*User, error) {
+	return s.getUser(ctx, `WHERE id = ?`, id)
+}
+
+// GetUserByEmail finds an active user by email, ignoring case
+func (s *SQLiteStore) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	return s.getUser(ctx, `WHERE lower(email) = ? AND is_active = TRUE`, NormalizeEmail(email))
+}
+
+func (s *SQLiteStore) getUser(ctx context.Context, where string, args ...interface{}) (*User, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	user, err := scanUser(s.db.QueryRowContext(ctx, `SELECT `+userColumns+` FROM users `+where, args...))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrUserNotFound
+		}
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	return user, nil
+}
+
+func (s *SQLiteStore) GetAllUsers(ctx context.Context) ([]User, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	users, err := queryUsers(ctx, s.db, `SELECT `+userColumns+` FROM users WHERE is_active = TRUE ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get users: %w", err)
+	}
+	return users, nil
+}
+
+// ListUsers returns one page of users using keyset pagination
+func (s *SQLiteStore) ListUsers(ctx context.Context, params ListUsersParams) (*UserPage, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	where, args := sqliteFilterClause(params)
+
+	cur, err := params.pageCursor()
+	if err != nil {
+		return nil, err
+	}
+
+	column := sortColumns[params.Sort]
+	descending := params.Order == "desc"
+	if cur != nil && cur.Backwards {
+		descending = !descending
+	}
+
+	if cur != nil {
+		op := ">"
+		if descending {
+			op = "<"
+		}
+		if column == "id" {
+			args = append(args, cur.ID)
+			where = append(where, "id "+op+" ?")
+		} else {
+			value := cur.Value
+			if column == "created_at" {
+				t, err := time.Parse(time.RFC3339Nano, value)
+				if err != nil {
+					return nil, Validation("invalid_cursor", "invalid cursor")
+				}
+				value = sqliteTime(t)
+			}
+			args = append(args, value, cur.ID)
+			where = append(where, fmt.Sprintf("(%s, id) %s (?, ?)", column, op))
+		}
+	}
+
+	direction := "ASC"
+	if descending {
+		direction = "DESC"
+	}
+	orderBy := fmt.Sprintf("%s %s, id %s", column, direction, direction)
+	if column == "id" {
+		orderBy = "id " + direction
+	}
+
+	args = append(args, params.Limit+1, params.Offset)
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM users
+		%s
+		ORDER BY %s
+		LIMIT ? OFFSET ?`, userColumns, whereSQL(where), orderBy)
+
+	users, err := queryUsers(ctx, s.db, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+
+	page := newUserPage(users, params, cur)
+
+	if params.IncludeTotal {
+		where, args := sqliteFilterClause(params)
+		var total int
+		query := `SELECT COUNT(*) FROM users ` + whereSQL(where)
+		if err := s.db.QueryRowContext(ctx, query, args...).Scan(&total); err != nil {
+			return nil, fmt.Errorf("failed to count users: %w", err)
+		}
+		page.Total = &total
+	}
+
+	return page, nil
+}
+
+// sqliteStreamBatch is how many users StreamUsers reads at a time
+const sqliteStreamBatch = 500
+
+// StreamUsers calls fn for every user matching the listing filters, in ID
+// order. The store has a single connection, which fn may need, so users
+// are read in batches of sqliteStreamBatch between calls rather than
+// through one open query. Users changed during the stream are seen as
+// they are when their batch is read.
+func (s *SQLiteStore) StreamUsers(ctx context.Context, params ListUsersParams, fn func(*User) error) error {
+	where, args := sqliteFilterClause(params)
+	where = append(where, "id > ?")
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM users
+		%s
+		ORDER BY id
+		LIMIT %d`, userColumns, whereSQL(where), sqliteStreamBatch)
+
+	after := 0
+	for {
+		users, err := s.streamBatch(ctx, query, append(args, after)...)
+		if err != nil {
+			return fmt.Errorf("failed to query users: %w", err)
+		}
+
+		for i := range users {
+			if err := fn(&users[i]); err != nil {
+				return err
+			}
+		}
+		if len(users) < sqliteStreamBatch {
+			return nil
+		}
+		after = users[len(users)-1].ID
+	}
+}
+
+// streamBatch reads one batch for StreamUsers, bounded by the query
+// timeout like any other statement
+func (s *SQLiteStore) streamBatch(ctx context.Context, query string, args ...interface{}) ([]User, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	return queryUsers(ctx, s.db, query, args...)
+}
+
+// SearchUsers finds active users matching the query as ranked by
+// searchRank
+func (s *SQLiteStore) SearchUsers(ctx context.Context, query string, terms []string, limit int) ([]UserSearchResult, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	sqlQuery := `
+		SELECT ` + userColumns + `,
+		       search_rank(first_name, last_name, email, ?1, ?2) AS rank
+		FROM users
+		WHERE is_active = TRUE AND search_rank(first_name, last_name, email, ?1, ?2) >= 0
+		ORDER BY rank DESC, id
+		LIMIT ?3`
+
+	rows, err := s.db.QueryContext(ctx, sqlQuery, query, strings.Join(terms, " "), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search users: %w", err)
+	}
+	defer rows.Close()
+
+	results := []UserSearchResult{}
+	for rows.Next() {
+		var result UserSearchResult
+		user, err := scanUser(rows, &result.Rank)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan user: %w", err)
+		}
+		result.User = *user
+		results = append(results, result)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate rows: %w", err)
+	}
+
+	return results, nil
+}
+
+// DeleteUser deactivates the user, provided it is still at the given
+// version
+func (s *SQLiteStore) DeleteUser(ctx context.Context, id, version int) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		UPDATE users
+		SET is_active = FALSE, deactivated_at = ?1, updated_at = ?1, version = version + 1
+		WHERE id = ?2 AND version = ?3`
+
+	result, err := s.db.ExecContext(ctx, query, sqliteTime(storeNow()), id, version)
+	if err != nil {
+		return fmt.Errorf("failed to delete user: %w", err)
+	}
+
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrPreconditionFailed
+	}
+
+	return nil
+}
+
+// UpdateUser saves the user's profile if the row is still at user.Version
+func (s *SQLiteStore) UpdateUser(ctx context.Context, user *User) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		UPDATE users
+		SET email = ?, first_name = ?, last_name = ?,
+		    country = ?, language = ?, attributes = ?, external_id = ?, updated_at = ?, version = version + 1
+		WHERE id = ? AND version = ?
+		RETURNING version`
+
+	now := storeNow()
+	user.Email = NormalizeEmail(user.Email)
+	err := s.db.QueryRowContext(ctx, query, user.Email, user.FirstName,
+		user.LastName, user.Country, user.Language, user.Attributes, user.ExternalID,
+		sqliteTime(now), user.ID, user.Version).Scan(&user.Version)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrPreconditionFailed
+		}
+		if isSQLiteUniqueViolation(err) {
+			return ErrEmailTaken
+		}
+		return fmt.Errorf("failed to update user: %w", err)
+	}
+	user.UpdatedAt = now
+	return nil
+}
+
+// SetUserActive activates or deactivates a user without touching other fields
+func (s *SQLiteStore) SetUserActive(ctx context.Context, id int, active bool) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		UPDATE users
+		SET is_active = ?1, updated_at = ?2, version = version + 1,
+		    deactivated_at = CASE WHEN ?1 THEN NULL ELSE COALESCE(deactivated_at, ?2) END
+		WHERE id = ?3`
+
+	_, err := s.db.ExecContext(ctx, query, active, sqliteTime(storeNow()), id)
+	if err != nil {
+		return fmt.Errorf("failed to update user status: %w", err)
+	}
+
+	return nil
+}
+
+// PurgeUser permanently deletes a deactivated user
+func (s *SQLiteStore) PurgeUser(ctx context.Context, id int) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	result, err := s.db.ExecContext(ctx, `DELETE FROM users WHERE id = ? AND is_active = FALSE`, id)
+	if err != nil {
+		return fmt.Errorf("failed to purge user: %w", err)
+	}
+
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+// AnonymizeUser replaces the personal data of a deactivated user with
+// placeholders, keeping the row so references to it stay valid
+func (s *SQLiteStore) AnonymizeUser(ctx context.Context, id int) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		UPDATE users
+		SET ` + sqliteAnonymizeAssignments + `
+		WHERE id = ?2 AND is_active = FALSE`
+
+	result, err := s.db.ExecContext(ctx, query, sqliteTime(storeNow()), id)
+	if err != nil {
+		return fmt.Errorf("failed to anonymize user: %w", err)
+	}
+
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+// PurgeDeactivatedBefore permanently deletes users deactivated before the
+// cutoff and returns their IDs
+func (s *SQLiteStore) PurgeDeactivatedBefore(ctx context.Context, cutoff time.Time) ([]int, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	ids, err := queryIDs(ctx, s.db, `
+		DELETE FROM users
+		WHERE is_active = FALSE AND deactivated_at < ?
+		RETURNING id`, sqliteTime(cutoff))
+	if err != nil {
+		return nil, fmt.Errorf("failed to purge users: %w", err)
+	}
+
+	sort.Ints(ids)
+	return ids, nil
+}
+
+// AnonymizeDeactivatedBefore anonymizes users deactivated before the cutoff
+// that have not been anonymized yet and returns their IDs
+func (s *SQLiteStore) AnonymizeDeactivatedBefore(ctx context.Context, cutoff time.Time) ([]int, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		UPDATE users
+		SET ` + sqliteAnonymizeAssignments + `
+		WHERE is_active = FALSE AND deactivated_at < ?2 AND anonymized_at IS NULL
+		RETURNING id`
+
+	ids, err := queryIDs(ctx, s.db, query, sqliteTime(storeNow()), sqliteTime(cutoff))
+	if err != nil {
+		return nil, fmt.Errorf("failed to anonymize users: %w", err)
+	}
+
+	sort.Ints(ids)
+	return ids, nil
+}
+
+// SetUserAvatar stores the URLs of the user's avatar renditions if the row
+// is still at version; nil removes the avatar
+func (s *SQLiteStore) SetUserAvatar(ctx context.Context, id, version int, urls AvatarURLs) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		UPDATE users
+		SET avatar_urls = ?, updated_at = ?, version = version + 1
+		WHERE id = ? AND version = ?`
+
+	result, err := s.db.ExecContext(ctx, query, urls, sqliteTime(storeNow()), id, version)
+	if err != nil {
+		return fmt.Errorf("failed to update avatar: %w", err)
+	}
+
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrPreconditionFailed
+	}
+
+	return nil
+}
+
+// UpdatePassword stores a new password hash for the user
+func (s *SQLiteStore) UpdatePassword(ctx context.Context, id int, passwordHash string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		UPDATE users
+		SET password_hash = ?, updated_at = ?, version = version + 1
+		WHERE id = ?`
+
+	_, err := s.db.ExecContext(ctx, query, passwordHash, sqliteTime(storeNow()), id)
+	if err != nil {
+		return fmt.Errorf("failed to update password: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SQLiteStore) GetUserGroups(ctx context.Context, id int) ([]GroupMembership, error) {
+	return []GroupMembership{}, nil
+}
+
+func (s *SQLiteStore) GetGroupsForUsers(ctx context.Context, ids []int) (map[int][]GroupMembership, error) {
+	groups := make(map[int][]GroupMembership, len(ids))
+	for _, id := range ids {
+		groups[id] = []GroupMembership{}
+	}
+	return groups, nil
+}
+
+func (s *SQLiteStore) RemoveUserFromGroups(ctx context.Context, id int) error {
+	return nil
+}
+
+func (s *SQLiteStore) CreatePrivacyRequest(ctx context.Context, req *PrivacyRequest) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		INSERT INTO privacy_requests (user_id, kind, requested_by, created_at)
+		VALUES (?, ?, ?, ?)
+		RETURNING id`
+
+	req.CreatedAt = storeNow()
+	err := s.db.QueryRowContext(ctx, query, req.UserID, req.Kind, req.RequestedBy,
+		sqliteTime(req.CreatedAt)).Scan(&req.ID)
+	if err != nil {
+		return fmt.Errorf("failed to record privacy request: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SQLiteStore) GetPrivacyRequests(ctx context.Context, userID int) ([]PrivacyRequest, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT id, user_id, kind, requested_by, created_at
+		FROM privacy_requests
+		WHERE user_id = ?
+		ORDER BY id`
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get privacy requests: %w", err)
+	}
+	defer rows.Close()
+
+	requests := []PrivacyRequest{}
+	for rows.Next() {
+		var req PrivacyRequest
+		if err := rows.Scan(&req.ID, &req.UserID, &req.Kind,
+			&req.RequestedBy, &req.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan privacy request: %w", err)
+		}
+		requests = append(requests, req)
+	}
+
+	return requests, rows.Err()
+}
+
+// RecordUserChange stores a history entry for the user's current state,
+// diffed against before. before is nil for a new user.
+func (s *SQLiteStore) RecordUserChange(ctx context.Context, action string, before *User, id int, actor Actor) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	after, err := s.GetUserByIDIncludingInactive(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	snapshot := snapshotOf(after)
+	var previous *UserSnapshot
+	if before != nil {
+		snap := snapshotOf(before)
+		previous = &snap
+	}
+
+	changes, err := json.Marshal(diffSnapshots(previous, snapshot))
+	if err != nil {
+		return fmt.Errorf("failed to encode changes: %w", err)
+	}
+	snapshotJSON, err := json.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("failed to encode snapshot: %w", err)
+	}
+
+	if actor.Source == "" {
+		actor.Source = SourceSystem
+	}
+
+	query := `
+		INSERT INTO user_changes (user_id, version, action, actor_id, actor_email,
+		                          source, request_id, changes, snapshot, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	_, err = s.db.ExecContext(ctx, query, id, after.Version, action, nullInt(actor.UserID),
+		nullString(actor.Email), actor.Source, nullString(actor.RequestID),
+		string(changes), string(snapshotJSON), sqliteTime(storeNow()))
+	if err != nil {
+		return fmt.Errorf("failed to record change: %w", err)
+	}
+
+	return nil
+}
+
+// ListUserChanges returns up to limit history entries for the user, newest
+// first, starting below beforeID when it is non-zero
+func (s *SQLiteStore) ListUserChanges(ctx context.Context, userID, limit int, beforeID int64) ([]UserChange, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT id, user_id, version, action, actor_id, actor_email, source,
+		       request_id, changes, snapshot, created_at
+		FROM user_changes
+		WHERE user_id = ?1 AND (?2 = 0 OR id < ?2)
+		ORDER BY id DESC
+		LIMIT ?3`
+
+	rows, err := s.db.QueryContext(ctx, query, userID, beforeID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user history: %w", err)
+	}
+	defer rows.Close()
+
+	changes := []UserChange{}
+	for rows.Next() {
+		change, err := scanUserChange(rows)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, *change)
+	}
+
+	return changes, rows.Err()
+}
+
+// GetUserSnapshot returns the user's state as of the given version, taken
+// from the latest change at or before it
+func (s *SQLiteStore) GetUserSnapshot(ctx context.Context, userID, version int) (*UserSnapshot, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT snapshot
+		FROM user_changes
+		WHERE user_id = ? AND version <= ?
+		ORDER BY version DESC, id DESC
+		LIMIT 1`
+
+	var data []byte
+	err := s.db.QueryRowContext(ctx, query, userID, version).Scan(&data)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, NotFound("version_not_found", "no history recorded for version %d", version)
+		}
+		return nil, fmt.Errorf("failed to get user history: %w", err)
+	}
+
+	snapshot := &UserSnapshot{}
+	if err := json.Unmarshal(data, snapshot); err != nil {
+		return nil, fmt.Errorf("invalid snapshot: %w", err)
+	}
+	return snapshot, nil
+}
+
+// DeleteUserChanges removes the history of the given users, which holds
+// their previous personal data
+func (s *SQLiteStore) DeleteUserChanges(ctx context.Context, ids ...int) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+
+	_, err := s.db.ExecContext(ctx, `DELETE FROM user_changes WHERE user_id IN (`+placeholders+`)`, args...)
+	if err != nil {
+		return fmt.Errorf("failed to delete user history: %w", err)
+	}
+
+	return nil
+}
+
+// GetAttributeSchema returns the stored attribute schema, or nil if none
+// has been saved
+func (s *SQLiteStore) GetAttributeSchema(ctx context.Context) ([]byte, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	var schema []byte
+	err := s.db.QueryRowContext(ctx, `SELECT schema FROM user_attribute_schema WHERE id = 1`).Scan(&schema)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get attribute schema: %w", err)
+	}
+
+	return schema, nil
+}
+
+func (s *SQLiteStore) SaveAttributeSchema(ctx context.Context, schema []byte) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		INSERT INTO user_attribute_schema (id, schema, updated_at)
+		VALUES (1, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET schema = excluded.schema, updated_at = excluded.updated_at`
+
+	_, err := s.db.ExecContext(ctx, query, string(schema), sqliteTime(storeNow()))
+	if err != nil {
+		return fmt.Errorf("failed to save attribute schema: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SQLiteStore) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withTimeout(ctx, s.timeout)
+}
+
+// sqliteAnonymizeAssignments overwrites personal data; ?1 is the current time
+const sqliteAnonymizeAssignments = `email = 'deleted-' || id || '@anonymized.invalid',
+		    first_name = 'Deleted', last_name = 'User', password_hash = '',
+		    attributes = '{}', avatar_urls = NULL, external_id = '',
+		    anonymized_at = ?1, updated_at = ?1, version = version + 1`
+
+// sqliteFilterClause builds the WHERE conditions shared by user listings,
+// like userFilterClause
+func sqliteFilterClause(params ListUsersParams) ([]string, []interface{}) {
+	var where []string
+	var args []interface{}
+
+	if params.Active != nil {
+		args = append(args, *params.Active)
+		where = append(where, "is_active = ?")
+	}
+	if params.Email != "" {
+		args = append(args, NormalizeEmail(params.Email))
+		where = append(where, "lower(email) = ?")
+	}
+	if params.ExternalID != "" {
+		args = append(args, params.ExternalID)
+		where = append(where, "external_id = ?")
+	}
+	if params.Country != "" {
+		args = append(args, params.Country)
+		where = append(where, "country = ?")
+	}
+	if params.Language != "" {
+		args = append(args, params.Language)
+		where = append(where, "language = ?")
+	}
+	if params.CreatedAfter != nil {
+		args = append(args, sqliteTime(*params.CreatedAfter))
+		where = append(where, "created_at >= ?")
+	}
+	if params.CreatedBefore != nil {
+		args = append(args, sqliteTime(*params.CreatedBefore))
+		where = append(where, "created_at < ?")
+	}
+	if len(params.Attributes) > 0 {
+		args = append(args, Attributes(params.Attributes))
+		where = append(where, "json_contains(attributes, ?)")
+	}
+
+	return where, args
+}
+
+// isSQLiteUniqueViolation reports whether err is a SQLite unique constraint
+// violation. The message is checked rather than the error code, which is
+// only available when the driver is built with cgo.
+func isSQLiteUniqueViolation(err error) bool {
+	return strings.Contains(err.Error(), "UNIQUE constraint failed")
+}
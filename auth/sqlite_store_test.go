@@ -0,0 +1,29 @@
+package auth_test
+
+import (
+	"testing"
+	"time"
+
+	"goAPI/auth"
+	"goAPI/auth/storetest"
+	"goAPI/migrations"
+)
+
+func TestSQLiteStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) auth.UserStore {
+		db, err := auth.OpenSQLite(":memory:")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+
+		migrator, err := migrations.NewSQLite(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := migrator.Up(t.Context()); err != nil {
+			t.Fatal(err)
+		}
+		return auth.NewSQLiteStore(db, 5*time.Second)
+	})
+}
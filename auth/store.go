@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"context"
+	"time"
+)
+
+// UserStore persists users and the records kept about them. Repository
+// stores them in Postgres, SQLiteStore in SQLite and MemoryStore in process
+// memory; all follow the same rules, which are checked by the suite in
+// auth/storetest.
+//
+// Lookups of a missing user return ErrUserNotFound, writes that would give
+// two users the same email (ignoring case) return ErrEmailTaken, and
+// versioned writes made against a stale version return
+// ErrPreconditionFailed. Every update bumps the user's version and
+// updated_at.
+type UserStore interface {
+	// WithTx runs fn with a store whose writes are applied together if fn
+	// succeeds and discarded otherwise. Calls on a store that is already in
+	// a transaction join it.
+	WithTx(ctx context.Context, fn func(UserStore) error) error
+
+	CreateUser(ctx context.Context, user *User) error
+	GetUserByID(ctx context.Context, id int) (*User, error)
+	GetUserByIDIncludingInactive(ctx context.Context, id int) (*User, error)
+	GetUserByEmail(ctx context.Context, email string) (*User, error)
+	GetAllUsers(ctx context.Context) ([]User, error)
+	ListUsers(ctx context.Context, params ListUsersParams) (*UserPage, error)
+	StreamUsers(ctx context.Context, params ListUsersParams, fn func(*User) error) error
+	SearchUsers(ctx context.Context, query string, terms []string, limit int) ([]UserSearchResult, error)
+
+	UpdateUser(ctx context.Context, user *User) error
+	UpdatePassword(ctx context.Context, id int, passwordHash string) error
+	SetUserActive(ctx context.Context, id int, active bool) error
+	SetUserAvatar(ctx context.Context, id, version int, urls AvatarURLs) error
+	DeleteUser(ctx context.Context, id, version int) error
+	PurgeUser(ctx context.Context, id int) error
+	AnonymizeUser(ctx context.Context, id int) error
+	PurgeDeactivatedBefore(ctx context.Context, cutoff time.Time) ([]int, error)
+	AnonymizeDeactivatedBefore(ctx context.Context, cutoff time.Time) ([]int, error)
+
+	GetUserGroups(ctx context.Context, id int) ([]GroupMembership, error)
+	GetGroupsForUsers(ctx context.Context, ids []int) (map[int][]GroupMembership, error)
+	RemoveUserFromGroups(ctx context.Context, id int) error
+
+	CreatePrivacyRequest(ctx context.Context, req *PrivacyRequest) error
+	GetPrivacyRequests(ctx context.Context, userID int) ([]PrivacyRequest, error)
+
+	RecordUserChange(ctx context.Context, action string, before *User, id int, actor Actor) error
+	ListUserChanges(ctx context.Context, userID, limit int, beforeID int64) ([]UserChange, error)
+	GetUserSnapshot(ctx context.Context, userID, version int) (*UserSnapshot, error)
+	DeleteUserChanges(ctx context.Context, ids ...int) error
+
+	GetAttributeSchema(ctx context.Context) ([]byte, error)
+	SaveAttributeSchema(ctx context.Context, schema []byte) error
+}
+
+var (
+	_ UserStore = (*Repository)(nil)
+	_ UserStore = (*SQLiteStore)(nil)
+	_ UserStore = (*MemoryStore)(nil)
+)
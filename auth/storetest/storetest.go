@@ -0,0 +1,759 @@
+// Package storetest checks that an auth.UserStore implementation follows
+// the rules every store must share, so the in-memory store can stand in
+// for Postgres.
+package storetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"goAPI/auth"
+)
+
+// Run runs the conformance suite. newStore must return an empty store for
+// each test.
+func Run(t *testing.T, newStore func(t *testing.T) auth.UserStore) {
+	tests := []struct {
+		name string
+		fn   func(*testing.T, auth.UserStore)
+	}{
+		{"CreateAndGet", testCreateAndGet},
+		{"EmailUniqueIgnoringCase", testEmailUniqueIgnoringCase},
+		{"MissingUser", testMissingUser},
+		{"UpdateBumpsVersion", testUpdateBumpsVersion},
+		{"SoftDelete", testSoftDelete},
+		{"Reactivate", testReactivate},
+		{"PurgeAndAnonymize", testPurgeAndAnonymize},
+		{"Retention", testRetention},
+		{"ListUsers", testListUsers},
+		{"ListUsersFilters", testListUsersFilters},
+		{"StreamUsers", testStreamUsers},
+		{"SearchUsers", testSearchUsers},
+		{"History", testHistory},
+		{"Transactions", testTransactions},
+		{"PrivacyRequests", testPrivacyRequests},
+		{"AttributeSchema", testAttributeSchema},
+		{"Avatar", testAvatar},
+		{"Groups", testGroups},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newStore(t))
+		})
+	}
+}
+
+func createUser(t *testing.T, store auth.UserStore, email, firstName, lastName string) *auth.User {
+	t.Helper()
+	user := &auth.User{
+		Email:     email,
+		Password:  "hash",
+		FirstName: firstName,
+		LastName:  lastName,
+		Country:   "US",
+	}
+	if err := store.CreateUser(t.Context(), user); err != nil {
+		t.Fatalf("CreateUser(%s): %v", email, err)
+	}
+	return user
+}
+
+func getUser(t *testing.T, store auth.UserStore, id int) *auth.User {
+	t.Helper()
+	user, err := store.GetUserByIDIncludingInactive(t.Context(), id)
+	if err != nil {
+		t.Fatalf("GetUserByIDIncludingInactive(%d): %v", id, err)
+	}
+	return user
+}
+
+func deactivate(t *testing.T, store auth.UserStore, user *auth.User) {
+	t.Helper()
+	if err := store.DeleteUser(t.Context(), user.ID, getUser(t, store, user.ID).Version); err != nil {
+		t.Fatalf("DeleteUser(%d): %v", user.ID, err)
+	}
+}
+
+func ids(users []auth.User) []int {
+	ids := []int{}
+	for _, user := range users {
+		ids = append(ids, user.ID)
+	}
+	return ids
+}
+
+func equalIDs(a, b []int) bool {
+	return fmt.Sprint(a) == fmt.Sprint(b)
+}
+
+func testCreateAndGet(t *testing.T, store auth.UserStore) {
+	before := time.Now().Add(-time.Second)
+	user := createUser(t, store, " Alice@Example.com ", "Alice", "Smith")
+
+	if user.ID == 0 || user.Version != 1 {
+		t.Fatalf("created user has ID %d and version %d", user.ID, user.Version)
+	}
+	if user.Email != "alice@example.com" {
+		t.Errorf("email = %q, want it normalized", user.Email)
+	}
+
+	got, err := store.GetUserByID(t.Context(), user.ID)
+	if err != nil {
+		t.Fatalf("GetUserByID: %v", err)
+	}
+	if got.Email != "alice@example.com" || got.FirstName != "Alice" || got.LastName != "Smith" ||
+		got.Country != "US" || got.Password != "hash" {
+		t.Errorf("stored user = %+v", got)
+	}
+	if got.Language != "en" {
+		t.Errorf("language = %q, want default en", got.Language)
+	}
+	if !got.IsActive || got.DeactivatedAt != nil || got.AnonymizedAt != nil {
+		t.Errorf("new user is not active: %+v", got)
+	}
+	if got.CreatedAt.Before(before) || !got.UpdatedAt.Equal(got.CreatedAt) {
+		t.Errorf("timestamps = %v, %v", got.CreatedAt, got.UpdatedAt)
+	}
+	if got.Attributes == nil {
+		t.Error("attributes are nil, want empty")
+	}
+
+	second := createUser(t, store, "bob@example.com", "Bob", "Jones")
+	if second.ID <= user.ID {
+		t.Errorf("second ID %d is not after %d", second.ID, user.ID)
+	}
+}
+
+func testEmailUniqueIgnoringCase(t *testing.T, store auth.UserStore) {
+	alice := createUser(t, store, "alice@example.com", "Alice", "Smith")
+	bob := createUser(t, store, "bob@example.com", "Bob", "Jones")
+
+	err := store.CreateUser(t.Context(), &auth.User{Email: "ALICE@example.com", Password: "hash",
+		FirstName: "A", LastName: "S", Country: "US"})
+	if !errors.Is(err, auth.ErrEmailTaken) {
+		t.Errorf("CreateUser with taken email: %v, want ErrEmailTaken", err)
+	}
+
+	bob.Email = "Alice@Example.com"
+	if err := store.UpdateUser(t.Context(), bob); !errors.Is(err, auth.ErrEmailTaken) {
+		t.Errorf("UpdateUser to taken email: %v, want ErrEmailTaken", err)
+	}
+
+	got, err := store.GetUserByEmail(t.Context(), "ALICE@EXAMPLE.COM")
+	if err != nil || got.ID != alice.ID {
+		t.Errorf("GetUserByEmail ignoring case = %v, %v", got, err)
+	}
+
+	// A deactivated user still holds its email
+	deactivate(t, store, alice)
+	err = store.CreateUser(t.Context(), &auth.User{Email: "alice@example.com", Password: "hash",
+		FirstName: "A", LastName: "S", Country: "US"})
+	if !errors.Is(err, auth.ErrEmailTaken) {
+		t.Errorf("CreateUser with email of deactivated user: %v, want ErrEmailTaken", err)
+	}
+}
+
+func testMissingUser(t *testing.T, store auth.UserStore) {
+	if _, err := store.GetUserByID(t.Context(), 999999); !errors.Is(err, auth.ErrUserNotFound) {
+		t.Errorf("GetUserByID: %v, want ErrUserNotFound", err)
+	}
+	if _, err := store.GetUserByIDIncludingInactive(t.Context(), 999999); !errors.Is(err, auth.ErrUserNotFound) {
+		t.Errorf("GetUserByIDIncludingInactive: %v, want ErrUserNotFound", err)
+	}
+	if _, err := store.GetUserByEmail(t.Context(), "nobody@example.com"); !errors.Is(err, auth.ErrUserNotFound) {
+		t.Errorf("GetUserByEmail: %v, want ErrUserNotFound", err)
+	}
+	if err := store.DeleteUser(t.Context(), 999999, 1); !errors.Is(err, auth.ErrPreconditionFailed) {
+		t.Errorf("DeleteUser: %v, want ErrPreconditionFailed", err)
+	}
+	if err := store.UpdateUser(t.Context(), &auth.User{ID: 999999, Version: 1, Email: "x@example.com"}); !errors.Is(err, auth.ErrPreconditionFailed) {
+		t.Errorf("UpdateUser: %v, want ErrPreconditionFailed", err)
+	}
+}
+
+func testUpdateBumpsVersion(t *testing.T, store auth.UserStore) {
+	user := createUser(t, store, "alice@example.com", "Alice", "Smith")
+	created := user.UpdatedAt
+
+	user.FirstName = "Alicia"
+	user.Email = "ALICIA@example.com"
+	user.Attributes = auth.Attributes{"team": "platform"}
+	if err := store.UpdateUser(t.Context(), user); err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+	if user.Version != 2 || user.UpdatedAt.Before(created) {
+		t.Errorf("after update version = %d, updated_at = %v", user.Version, user.UpdatedAt)
+	}
+
+	got := getUser(t, store, user.ID)
+	if got.FirstName != "Alicia" || got.Email != "alicia@example.com" || got.Version != 2 ||
+		got.Attributes["team"] != "platform" {
+		t.Errorf("stored user = %+v", got)
+	}
+
+	stale := *got
+	stale.Version = 1
+	if err := store.UpdateUser(t.Context(), &stale); !errors.Is(err, auth.ErrPreconditionFailed) {
+		t.Errorf("UpdateUser at stale version: %v, want ErrPreconditionFailed", err)
+	}
+
+	if err := store.UpdatePassword(t.Context(), user.ID, "new-hash"); err != nil {
+		t.Fatalf("UpdatePassword: %v", err)
+	}
+	got = getUser(t, store, user.ID)
+	if got.Password != "new-hash" || got.Version != 3 {
+		t.Errorf("after UpdatePassword password = %q, version = %d", got.Password, got.Version)
+	}
+}
+
+func testSoftDelete(t *testing.T, store auth.UserStore) {
+	user := createUser(t, store, "alice@example.com", "Alice", "Smith")
+	other := createUser(t, store, "bob@example.com", "Bob", "Jones")
+
+	if err := store.DeleteUser(t.Context(), user.ID, user.Version+1); !errors.Is(err, auth.ErrPreconditionFailed) {
+		t.Errorf("DeleteUser at wrong version: %v, want ErrPreconditionFailed", err)
+	}
+	if err := store.DeleteUser(t.Context(), user.ID, user.Version); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+
+	if _, err := store.GetUserByID(t.Context(), user.ID); !errors.Is(err, auth.ErrUserNotFound) {
+		t.Errorf("GetUserByID of deactivated user: %v, want ErrUserNotFound", err)
+	}
+	if _, err := store.GetUserByEmail(t.Context(), user.Email); !errors.Is(err, auth.ErrUserNotFound) {
+		t.Errorf("GetUserByEmail of deactivated user: %v, want ErrUserNotFound", err)
+	}
+
+	got := getUser(t, store, user.ID)
+	if got.IsActive || got.DeactivatedAt == nil || got.Version != user.Version+1 {
+		t.Errorf("deactivated user = %+v", got)
+	}
+
+	active, err := store.GetAllUsers(t.Context())
+	if err != nil {
+		t.Fatalf("GetAllUsers: %v", err)
+	}
+	if !equalIDs(ids(active), []int{other.ID}) {
+		t.Errorf("GetAllUsers = %v, want only %d", ids(active), other.ID)
+	}
+
+	if all := allUsers(t, store); !equalIDs(ids(all), []int{user.ID, other.ID}) {
+		t.Errorf("users including inactive = %v", ids(all))
+	}
+}
+
+func testReactivate(t *testing.T, store auth.UserStore) {
+	user := createUser(t, store, "alice@example.com", "Alice", "Smith")
+
+	if err := store.SetUserActive(t.Context(), user.ID, false); err != nil {
+		t.Fatalf("SetUserActive(false): %v", err)
+	}
+	deactivated := getUser(t, store, user.ID)
+	if deactivated.IsActive || deactivated.DeactivatedAt == nil {
+		t.Fatalf("deactivated user = %+v", deactivated)
+	}
+
+	// Deactivating again keeps the original time
+	if err := store.SetUserActive(t.Context(), user.ID, false); err != nil {
+		t.Fatalf("SetUserActive(false): %v", err)
+	}
+	if again := getUser(t, store, user.ID); !again.DeactivatedAt.Equal(*deactivated.DeactivatedAt) {
+		t.Errorf("deactivated_at moved from %v to %v", deactivated.DeactivatedAt, again.DeactivatedAt)
+	}
+
+	if err := store.SetUserActive(t.Context(), user.ID, true); err != nil {
+		t.Fatalf("SetUserActive(true): %v", err)
+	}
+	got := getUser(t, store, user.ID)
+	if !got.IsActive || got.DeactivatedAt != nil || got.Version != 4 {
+		t.Errorf("reactivated user = %+v", got)
+	}
+}
+
+func testPurgeAndAnonymize(t *testing.T, store auth.UserStore) {
+	alice := createUser(t, store, "alice@example.com", "Alice", "Smith")
+	bob := createUser(t, store, "bob@example.com", "Bob", "Jones")
+
+	if err := store.PurgeUser(t.Context(), alice.ID); !errors.Is(err, auth.ErrUserNotFound) {
+		t.Errorf("PurgeUser of active user: %v, want ErrUserNotFound", err)
+	}
+	if err := store.AnonymizeUser(t.Context(), alice.ID); !errors.Is(err, auth.ErrUserNotFound) {
+		t.Errorf("AnonymizeUser of active user: %v, want ErrUserNotFound", err)
+	}
+
+	deactivate(t, store, alice)
+	if err := store.PurgeUser(t.Context(), alice.ID); err != nil {
+		t.Fatalf("PurgeUser: %v", err)
+	}
+	if _, err := store.GetUserByIDIncludingInactive(t.Context(), alice.ID); !errors.Is(err, auth.ErrUserNotFound) {
+		t.Errorf("purged user still found: %v", err)
+	}
+
+	deactivate(t, store, bob)
+	if err := store.AnonymizeUser(t.Context(), bob.ID); err != nil {
+		t.Fatalf("AnonymizeUser: %v", err)
+	}
+	got := getUser(t, store, bob.ID)
+	if got.Email != fmt.Sprintf("deleted-%d@anonymized.invalid", bob.ID) ||
+		got.FirstName != "Deleted" || got.LastName != "User" || got.Password != "" ||
+		got.AnonymizedAt == nil || len(got.Attributes) != 0 {
+		t.Errorf("anonymized user = %+v", got)
+	}
+
+	// Anonymizing frees the email
+	createUser(t, store, "bob@example.com", "Bob", "Jones")
+}
+
+func testRetention(t *testing.T, store auth.UserStore) {
+	active := createUser(t, store, "active@example.com", "Active", "User")
+	first := createUser(t, store, "first@example.com", "First", "User")
+	second := createUser(t, store, "second@example.com", "Second", "User")
+	deactivate(t, store, first)
+	deactivate(t, store, second)
+
+	past := time.Now().Add(-time.Hour)
+	if ids, err := store.AnonymizeDeactivatedBefore(t.Context(), past); err != nil || len(ids) != 0 {
+		t.Errorf("AnonymizeDeactivatedBefore(past) = %v, %v", ids, err)
+	}
+
+	future := time.Now().Add(time.Hour)
+	anonymized, err := store.AnonymizeDeactivatedBefore(t.Context(), future)
+	if err != nil {
+		t.Fatalf("AnonymizeDeactivatedBefore: %v", err)
+	}
+	if len(anonymized) != 2 {
+		t.Errorf("anonymized %v, want %d and %d", anonymized, first.ID, second.ID)
+	}
+	if again, err := store.AnonymizeDeactivatedBefore(t.Context(), future); err != nil || len(again) != 0 {
+		t.Errorf("anonymized again: %v, %v", again, err)
+	}
+
+	purged, err := store.PurgeDeactivatedBefore(t.Context(), future)
+	if err != nil {
+		t.Fatalf("PurgeDeactivatedBefore: %v", err)
+	}
+	if len(purged) != 2 {
+		t.Errorf("purged %v, want %d and %d", purged, first.ID, second.ID)
+	}
+
+	if remaining := allUsers(t, store); !equalIDs(ids(remaining), []int{active.ID}) {
+		t.Errorf("remaining users = %v, want %d", ids(remaining), active.ID)
+	}
+}
+
+func listParams(sort, order string, limit int) auth.ListUsersParams {
+	return auth.ListUsersParams{Sort: sort, Order: order, Limit: limit}
+}
+
+// allUsers lists every user in ID order, active or not
+func allUsers(t *testing.T, store auth.UserStore) []auth.User {
+	t.Helper()
+	page, err := store.ListUsers(t.Context(), listParams("id", "asc", 100))
+	if err != nil {
+		t.Fatalf("ListUsers: %v", err)
+	}
+	return page.Data
+}
+
+func testListUsers(t *testing.T, store auth.UserStore) {
+	// Created out of email order
+	var created []*auth.User
+	for _, name := range []string{"dave", "alice", "erin", "carol", "bob"} {
+		created = append(created, createUser(t, store, name+"@example.com", name, "user"))
+	}
+	byEmail := []int{created[1].ID, created[4].ID, created[3].ID, created[0].ID, created[2].ID}
+
+	for _, order := range []string{"asc", "desc"} {
+		want := append([]int(nil), byEmail...)
+		if order == "desc" {
+			for i, j := 0, len(want)-1; i < j; i, j = i+1, j-1 {
+				want[i], want[j] = want[j], want[i]
+			}
+		}
+
+		params := listParams("email", order, 2)
+		var got []int
+		var pages []*auth.UserPage
+		for {
+			page, err := store.ListUsers(t.Context(), params)
+			if err != nil {
+				t.Fatalf("ListUsers: %v", err)
+			}
+			pages = append(pages, page)
+			got = append(got, ids(page.Data)...)
+			if page.NextCursor == "" {
+				break
+			}
+			params.Cursor = page.NextCursor
+		}
+		if !equalIDs(got, want) {
+			t.Errorf("%s pages = %v, want %v", order, got, want)
+		}
+		if len(pages) != 3 || pages[0].PrevCursor != "" {
+			t.Fatalf("%s listing has %d pages, first prev cursor %q", order, len(pages), pages[0].PrevCursor)
+		}
+
+		// Walking back from the last page returns the middle page
+		params.Cursor = pages[2].PrevCursor
+		page, err := store.ListUsers(t.Context(), params)
+		if err != nil {
+			t.Fatalf("ListUsers backwards: %v", err)
+		}
+		if !equalIDs(ids(page.Data), want[2:4]) {
+			t.Errorf("%s previous page = %v, want %v", order, ids(page.Data), want[2:4])
+		}
+		if page.PrevCursor == "" || page.NextCursor == "" {
+			t.Errorf("%s middle page cursors = %q, %q", order, page.PrevCursor, page.NextCursor)
+		}
+	}
+
+	byCreated := listParams("created_at", "asc", 10)
+	byCreated.IncludeTotal = true
+	page, err := store.ListUsers(t.Context(), byCreated)
+	if err != nil {
+		t.Fatalf("ListUsers by created_at: %v", err)
+	}
+	if !equalIDs(ids(page.Data), ids(derefUsers(created))) || page.Total == nil || *page.Total != 5 {
+		t.Errorf("created_at listing = %v, total %v", ids(page.Data), page.Total)
+	}
+
+	first, err := store.ListUsers(t.Context(), listParams("id", "asc", 2))
+	if err != nil {
+		t.Fatalf("ListUsers: %v", err)
+	}
+	mismatched := listParams("email", "asc", 2)
+	mismatched.Cursor = first.NextCursor
+	_, err = store.ListUsers(t.Context(), mismatched)
+	if appErr, ok := err.(*auth.Error); !ok || appErr.Kind != auth.KindValidation {
+		t.Errorf("ListUsers with cursor for another sort: %v, want a validation error", err)
+	}
+}
+
+func derefUsers(users []*auth.User) []auth.User {
+	out := make([]auth.User, len(users))
+	for i, user := range users {
+		out[i] = *user
+	}
+	return out
+}
+
+func testListUsersFilters(t *testing.T, store auth.UserStore) {
+	alice := createUser(t, store, "alice@example.com", "Alice", "Smith")
+	bob := &auth.User{Email: "bob@example.com", Password: "hash", FirstName: "Bob",
+		LastName: "Jones", Country: "DE", Language: "de", ExternalID: "idp-42",
+		Attributes: auth.Attributes{"team": "platform", "level": 3, "tags": []interface{}{"a", "b"}}}
+	if err := store.CreateUser(t.Context(), bob); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	carol := createUser(t, store, "carol@example.com", "Carol", "White")
+	deactivate(t, store, carol)
+
+	active, inactive := true, false
+	created := getUser(t, store, alice.ID).CreatedAt
+	tests := []struct {
+		name   string
+		modify func(*auth.ListUsersParams)
+		want   []int
+	}{
+		{"all", func(p *auth.ListUsersParams) {}, []int{alice.ID, bob.ID, carol.ID}},
+		{"active", func(p *auth.ListUsersParams) { p.Active = &active }, []int{alice.ID, bob.ID}},
+		{"inactive", func(p *auth.ListUsersParams) { p.Active = &inactive }, []int{carol.ID}},
+		{"email", func(p *auth.ListUsersParams) { p.Email = "Bob@Example.com" }, []int{bob.ID}},
+		{"external id", func(p *auth.ListUsersParams) { p.ExternalID = "idp-42" }, []int{bob.ID}},
+		{"unmatched external id", func(p *auth.ListUsersParams) { p.ExternalID = "IDP-42" }, []int{}},
+		{"country", func(p *auth.ListUsersParams) { p.Country = "DE" }, []int{bob.ID}},
+		{"language", func(p *auth.ListUsersParams) { p.Language = "en" }, []int{alice.ID, carol.ID}},
+		{"attribute", func(p *auth.ListUsersParams) {
+			p.Attributes = map[string]interface{}{"team": "platform"}
+		}, []int{bob.ID}},
+		{"numeric attribute", func(p *auth.ListUsersParams) {
+			p.Attributes = map[string]interface{}{"level": 3}
+		}, []int{bob.ID}},
+		{"array attribute", func(p *auth.ListUsersParams) {
+			p.Attributes = map[string]interface{}{"tags": []interface{}{"b"}}
+		}, []int{bob.ID}},
+		{"unmatched attribute", func(p *auth.ListUsersParams) {
+			p.Attributes = map[string]interface{}{"team": "sales"}
+		}, []int{}},
+		{"created before", func(p *auth.ListUsersParams) {
+			p.CreatedBefore = &created
+		}, []int{}},
+		{"created after", func(p *auth.ListUsersParams) {
+			p.CreatedAfter = &created
+		}, []int{alice.ID, bob.ID, carol.ID}},
+	}
+
+	for _, tt := range tests {
+		params := listParams("id", "asc", 10)
+		params.IncludeTotal = true
+		tt.modify(&params)
+
+		page, err := store.ListUsers(t.Context(), params)
+		if err != nil {
+			t.Fatalf("%s: ListUsers: %v", tt.name, err)
+		}
+		if !equalIDs(ids(page.Data), tt.want) || *page.Total != len(tt.want) {
+			t.Errorf("%s: got %v (total %d), want %v", tt.name, ids(page.Data), *page.Total, tt.want)
+		}
+	}
+
+	// An offset skips rows without changing the total
+	params := listParams("id", "asc", 1)
+	params.IncludeTotal = true
+	params.Offset = 1
+	page, err := store.ListUsers(t.Context(), params)
+	if err != nil {
+		t.Fatalf("offset: ListUsers: %v", err)
+	}
+	if !equalIDs(ids(page.Data), []int{bob.ID}) || *page.Total != 3 {
+		t.Errorf("offset: got %v (total %d), want [%d] (total 3)", ids(page.Data), *page.Total, bob.ID)
+	}
+}
+
+func testStreamUsers(t *testing.T, store auth.UserStore) {
+	var want []int
+	for _, name := range []string{"carol", "alice", "bob"} {
+		want = append(want, createUser(t, store, name+"@example.com", name, "user").ID)
+	}
+
+	var got []int
+	err := store.StreamUsers(t.Context(), listParams("id", "asc", 0), func(user *auth.User) error {
+		got = append(got, user.ID)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("StreamUsers: %v", err)
+	}
+	if !equalIDs(got, want) {
+		t.Errorf("StreamUsers = %v, want %v", got, want)
+	}
+
+	stop := errors.New("stop")
+	calls := 0
+	err = store.StreamUsers(t.Context(), listParams("id", "asc", 0), func(*auth.User) error {
+		calls++
+		return stop
+	})
+	if err != stop || calls != 1 {
+		t.Errorf("StreamUsers returned %v after %d calls, want the callback's error", err, calls)
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	err = store.StreamUsers(ctx, listParams("id", "asc", 0), func(*auth.User) error { return nil })
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("StreamUsers with a cancelled context = %v, want context.Canceled", err)
+	}
+}
+
+func testSearchUsers(t *testing.T, store auth.UserStore) {
+	alice := createUser(t, store, "alice@example.com", "Alice", "Smithson")
+	createUser(t, store, "bob@example.com", "Bob", "Jones")
+	inactive := createUser(t, store, "alicia@example.com", "Alicia", "Smithers")
+	deactivate(t, store, inactive)
+
+	results, err := store.SearchUsers(t.Context(), "smith", []string{"smith"}, 10)
+	if err != nil {
+		t.Fatalf("SearchUsers: %v", err)
+	}
+	if len(results) != 1 || results[0].User.ID != alice.ID {
+		t.Errorf("search for prefix = %+v, want only %d", results, alice.ID)
+	}
+
+	results, err = store.SearchUsers(t.Context(), "zzzz", []string{"zzzz"}, 10)
+	if err != nil || len(results) != 0 {
+		t.Errorf("search without match = %+v, %v", results, err)
+	}
+}
+
+func testHistory(t *testing.T, store auth.UserStore) {
+	user := createUser(t, store, "alice@example.com", "Alice", "Smith")
+	actor := auth.Actor{UserID: 7, Email: "admin@example.com", Source: auth.SourceAPI, RequestID: "req-1"}
+	if err := store.RecordUserChange(t.Context(), auth.ChangeCreate, nil, user.ID, actor); err != nil {
+		t.Fatalf("RecordUserChange: %v", err)
+	}
+
+	before := getUser(t, store, user.ID)
+	updated := *before
+	updated.FirstName = "Alicia"
+	if err := store.UpdateUser(t.Context(), &updated); err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+	if err := store.RecordUserChange(t.Context(), auth.ChangeUpdate, before, user.ID, auth.Actor{}); err != nil {
+		t.Fatalf("RecordUserChange: %v", err)
+	}
+
+	changes, err := store.ListUserChanges(t.Context(), user.ID, 10, 0)
+	if err != nil {
+		t.Fatalf("ListUserChanges: %v", err)
+	}
+	if len(changes) != 2 {
+		t.Fatalf("got %d changes, want 2", len(changes))
+	}
+	latest, first := changes[0], changes[1]
+	if latest.Action != auth.ChangeUpdate || latest.Version != 2 || latest.Actor.Source != auth.SourceSystem {
+		t.Errorf("latest change = %+v", latest)
+	}
+	if change, ok := latest.Changes["first_name"]; !ok || change.From != "Alice" || change.To != "Alicia" {
+		t.Errorf("latest change diff = %+v", latest.Changes)
+	}
+	if first.Action != auth.ChangeCreate || first.Version != 1 || first.Actor != actor {
+		t.Errorf("first change = %+v", first)
+	}
+
+	older, err := store.ListUserChanges(t.Context(), user.ID, 10, latest.ID)
+	if err != nil || len(older) != 1 || older[0].ID != first.ID {
+		t.Errorf("ListUserChanges before %d = %+v, %v", latest.ID, older, err)
+	}
+
+	snapshot, err := store.GetUserSnapshot(t.Context(), user.ID, 1)
+	if err != nil {
+		t.Fatalf("GetUserSnapshot: %v", err)
+	}
+	if snapshot.FirstName != "Alice" || snapshot.Email != "alice@example.com" {
+		t.Errorf("snapshot at version 1 = %+v", snapshot)
+	}
+	if snapshot, err := store.GetUserSnapshot(t.Context(), user.ID, 5); err != nil || snapshot.FirstName != "Alicia" {
+		t.Errorf("snapshot at later version = %+v, %v", snapshot, err)
+	}
+	_, err = store.GetUserSnapshot(t.Context(), user.ID, 0)
+	if appErr, ok := err.(*auth.Error); !ok || appErr.Kind != auth.KindNotFound {
+		t.Errorf("GetUserSnapshot before history: %v, want not found", err)
+	}
+
+	if err := store.DeleteUserChanges(t.Context(), user.ID); err != nil {
+		t.Fatalf("DeleteUserChanges: %v", err)
+	}
+	if changes, err := store.ListUserChanges(t.Context(), user.ID, 10, 0); err != nil || len(changes) != 0 {
+		t.Errorf("changes after delete = %+v, %v", changes, err)
+	}
+
+	if err := store.RecordUserChange(t.Context(), auth.ChangeUpdate, nil, 999999, auth.Actor{}); !errors.Is(err, auth.ErrUserNotFound) {
+		t.Errorf("RecordUserChange for missing user: %v, want ErrUserNotFound", err)
+	}
+}
+
+func testTransactions(t *testing.T, store auth.UserStore) {
+	failure := errors.New("rollback")
+	err := store.WithTx(t.Context(), func(tx auth.UserStore) error {
+		createUser(t, tx, "rolled-back@example.com", "Rolled", "Back")
+		return tx.WithTx(t.Context(), func(nested auth.UserStore) error {
+			createUser(t, nested, "nested@example.com", "Nested", "User")
+			return failure
+		})
+	})
+	if err != failure {
+		t.Fatalf("WithTx returned %v, want the callback's error", err)
+	}
+	if all := allUsers(t, store); len(all) != 0 {
+		t.Errorf("users after rollback = %v", ids(all))
+	}
+
+	var created *auth.User
+	err = store.WithTx(t.Context(), func(tx auth.UserStore) error {
+		created = createUser(t, tx, "committed@example.com", "Committed", "User")
+		return tx.RecordUserChange(t.Context(), auth.ChangeCreate, nil, created.ID, auth.Actor{})
+	})
+	if err != nil {
+		t.Fatalf("WithTx: %v", err)
+	}
+	if _, err := store.GetUserByID(t.Context(), created.ID); err != nil {
+		t.Errorf("committed user not found: %v", err)
+	}
+	if changes, err := store.ListUserChanges(t.Context(), created.ID, 10, 0); err != nil || len(changes) != 1 {
+		t.Errorf("committed changes = %+v, %v", changes, err)
+	}
+}
+
+func testPrivacyRequests(t *testing.T, store auth.UserStore) {
+	user := createUser(t, store, "alice@example.com", "Alice", "Smith")
+
+	req := &auth.PrivacyRequest{UserID: user.ID, Kind: auth.PrivacyRequestExport, RequestedBy: "alice@example.com"}
+	if err := store.CreatePrivacyRequest(t.Context(), req); err != nil {
+		t.Fatalf("CreatePrivacyRequest: %v", err)
+	}
+	if req.ID == 0 || req.CreatedAt.IsZero() {
+		t.Errorf("created request = %+v", req)
+	}
+
+	requests, err := store.GetPrivacyRequests(t.Context(), user.ID)
+	if err != nil {
+		t.Fatalf("GetPrivacyRequests: %v", err)
+	}
+	if len(requests) != 1 || requests[0].ID != req.ID || requests[0].Kind != auth.PrivacyRequestExport {
+		t.Errorf("requests = %+v", requests)
+	}
+
+	invalid := &auth.PrivacyRequest{UserID: user.ID, Kind: "unknown", RequestedBy: "x"}
+	if err := store.CreatePrivacyRequest(t.Context(), invalid); err == nil {
+		t.Error("CreatePrivacyRequest accepted an unknown kind")
+	}
+
+	if requests, err := store.GetPrivacyRequests(t.Context(), 999999); err != nil || len(requests) != 0 {
+		t.Errorf("requests of unknown user = %+v, %v", requests, err)
+	}
+}
+
+func testAttributeSchema(t *testing.T, store auth.UserStore) {
+	if schema, err := store.GetAttributeSchema(t.Context()); err != nil || schema != nil {
+		t.Errorf("initial schema = %q, %v", schema, err)
+	}
+
+	for _, schema := range []string{`{"type": "object"}`, `{"type": "object", "properties": {}}`} {
+		if err := store.SaveAttributeSchema(t.Context(), []byte(schema)); err != nil {
+			t.Fatalf("SaveAttributeSchema: %v", err)
+		}
+		got, err := store.GetAttributeSchema(t.Context())
+		if err != nil || len(got) == 0 {
+			t.Fatalf("GetAttributeSchema = %q, %v", got, err)
+		}
+	}
+}
+
+func testAvatar(t *testing.T, store auth.UserStore) {
+	user := createUser(t, store, "alice@example.com", "Alice", "Smith")
+
+	urls := auth.AvatarURLs{auth.DefaultAvatarSize: "/media/a.jpg", "other": "/media/b.jpg"}
+	if err := store.SetUserAvatar(t.Context(), user.ID, user.Version, urls); err != nil {
+		t.Fatalf("SetUserAvatar: %v", err)
+	}
+	got := getUser(t, store, user.ID)
+	if got.AvatarURL != "/media/a.jpg" || len(got.AvatarURLs) != 2 || got.Version != 2 {
+		t.Errorf("user with avatar = %+v", got)
+	}
+
+	if err := store.SetUserAvatar(t.Context(), user.ID, user.Version, nil); err != auth.ErrPreconditionFailed {
+		t.Errorf("SetUserAvatar with a stale version = %v, want ErrPreconditionFailed", err)
+	}
+	if got := getUser(t, store, user.ID); len(got.AvatarURLs) != 2 {
+		t.Errorf("stale SetUserAvatar changed the avatar: %+v", got)
+	}
+
+	if err := store.SetUserAvatar(t.Context(), user.ID, got.Version, nil); err != nil {
+		t.Fatalf("SetUserAvatar(nil): %v", err)
+	}
+	got = getUser(t, store, user.ID)
+	if got.AvatarURL != "" || got.AvatarURLs != nil {
+		t.Errorf("user after removing avatar = %+v", got)
+	}
+}
+
+func testGroups(t *testing.T, store auth.UserStore) {
+	user := createUser(t, store, "alice@example.com", "Alice", "Smith")
+
+	groups, err := store.GetGroupsForUsers(t.Context(), []int{user.ID, 999999})
+	if err != nil {
+		t.Fatalf("GetGroupsForUsers: %v", err)
+	}
+	if len(groups) != 2 || groups[user.ID] == nil || groups[999999] == nil {
+		t.Errorf("groups = %+v, want an empty entry per user", groups)
+	}
+
+	if err := store.RemoveUserFromGroups(t.Context(), user.ID); err != nil {
+		t.Errorf("RemoveUserFromGroups: %v", err)
+	}
+}
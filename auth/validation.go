@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+
+	"goAPI/iso"
+)
+
+// validate is shared by the handlers and the service so that request
+// structs are checked by the same rules on every path
+var validate = newValidator()
+
+func newValidator() *validator.Validate {
+	v := validator.New()
+
+	// Report fields by their JSON names, which is what clients send
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+
+	// iso_country accepts ISO 3166-1 alpha-2 codes such as "US"
+	v.RegisterValidation("iso_country", func(fl validator.FieldLevel) bool {
+		return iso.IsCountry(fl.Field().String())
+	})
+	// iso_language accepts ISO 639-1 codes with an optional region, such
+	// as "en" or "pt-BR"
+	v.RegisterValidation("iso_language", func(fl validator.FieldLevel) bool {
+		return iso.IsLanguageTag(fl.Field().String())
+	})
+
+	return v
+}
+
+// FieldError describes a failed validation rule in a form clients can act
+// on. Field is the JSON path of the field, Rule the validation tag that
+// failed, such as "required" or "min", and Param the tag's argument.
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
+}
+
+// fieldPath returns the JSON path of a failed field without the name of
+// the request struct, e.g. "email" rather than "CreateUserRequest.email"
+func fieldPath(fe validator.FieldError) string {
+	_, path, found := strings.Cut(fe.Namespace(), ".")
+	if !found {
+		return fe.Field()
+	}
+	return path
+}
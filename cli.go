@@ -0,0 +1,375 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"goAPI/auth"
+	"goAPI/migrations"
+)
+
+func runCommand(config *Config, args []string) error {
+	// The in-memory store starts empty in every process, so there would be
+	// nothing for a command to work on
+	if config.UserStore == userStoreMemory {
+		return fmt.Errorf("%s: maintenance commands need a database, not USER_STORE=%s", args[0], userStoreMemory)
+	}
+
+	// Interrupting a command cancels the query it is running
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	switch args[0] {
+	case "import":
+		return runImport(ctx, config, args[1:])
+	case "export":
+		return runExport(ctx, config, args[1:])
+	case "retention":
+		return runRetention(ctx, config, args[1:])
+	case "gdpr":
+		return runGDPR(ctx, config, args[1:])
+	case "migrate":
+		return runMigrate(ctx, config, args[1:])
+	}
+	return fmt.Errorf("unknown command %q (available: serve, import, export, retention, gdpr, migrate)", args[0])
+}
+
+// runImport bulk-creates users from a CSV or NDJSON file:
+//
+//	goAPI import -file users.csv [-format csv|ndjson] [-mode atomic|best_effort] [-dry-run]
+func runImport(ctx context.Context, config *Config, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	file := flags.String("file", "", "CSV or NDJSON file to import, - for stdin")
+	format := flags.String("format", "", "csv or ndjson (default: from the file extension)")
+	mode := flags.String("mode", string(auth.ImportAtomic), "atomic or best_effort")
+	dryRun := flags.Bool("dry-run", false, "validate without creating users")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *file == "" {
+		return fmt.Errorf("import: -file is required")
+	}
+
+	if *format == "" {
+		switch strings.ToLower(filepath.Ext(*file)) {
+		case ".csv":
+			*format = auth.ImportFormatCSV
+		case ".ndjson", ".jsonl":
+			*format = auth.ImportFormatNDJSON
+		default:
+			return fmt.Errorf("import: cannot infer format of %s, use -format", *file)
+		}
+	}
+
+	var input io.Reader = os.Stdin
+	if *file != "-" {
+		f, err := os.Open(*file)
+		if err != nil {
+			return fmt.Errorf("import: %w", err)
+		}
+		defer f.Close()
+		input = f
+	}
+
+	rows, err := auth.ParseImport(*format, input)
+	if err != nil {
+		return fmt.Errorf("import: %w", err)
+	}
+
+	db, err := openDatabase(config)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	service, err := newAuthService(config, db)
+	if err != nil {
+		return err
+	}
+	report, err := service.WithActor(auth.Actor{Source: auth.SourceCLI}).ImportUsers(ctx, rows, auth.ImportOptions{
+		Mode:   auth.ImportMode(*mode),
+		DryRun: *dryRun,
+	})
+	if err != nil {
+		return fmt.Errorf("import: %w", err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		return err
+	}
+
+	if report.Failed > 0 || (!report.DryRun && report.Mode == auth.ImportAtomic && report.Created < report.Total) {
+		return fmt.Errorf("import: %d of %d rows failed", report.Failed, report.Total)
+	}
+	return nil
+}
+
+// runExport streams users to a CSV or NDJSON file:
+//
+//	goAPI export [-format csv|ndjson] [-columns id,email] [-filter "country=DE&active=all"] [-out users.csv]
+func runExport(ctx context.Context, config *Config, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := flags.String("format", auth.ExportFormatCSV, "csv or ndjson")
+	columns := flags.String("columns", "", "comma-separated columns (default: all)")
+	filter := flags.String("filter", "", "listing filters as a query string, e.g. country=DE&active=all")
+	out := flags.String("out", "-", "output file, - for stdout")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	query, err := url.ParseQuery(*filter)
+	if err != nil {
+		return fmt.Errorf("export: invalid -filter: %w", err)
+	}
+	params, err := auth.ParseAdminListUsersParams(query)
+	if err != nil {
+		return fmt.Errorf("export: %w", err)
+	}
+
+	selected, err := auth.ParseExportColumns(*columns)
+	if err != nil {
+		return fmt.Errorf("export: %w", err)
+	}
+
+	var output io.Writer = os.Stdout
+	if *out != "-" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return fmt.Errorf("export: %w", err)
+		}
+		defer f.Close()
+		output = f
+	}
+
+	exporter, err := auth.NewExporter(*format, output, selected)
+	if err != nil {
+		return fmt.Errorf("export: %w", err)
+	}
+
+	db, err := openDatabase(config)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	service, err := newAuthService(config, db)
+	if err != nil {
+		return err
+	}
+	return service.ExportUsers(ctx, params, exporter)
+}
+
+// runRetention applies the retention policy once, e.g. from cron:
+//
+//	goAPI retention [-days 30] [-mode anonymize|delete]
+func runRetention(ctx context.Context, config *Config, args []string) error {
+	flags := flag.NewFlagSet("retention", flag.ContinueOnError)
+	days := flags.Int("days", config.RetentionDays, "days after deactivation before the policy applies")
+	mode := flags.String("mode", config.RetentionMode, "anonymize or delete")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if !auth.RetentionMode(*mode).Valid() {
+		return fmt.Errorf("retention: unknown mode %q (available: %s, %s)", *mode, auth.RetentionAnonymize, auth.RetentionDelete)
+	}
+
+	config.RetentionDays = *days
+	config.RetentionMode = *mode
+
+	db, err := openDatabase(config)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	service, err := newAuthService(config, db)
+	if err != nil {
+		return err
+	}
+	n, err := service.ApplyRetention(ctx, config.retentionPolicy())
+	if err != nil {
+		return fmt.Errorf("retention: %w", err)
+	}
+
+	fmt.Printf("%s %d deactivated users\n", *mode, n)
+	return nil
+}
+
+// runGDPR handles data subject requests for a single user:
+//
+//	goAPI gdpr export -id 42 [-out user-42.json]
+//	goAPI gdpr erase -id 42
+func runGDPR(ctx context.Context, config *Config, args []string) error {
+	if len(args) == 0 || (args[0] != "export" && args[0] != "erase") {
+		return fmt.Errorf("gdpr: expected export or erase")
+	}
+	action := args[0]
+
+	flags := flag.NewFlagSet("gdpr "+action, flag.ContinueOnError)
+	id := flags.Int("id", 0, "ID of the user")
+	out := flags.String("out", "-", "output file for export, - for stdout")
+	by := flags.String("requested-by", "cli:"+os.Getenv("USER"), "who made the request, for the record")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	if *id <= 0 {
+		return fmt.Errorf("gdpr: -id is required")
+	}
+
+	db, err := openDatabase(config)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	service, err := newAuthService(config, db)
+	if err != nil {
+		return err
+	}
+
+	var result interface{}
+	if action == "export" {
+		result, err = service.ExportUserData(ctx, *id, *by)
+	} else {
+		result, err = service.EraseUser(ctx, *id, *by)
+	}
+	if err != nil {
+		return fmt.Errorf("gdpr %s: %w", action, err)
+	}
+
+	var output io.Writer = os.Stdout
+	if *out != "-" && action == "export" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return fmt.Errorf("gdpr export: %w", err)
+		}
+		defer f.Close()
+		output = f
+	}
+
+	encoder := json.NewEncoder(output)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(result)
+}
+
+// openDatabase connects to the database for a command that uses the user
+// store, checking its schema as the server does on start
+func openDatabase(config *Config) (*sql.DB, error) {
+	db, err := connectDB(config.DatabaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	if err := checkSchema(config, db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("database schema check failed: %w", err)
+	}
+	return db, nil
+}
+
+// runMigrate manages the database schema:
+//
+//	goAPI migrate up
+//	goAPI migrate down [N]
+//	goAPI migrate goto VERSION
+//	goAPI migrate status
+//	goAPI migrate force VERSION
+//
+// force records the migrations up to VERSION as applied without running
+// them, to adopt a database whose schema was created by hand.
+func runMigrate(ctx context.Context, config *Config, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("migrate: expected up, down, goto, status or force")
+	}
+	action := args[0]
+
+	var version int
+	switch action {
+	case "up", "status":
+		if len(args) != 1 {
+			return fmt.Errorf("migrate %s takes no arguments", action)
+		}
+	case "down":
+		version = 1
+		fallthrough
+	case "goto", "force":
+		if len(args) == 2 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 0 {
+				return fmt.Errorf("migrate %s: invalid number %q", action, args[1])
+			}
+			version = n
+		} else if len(args) != 1 || action != "down" {
+			return fmt.Errorf("migrate %s: expected a version", action)
+		}
+	default:
+		return fmt.Errorf("migrate: unknown action %q (available: up, down, goto, status, force)", action)
+	}
+
+	db, err := connectDB(config.DatabaseURL)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close()
+
+	migrator, err := newMigrator(config, db)
+	if err != nil {
+		return err
+	}
+
+	var steps []migrations.Step
+	switch action {
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return fmt.Errorf("migrate status: %w", err)
+		}
+		for _, status := range statuses {
+			state := "pending"
+			if status.AppliedAt != nil {
+				state = "applied " + status.AppliedAt.Format(time.RFC3339)
+			}
+			if status.Unknown {
+				state += " (unknown to this build)"
+			}
+			fmt.Printf("%s  %s\n", status.Migration, state)
+		}
+		return nil
+	case "force":
+		if err := migrator.Force(ctx, version); err != nil {
+			return fmt.Errorf("migrate force: %w", err)
+		}
+		fmt.Printf("recorded migrations up to version %d as applied\n", version)
+		return nil
+	case "up":
+		steps, err = migrator.Up(ctx)
+	case "down":
+		steps, err = migrator.Down(ctx, version)
+	case "goto":
+		steps, err = migrator.Goto(ctx, version)
+	}
+
+	for _, step := range steps {
+		fmt.Println(step)
+	}
+	if err != nil {
+		return fmt.Errorf("migrate %s: %w", action, err)
+	}
+	if len(steps) == 0 {
+		fmt.Println("nothing to migrate")
+	}
+	return nil
+}
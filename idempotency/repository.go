@@ -0,0 +1,217 @@
+// Package idempotency stores the responses to requests sent with an
+// Idempotency-Key header so that retries can be answered without repeating
+// the request's side effects.
+package idempotency
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// lockTimeout is how long a key stays reserved by a request that never
+// completed, for example because the server crashed while handling it
+const lockTimeout = time.Minute
+
+// Record is the stored outcome of the first request made with a key.
+// StatusCode is zero while that request is still in progress. Keys are
+// chosen by clients, so they are scoped to the caller that sent them.
+type Record struct {
+	Caller      string
+	Key         string
+	Fingerprint string
+	StatusCode  int
+	Header      http.Header
+	Body        []byte
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+}
+
+// Completed reports whether the first request has finished and its
+// response can be replayed
+func (r *Record) Completed() bool {
+	return r.StatusCode != 0
+}
+
+type Repository struct {
+	db      *sql.DB
+	timeout time.Duration
+}
+
+// NewRepository returns a repository whose operations give up after
+// timeout; zero means they are bounded only by the caller's context
+func NewRepository(db *sql.DB, timeout time.Duration) *Repository {
+	return &Repository{db: db, timeout: timeout}
+}
+
+func (r *Repository) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if r.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, r.timeout)
+}
+
+// Reserve claims a caller's key for a request with the given fingerprint.
+// It returns true if the caller now owns the key and must Complete or
+// Release it. Otherwise it returns the record of the request that already
+// used it. Expired keys and keys abandoned by unfinished requests are
+// reclaimed.
+func (r *Repository) Reserve(ctx context.Context, caller, key, fingerprint string, ttl time.Duration) (*Record, bool, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		INSERT INTO idempotency_keys (caller, key, fingerprint, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (caller, key) DO UPDATE
+		SET fingerprint = EXCLUDED.fingerprint, status_code = NULL, headers = NULL,
+		    body = NULL, created_at = EXCLUDED.created_at, expires_at = EXCLUDED.expires_at
+		WHERE idempotency_keys.expires_at < $4
+		   OR (idempotency_keys.status_code IS NULL AND idempotency_keys.created_at < $6)
+		RETURNING key`
+
+	// The stored record can expire between the two statements, so retry once
+	for attempt := 0; attempt < 2; attempt++ {
+		now := time.Now()
+		var reserved string
+		err := r.db.QueryRowContext(ctx, query, caller, key, fingerprint, now, now.Add(ttl), now.Add(-lockTimeout)).Scan(&reserved)
+		if err == nil {
+			return nil, true, nil
+		}
+		if err != sql.ErrNoRows {
+			return nil, false, fmt.Errorf("failed to reserve idempotency key: %w", err)
+		}
+
+		record, err := r.Get(ctx, caller, key)
+		if err == nil {
+			return record, false, nil
+		}
+		if err != sql.ErrNoRows {
+			return nil, false, err
+		}
+	}
+	return nil, false, fmt.Errorf("failed to reserve idempotency key %q", key)
+}
+
+// Get returns the record of a caller's key, or sql.ErrNoRows if it is
+// unused
+func (r *Repository) Get(ctx context.Context, caller, key string) (*Record, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT caller, key, fingerprint, status_code, headers, body, created_at, expires_at
+		FROM idempotency_keys
+		WHERE caller = $1 AND key = $2`
+
+	var record Record
+	var status sql.NullInt64
+	var header []byte
+	err := r.db.QueryRowContext(ctx, query, caller, key).Scan(&record.Caller, &record.Key, &record.Fingerprint, &status,
+		&header, &record.Body, &record.CreatedAt, &record.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get idempotency key: %w", err)
+	}
+
+	record.StatusCode = int(status.Int64)
+	if header != nil {
+		if err := json.Unmarshal(header, &record.Header); err != nil {
+			return nil, fmt.Errorf("invalid stored headers: %w", err)
+		}
+	}
+	return &record, nil
+}
+
+// Complete stores the response of the request that reserved the key
+func (r *Repository) Complete(ctx context.Context, caller, key string, status int, header http.Header, body []byte) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	data, err := json.Marshal(header)
+	if err != nil {
+		return fmt.Errorf("failed to encode headers: %w", err)
+	}
+
+	query := `
+		UPDATE idempotency_keys
+		SET status_code = $1, headers = $2, body = $3
+		WHERE caller = $4 AND key = $5`
+
+	if _, err := r.db.ExecContext(ctx, query, status, data, body, caller, key); err != nil {
+		return fmt.Errorf("failed to store idempotent response: %w", err)
+	}
+	return nil
+}
+
+// Release frees a reserved key without storing a response, so the request
+// can be retried from scratch
+func (r *Repository) Release(ctx context.Context, caller, key string) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	query := `DELETE FROM idempotency_keys WHERE caller = $1 AND key = $2 AND status_code IS NULL`
+	_, err := r.db.ExecContext(ctx, query, caller, key)
+	if err != nil {
+		return fmt.Errorf("failed to release idempotency key: %w", err)
+	}
+	return nil
+}
+
+// DeleteExpired removes keys whose replay window has passed
+func (r *Repository) DeleteExpired(ctx context.Context) (int64, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	result, err := r.db.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE expires_at < $1`, time.Now())
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired idempotency keys: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// StartCleanupJob deletes expired keys on every interval until the
+// returned stop function is called
+func (r *Repository) StartCleanupJob(interval time.Duration) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := r.DeleteExpired(ctx); err != nil {
+					log.Printf("idempotency cleanup failed: %v", err)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return cancel
+}
+
+// Fingerprint identifies a request by its method, path and body, so that
+// a key reused for a different request can be detected. Credentials aren't
+// included: keys are already scoped to the caller, and a retry may carry a
+// refreshed token.
+func Fingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	for _, part := range []string{r.Method, r.URL.RequestURI()} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
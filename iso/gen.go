@@ -0,0 +1,87 @@
+//go:build ignore
+
+// This program generates countries.csv and languages.csv from the CLDR data
+// in golang.org/x/text. Run it with "go generate ./iso".
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"os"
+
+	"golang.org/x/text/language"
+	"golang.org/x/text/language/display"
+)
+
+func main() {
+	writeCSV("countries.csv", []string{"alpha2", "alpha3", "numeric", "name"}, countries())
+	writeCSV("languages.csv", []string{"alpha2", "alpha3", "name"}, languages())
+}
+
+func countries() [][]string {
+	names := display.English.Regions()
+	var rows [][]string
+	for a := 'A'; a <= 'Z'; a++ {
+		for b := 'A'; b <= 'Z'; b++ {
+			code := string([]rune{a, b})
+			region, err := language.ParseRegion(code)
+			if err != nil || region.String() != code || !region.IsCountry() || region.IsPrivateUse() {
+				continue
+			}
+			if region.Canonicalize() != region {
+				continue
+			}
+			if region.ISO3() == "" || region.M49() == 0 {
+				continue
+			}
+			// Codes withdrawn from ISO 3166-1 have no display name
+			name := names.Name(region)
+			if name == "" {
+				continue
+			}
+			rows = append(rows, []string{code, region.ISO3(), fmt.Sprintf("%03d", region.M49()), name})
+		}
+	}
+	return rows
+}
+
+func languages() [][]string {
+	names := display.English.Languages()
+	var rows [][]string
+	for a := 'a'; a <= 'z'; a++ {
+		for b := 'a'; b <= 'z'; b++ {
+			code := string([]rune{a, b})
+			base, err := language.ParseBase(code)
+			if err != nil || base.String() != code {
+				continue
+			}
+			// Skip deprecated codes that canonicalize to another language
+			tag := language.Make(code)
+			if tag.String() != code {
+				continue
+			}
+			name := names.Name(tag)
+			if name == "" {
+				continue
+			}
+			rows = append(rows, []string{code, base.ISO3(), name})
+		}
+	}
+	return rows
+}
+
+func writeCSV(path string, header []string, rows [][]string) {
+	f, err := os.Create(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	w.Write(header)
+	w.WriteAll(rows)
+	if err := w.Error(); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,85 @@
+package iso
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"golang.org/x/text/language"
+)
+
+// Handler serves the reference data. Names are localized to the lang query
+// parameter or the Accept-Language header.
+type Handler struct{}
+
+func NewHandler() *Handler {
+	return &Handler{}
+}
+
+type CountryResponse struct {
+	Code        string `json:"code"`
+	Alpha3      string `json:"alpha3"`
+	Numeric     string `json:"numeric"`
+	Name        string `json:"name"`
+	EnglishName string `json:"english_name"`
+}
+
+type LanguageResponse struct {
+	Code        string `json:"code"`
+	Alpha3      string `json:"alpha3"`
+	Name        string `json:"name"`
+	EnglishName string `json:"english_name"`
+	NativeName  string `json:"native_name"`
+}
+
+func (h *Handler) Countries(w http.ResponseWriter, r *http.Request) {
+	lang := h.displayLanguage(r)
+
+	data := make([]CountryResponse, 0, len(countries))
+	for _, c := range countries {
+		data = append(data, CountryResponse{
+			Code:        c.Alpha2,
+			Alpha3:      c.Alpha3,
+			Numeric:     c.Numeric,
+			Name:        CountryName(c, lang),
+			EnglishName: c.Name,
+		})
+	}
+
+	h.respondWithJSON(w, lang, data)
+}
+
+func (h *Handler) Languages(w http.ResponseWriter, r *http.Request) {
+	lang := h.displayLanguage(r)
+
+	data := make([]LanguageResponse, 0, len(languages))
+	for _, l := range languages {
+		data = append(data, LanguageResponse{
+			Code:        l.Alpha2,
+			Alpha3:      l.Alpha3,
+			Name:        LanguageName(l, lang),
+			EnglishName: l.Name,
+			NativeName:  NativeLanguageName(l),
+		})
+	}
+
+	h.respondWithJSON(w, lang, data)
+}
+
+func (h *Handler) displayLanguage(r *http.Request) language.Tag {
+	if lang := r.URL.Query().Get("lang"); lang != "" {
+		return DisplayLanguage(lang)
+	}
+	return DisplayLanguage(r.Header.Get("Accept-Language"))
+}
+
+func (h *Handler) respondWithJSON(w http.ResponseWriter, lang language.Tag, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Language", lang.String())
+	w.Header().Set("Vary", "Accept-Language")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"language": lang.String(),
+		"data":     data,
+	})
+}
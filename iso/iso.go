@@ -0,0 +1,163 @@
+// Package iso provides embedded ISO 3166-1 country and ISO 639-1 language
+// reference data, with display names localized through CLDR.
+package iso
+
+import (
+	_ "embed"
+	"encoding/csv"
+	"strings"
+
+	"golang.org/x/text/language"
+	"golang.org/x/text/language/display"
+)
+
+//go:generate go run gen.go
+
+//go:embed countries.csv
+var countriesCSV string
+
+//go:embed languages.csv
+var languagesCSV string
+
+// Country is an ISO 3166-1 country
+type Country struct {
+	Alpha2  string
+	Alpha3  string
+	Numeric string
+	Name    string // English short name
+}
+
+// Language is an ISO 639-1 language
+type Language struct {
+	Alpha2 string
+	Alpha3 string
+	Name   string // English name
+}
+
+var (
+	countries    = loadCountries()
+	languages    = loadLanguages()
+	countryByID  = map[string]Country{}
+	languageByID = map[string]Language{}
+)
+
+func init() {
+	for _, c := range countries {
+		countryByID[c.Alpha2] = c
+	}
+	for _, l := range languages {
+		languageByID[l.Alpha2] = l
+	}
+}
+
+func loadCountries() []Country {
+	var list []Country
+	for _, record := range readCSV(countriesCSV) {
+		list = append(list, Country{Alpha2: record[0], Alpha3: record[1], Numeric: record[2], Name: record[3]})
+	}
+	return list
+}
+
+func loadLanguages() []Language {
+	var list []Language
+	for _, record := range readCSV(languagesCSV) {
+		list = append(list, Language{Alpha2: record[0], Alpha3: record[1], Name: record[2]})
+	}
+	return list
+}
+
+// readCSV parses embedded data, skipping the header row
+func readCSV(data string) [][]string {
+	records, err := csv.NewReader(strings.NewReader(data)).ReadAll()
+	if err != nil {
+		panic("iso: invalid embedded data: " + err.Error())
+	}
+	return records[1:]
+}
+
+// Countries returns every country ordered by alpha-2 code
+func Countries() []Country {
+	return append([]Country(nil), countries...)
+}
+
+// Languages returns every language ordered by alpha-2 code
+func Languages() []Language {
+	return append([]Language(nil), languages...)
+}
+
+func LookupCountry(code string) (Country, bool) {
+	c, ok := countryByID[code]
+	return c, ok
+}
+
+func LookupLanguage(code string) (Language, bool) {
+	l, ok := languageByID[code]
+	return l, ok
+}
+
+// IsCountry reports whether code is an upper-case ISO 3166-1 alpha-2 code
+func IsCountry(code string) bool {
+	_, ok := countryByID[code]
+	return ok
+}
+
+// IsLanguageTag reports whether tag is a BCP 47 tag made of an ISO 639-1
+// language and an optional ISO 3166-1 region, e.g. "en" or "pt-BR". Longer
+// tags are not accepted because they don't fit the users table.
+func IsLanguageTag(tag string) bool {
+	lang, region, hasRegion := strings.Cut(tag, "-")
+	if _, ok := languageByID[lang]; !ok {
+		return false
+	}
+	return !hasRegion || IsCountry(region)
+}
+
+// DisplayLanguage picks the language display names are shown in from a
+// language tag or an Accept-Language header, falling back to English
+func DisplayLanguage(preferred string) language.Tag {
+	tags, _, err := language.ParseAcceptLanguage(preferred)
+	if err != nil || len(tags) == 0 {
+		return language.English
+	}
+
+	_, index, confidence := displayMatcher.Match(tags...)
+	if confidence == language.No {
+		return language.English
+	}
+	return display.Supported.Tags()[index]
+}
+
+var displayMatcher = language.NewMatcher(display.Supported.Tags())
+
+// CountryName returns the name of the country in the display language
+func CountryName(c Country, in language.Tag) string {
+	region, err := language.ParseRegion(c.Alpha2)
+	if err != nil {
+		return c.Name
+	}
+	if namer := display.Regions(in); namer != nil {
+		if name := namer.Name(region); name != "" {
+			return name
+		}
+	}
+	return c.Name
+}
+
+// LanguageName returns the name of the language in the display language
+func LanguageName(l Language, in language.Tag) string {
+	tag := language.Make(l.Alpha2)
+	if namer := display.Languages(in); namer != nil {
+		if name := namer.Name(tag); name != "" {
+			return name
+		}
+	}
+	return l.Name
+}
+
+// NativeLanguageName returns the name of the language in itself
+func NativeLanguageName(l Language) string {
+	if name := display.Self.Name(language.Make(l.Alpha2)); name != "" {
+		return name
+	}
+	return l.Name
+}
@@ -4,48 +4,121 @@ import (
 	"context"
 	"goAPI/auth" // Update this to your module name
 	"net/http"
+	"strconv"
 	"strings"
-)
-
-type contextKey string
 
-const UserContextKey contextKey = "user"
+	"github.com/gorilla/mux"
+)
 
 func AuthMiddleware(jwtService *auth.JWTService) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			// Get token from Authorization header
-			authHeader := r.Header.Get("Authorization")
-			if authHeader == "" {
-				http.Error(w, "Authorization header required", http.StatusUnauthorized)
+			if r.Header.Get("Authorization") == "" {
+				auth.WriteError(w, r, auth.Unauthorized("missing_token", "Authorization header required"))
 				return
 			}
 
-			// Check for Bearer token
-			tokenParts := strings.Split(authHeader, " ")
-			if len(tokenParts) != 2 || tokenParts[0] != "Bearer" {
-				http.Error(w, "Invalid authorization header format", http.StatusUnauthorized)
+			claims, err := bearerClaims(jwtService, r)
+			if err != nil {
+				auth.WriteError(w, r, err)
 				return
 			}
 
-			tokenString := tokenParts[1]
+			// Add user info to context
+			next.ServeHTTP(w, r.WithContext(auth.ContextWithClaims(r.Context(), claims)))
+		})
+	}
+}
 
-			// Validate token
-			claims, err := jwtService.ValidateToken(tokenString)
+// OptionalAuth is AuthMiddleware for routes that don't require a token:
+// requests without one pass through anonymously, so only a token that is
+// sent has to be valid. Changes made with a token are attributed to its
+// user.
+func OptionalAuth(jwtService *auth.JWTService) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			claims, err := bearerClaims(jwtService, r)
 			if err != nil {
-				http.Error(w, "Invalid token", http.StatusUnauthorized)
+				auth.WriteError(w, r, err)
 				return
 			}
 
-			// Add user info to context
-			ctx := context.WithValue(r.Context(), UserContextKey, claims)
-			next.ServeHTTP(w, r.WithContext(ctx))
+			next.ServeHTTP(w, r.WithContext(auth.ContextWithClaims(r.Context(), claims)))
 		})
 	}
 }
 
+// bearerClaims validates the bearer token in the Authorization header
+func bearerClaims(jwtService *auth.JWTService, r *http.Request) (*auth.Claims, error) {
+	tokenParts := strings.Split(r.Header.Get("Authorization"), " ")
+	if len(tokenParts) != 2 || tokenParts[0] != "Bearer" {
+		return nil, auth.Unauthorized("invalid_token", "Invalid authorization header format")
+	}
+
+	claims, err := jwtService.ValidateToken(tokenParts[1])
+	if err != nil {
+		return nil, auth.Unauthorized("invalid_token", "Invalid token")
+	}
+	return claims, nil
+}
+
+// RequireAdmin only lets the configured administrator accounts through. It
+// must be used after AuthMiddleware.
+func RequireAdmin(adminEmails []string) func(http.Handler) http.Handler {
+	admins := adminSet(adminEmails)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := GetUserFromContext(r.Context())
+			if !ok || !admins[strings.ToLower(claims.Email)] {
+				auth.WriteError(w, r, auth.Forbidden("admin_required", "Admin access required"))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireSelfOrAdmin only lets through the user named by the route's id
+// variable and the configured administrator accounts. It must be used after
+// AuthMiddleware.
+func RequireSelfOrAdmin(adminEmails []string) func(http.Handler) http.Handler {
+	admins := adminSet(adminEmails)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := GetUserFromContext(r.Context())
+			if !ok {
+				auth.WriteError(w, r, auth.Forbidden("forbidden", "Access to this user is not allowed"))
+				return
+			}
+
+			id, err := strconv.Atoi(mux.Vars(r)["id"])
+			if (err != nil || id != claims.UserID) && !admins[strings.ToLower(claims.Email)] {
+				auth.WriteError(w, r, auth.Forbidden("forbidden", "Access to this user is not allowed"))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func adminSet(adminEmails []string) map[string]bool {
+	admins := make(map[string]bool, len(adminEmails))
+	for _, email := range adminEmails {
+		admins[strings.ToLower(strings.TrimSpace(email))] = true
+	}
+	return admins
+}
+
 // Helper function to get user from context
 func GetUserFromContext(ctx context.Context) (*auth.Claims, bool) {
-	claims, ok := ctx.Value(UserContextKey).(*auth.Claims)
-	return claims, ok
+	return auth.ClaimsFromContext(ctx)
 }
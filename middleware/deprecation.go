@@ -0,0 +1,24 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Deprecated marks responses from a route that is being retired, using the
+// Deprecation (RFC 9745) and Sunset (RFC 8594) headers, and points clients
+// at the route that replaces it
+func Deprecated(deprecatedAt, sunset time.Time, successor string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Deprecation", fmt.Sprintf("@%d", deprecatedAt.Unix()))
+			w.Header().Set("Sunset", sunset.UTC().Format(http.TimeFormat))
+			if successor != "" {
+				w.Header().Add("Link", fmt.Sprintf(`<%s>; rel="successor-version"`, successor))
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"goAPI/auth"
+	"goAPI/idempotency"
+)
+
+const (
+	IdempotencyKeyHeader     = "Idempotency-Key"
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+)
+
+// idempotentMethods are the methods whose retries are deduplicated
+var idempotentMethods = map[string]bool{
+	http.MethodPost:   true,
+	http.MethodPut:    true,
+	http.MethodPatch:  true,
+	http.MethodDelete: true,
+}
+
+var validIdempotencyKey = regexp.MustCompile(`^[\x21-\x7e]{1,255}$`)
+
+// Idempotency makes writes sent with an Idempotency-Key header safe to
+// retry. The first response for a key is stored and replayed for retries
+// until ttl has passed. Reusing a key for a different request is rejected
+// with 422, and retrying while the first request is still running with
+// 409. Server errors aren't stored, so those requests can be retried.
+//
+// Keys are scoped to the user of the bearer token, so a key sent without
+// one is rejected. Requests with an invalid token are passed through
+// unrecorded, the route's authentication rejects them.
+//
+// The request body is buffered to fingerprint it, up to maxBodyBytes; the
+// route's own limit, so that a larger body is rejected with 413 as the
+// handler would. Responses are stored as sent, so routes whose responses
+// carry tokens must not use this middleware.
+func Idempotency(keys *idempotency.Repository, jwtService *auth.JWTService, ttl time.Duration, maxBodyBytes int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(IdempotencyKeyHeader)
+			if key == "" || !idempotentMethods[r.Method] {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if !validIdempotencyKey.MatchString(key) {
+				auth.WriteError(w, r, auth.Validation("invalid_idempotency_key", "Idempotency-Key must be 1 to 255 printable characters"))
+				return
+			}
+
+			if r.Header.Get("Authorization") == "" {
+				auth.WriteError(w, r, auth.Unauthorized("authentication_required", "Idempotency-Key requires a bearer token"))
+				return
+			}
+			claims, err := bearerClaims(jwtService, r)
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			caller := fmt.Sprintf("user:%d", claims.UserID)
+
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+			if err != nil {
+				var tooLarge *http.MaxBytesError
+				if errors.As(err, &tooLarge) {
+					auth.WriteError(w, r, auth.Validation("request_too_large", "request body must be at most %d bytes", maxBodyBytes).WithStatus(http.StatusRequestEntityTooLarge))
+					return
+				}
+				auth.WriteError(w, r, auth.ErrInvalidBody)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			fingerprint := idempotency.Fingerprint(r, body)
+			record, reserved, err := keys.Reserve(r.Context(), caller, key, fingerprint, ttl)
+			if err != nil {
+				auth.WriteError(w, r, err)
+				return
+			}
+
+			if !reserved {
+				switch {
+				case record.Fingerprint != fingerprint:
+					auth.WriteError(w, r, auth.Validation("idempotency_key_reused", "Idempotency-Key was already used for a different request").WithStatus(http.StatusUnprocessableEntity))
+				case !record.Completed():
+					auth.WriteError(w, r, auth.Conflict("idempotency_key_in_use", "a request with this Idempotency-Key is still being processed"))
+				default:
+					replay(w, record)
+				}
+				return
+			}
+
+			recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(recorder, r)
+
+			// The key must be settled even if the client has gone away,
+			// otherwise it stays locked until lockTimeout. Canceled requests
+			// never got a real response, so they can be retried like server
+			// errors.
+			ctx := context.WithoutCancel(r.Context())
+			if recorder.status >= http.StatusInternalServerError || recorder.status == auth.StatusClientClosedRequest {
+				err = keys.Release(ctx, caller, key)
+			} else {
+				err = keys.Complete(ctx, caller, key, recorder.status, recorder.stored, recorder.body.Bytes())
+			}
+			if err != nil {
+				log.Printf("request %s: %v", auth.RequestIDFromContext(r.Context()), err)
+			}
+		})
+	}
+}
+
+// replay writes a stored response again
+func replay(w http.ResponseWriter, record *idempotency.Record) {
+	for name, values := range record.Header {
+		w.Header()[name] = values
+	}
+	w.Header().Set(IdempotentReplayedHeader, "true")
+	w.WriteHeader(record.StatusCode)
+	w.Write(record.Body)
+}
+
+// responseRecorder passes a response through while keeping a copy of it
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	stored      http.Header
+	body        bytes.Buffer
+	wroteHeader bool
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.wroteHeader {
+		return
+	}
+	rec.wroteHeader = true
+	rec.status = status
+
+	// The request ID and CORS headers belong to each attempt, not to the
+	// stored response
+	rec.stored = rec.Header().Clone()
+	for name := range rec.stored {
+		if name == RequestIDHeader || strings.HasPrefix(name, "Access-Control-") {
+			delete(rec.stored, name)
+		}
+	}
+
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if !rec.wroteHeader {
+		rec.WriteHeader(http.StatusOK)
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
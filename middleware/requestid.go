@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+
+	"goAPI/auth"
+)
+
+const RequestIDHeader = "X-Request-ID"
+
+// validRequestID limits the request IDs accepted from clients to values
+// that are safe to log and store
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,100}$`)
+
+// RequestID tags every request with an ID, reusing the client's
+// X-Request-ID when it sends a valid one, and echoes it in the response
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(RequestIDHeader)
+		if !validRequestID.MatchString(requestID) {
+			requestID = newRequestID()
+		}
+
+		w.Header().Set(RequestIDHeader, requestID)
+		next.ServeHTTP(w, r.WithContext(auth.ContextWithRequestID(r.Context(), requestID)))
+	})
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,28 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// SCIMAuthMiddleware protects the SCIM endpoints with the static bearer token
+// configured for the identity provider
+func SCIMAuthMiddleware(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authHeader := r.Header.Get("Authorization")
+			tokenParts := strings.SplitN(authHeader, " ", 2)
+			if len(tokenParts) != 2 || !strings.EqualFold(tokenParts[0], "Bearer") ||
+				subtle.ConstantTimeCompare([]byte(tokenParts[1]), []byte(token)) != 1 {
+				w.Header().Set("Content-Type", "application/scim+json")
+				w.Header().Set("WWW-Authenticate", `Bearer realm="scim"`)
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`{"schemas":["urn:ietf:params:scim:api:messages:2.0:Error"],"status":"401","detail":"Invalid bearer token"}`))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
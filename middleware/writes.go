@@ -0,0 +1,28 @@
+package middleware
+
+import (
+	"net/http"
+
+	"goAPI/auth"
+)
+
+// safeMethods are the methods that only read
+var safeMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+}
+
+// TrackWrites sends the reads of a request to the primary database once it
+// has written, so that they don't miss the write on a replica that hasn't
+// caught up yet. Requests with other than safe methods read from the
+// primary throughout, as they check versions before writing.
+func TrackWrites(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := auth.ContextWithWriteTracking(r.Context())
+		if !safeMethods[r.Method] {
+			ctx = auth.ContextWithPrimaryReads(r.Context())
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
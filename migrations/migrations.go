@@ -0,0 +1,88 @@
+// Package migrations embeds the database schema so that it ships with the
+// binary, and applies it. Each schema change is a pair of files,
+// NNN_name.up.sql and NNN_name.down.sql; the versions applied to a database
+// are recorded in its schema_migrations table.
+package migrations
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// Postgres holds the schema of the Postgres stores
+//
+//go:embed postgres/*.sql
+var Postgres embed.FS
+
+// SQLite holds the schema of the SQLite store
+//
+//go:embed sqlite/*.sql
+var SQLite embed.FS
+
+// Migration is one schema change
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+func (m Migration) String() string {
+	return fmt.Sprintf("%03d_%s", m.Version, m.Name)
+}
+
+var migrationFile = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Load reads the migrations in dir of fsys, ordered by version. Every
+// migration must have both an up and a down file.
+func Load(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		match := migrationFile.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("unexpected migration file %s", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		data, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d is named both %s and %s", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Version == 0 {
+			return nil, fmt.Errorf("migration %s: versions start at 1", m)
+		}
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %s needs both an up and a down file", m)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
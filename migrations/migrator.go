@@ -0,0 +1,346 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+// lockKey identifies the Postgres advisory lock held while migrating, so
+// that instances starting together don't apply the same migration twice
+const lockKey = 4817265093
+
+const createSchemaMigrations = `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+	    version INTEGER PRIMARY KEY,
+	    name VARCHAR(255) NOT NULL,
+	    applied_at TIMESTAMP NOT NULL
+	)`
+
+// Migrator applies migrations to a database and records them in its
+// schema_migrations table
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+	lock       bool // Take the Postgres advisory lock
+}
+
+// NewPostgres returns a migrator for a Postgres database
+func NewPostgres(db *sql.DB) (*Migrator, error) {
+	migrations, err := Load(Postgres, "postgres")
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations, lock: true}, nil
+}
+
+// NewSQLite returns a migrator for a SQLite database. SQLite databases
+// are used by a single process, so no lock is taken.
+func NewSQLite(db *sql.DB) (*Migrator, error) {
+	migrations, err := Load(SQLite, "sqlite")
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+// Latest returns the version of the newest migration
+func (m *Migrator) Latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// MigrationStatus tells whether a migration has been applied. Migrations
+// recorded in the database that this build doesn't know about, because a
+// newer build applied them, are listed with Unknown set.
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time
+	Unknown   bool
+}
+
+// Status lists every migration, known or applied, in version order
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	var statuses []MigrationStatus
+	err := m.withConn(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			status := MigrationStatus{Migration: migration}
+			if record, ok := applied[migration.Version]; ok {
+				status.AppliedAt = &record.AppliedAt
+				delete(applied, migration.Version)
+			}
+			statuses = append(statuses, status)
+		}
+		for _, record := range applied {
+			appliedAt := record.AppliedAt
+			statuses = append(statuses, MigrationStatus{
+				Migration: Migration{Version: record.Version, Name: record.Name},
+				AppliedAt: &appliedAt,
+				Unknown:   true,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sortStatuses(statuses)
+	return statuses, nil
+}
+
+// Pending returns the known migrations that haven't been applied
+func (m *Migrator) Pending(ctx context.Context) ([]Migration, error) {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	for _, status := range statuses {
+		if status.AppliedAt == nil {
+			pending = append(pending, status.Migration)
+		}
+	}
+	return pending, nil
+}
+
+// Step is a migration applied or reverted by Up, Down or Goto
+type Step struct {
+	Migration
+	Reverted bool
+}
+
+func (s Step) String() string {
+	if s.Reverted {
+		return "reverted " + s.Migration.String()
+	}
+	return "applied " + s.Migration.String()
+}
+
+// Up applies every pending migration
+func (m *Migrator) Up(ctx context.Context) ([]Step, error) {
+	return m.Goto(ctx, m.Latest())
+}
+
+// Down reverts the last n applied migrations
+func (m *Migrator) Down(ctx context.Context, n int) ([]Step, error) {
+	return m.migrate(ctx, func(applied map[int]appliedMigration) ([]Step, error) {
+		versions := sortedVersions(applied)
+		var steps []Step
+		for i := len(versions) - 1; i >= 0 && len(steps) < n; i-- {
+			migration, err := m.find(versions[i])
+			if err != nil {
+				return nil, err
+			}
+			steps = append(steps, Step{Migration: migration, Reverted: true})
+		}
+		return steps, nil
+	})
+}
+
+// Goto applies or reverts migrations until exactly those up to version
+// are applied. Version 0 reverts everything.
+func (m *Migrator) Goto(ctx context.Context, version int) ([]Step, error) {
+	if version != 0 {
+		if _, err := m.find(version); err != nil {
+			return nil, err
+		}
+	}
+
+	return m.migrate(ctx, func(applied map[int]appliedMigration) ([]Step, error) {
+		var steps []Step
+		versions := sortedVersions(applied)
+		for i := len(versions) - 1; i >= 0 && versions[i] > version; i-- {
+			migration, err := m.find(versions[i])
+			if err != nil {
+				return nil, err
+			}
+			steps = append(steps, Step{Migration: migration, Reverted: true})
+		}
+		for _, migration := range m.migrations {
+			if _, ok := applied[migration.Version]; !ok && migration.Version <= version {
+				steps = append(steps, Step{Migration: migration})
+			}
+		}
+		return steps, nil
+	})
+}
+
+// Force records exactly the migrations up to version as applied without
+// running them, for databases whose schema was created some other way
+func (m *Migrator) Force(ctx context.Context, version int) error {
+	if version != 0 {
+		if _, err := m.find(version); err != nil {
+			return err
+		}
+	}
+
+	return m.withConn(ctx, func(conn *sql.Conn) error {
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("failed to begin transaction: %w", err)
+		}
+		defer tx.Rollback()
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM schema_migrations`); err != nil {
+			return fmt.Errorf("failed to clear schema_migrations: %w", err)
+		}
+		for _, migration := range m.migrations {
+			if migration.Version > version {
+				break
+			}
+			if err := recordMigration(ctx, tx, migration); err != nil {
+				return err
+			}
+		}
+		return tx.Commit()
+	})
+}
+
+func (m *Migrator) find(version int) (Migration, error) {
+	for _, migration := range m.migrations {
+		if migration.Version == version {
+			return migration, nil
+		}
+	}
+	return Migration{}, fmt.Errorf("unknown migration version %d", version)
+}
+
+// migrate runs the steps planned from the applied migrations. Planning
+// happens under the lock, so a concurrent run sees the finished result.
+func (m *Migrator) migrate(ctx context.Context, plan func(map[int]appliedMigration) ([]Step, error)) ([]Step, error) {
+	var done []Step
+	err := m.withConn(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		steps, err := plan(applied)
+		if err != nil {
+			return err
+		}
+
+		for _, step := range steps {
+			if err := runStep(ctx, conn, step); err != nil {
+				return err
+			}
+			done = append(done, step)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// runStep applies or reverts one migration together with its record in
+// schema_migrations, so a failed migration leaves nothing behind
+func runStep(ctx context.Context, conn *sql.Conn, step Step) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	script := step.Up
+	if step.Reverted {
+		script = step.Down
+	}
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return fmt.Errorf("migration %s failed: %w", step.Migration, err)
+	}
+
+	if step.Reverted {
+		_, err = tx.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = $1`, step.Version)
+		if err != nil {
+			return fmt.Errorf("failed to record migration %s: %w", step.Migration, err)
+		}
+	} else if err := recordMigration(ctx, tx, step.Migration); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func recordMigration(ctx context.Context, tx *sql.Tx, migration Migration) error {
+	_, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)`,
+		migration.Version, migration.Name, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to record migration %s: %w", migration, err)
+	}
+	return nil
+}
+
+// withConn runs fn on a single connection, holding the migration lock and
+// with the schema_migrations table in place
+func (m *Migrator) withConn(ctx context.Context, fn func(*sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+	defer conn.Close()
+
+	if m.lock {
+		if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockKey); err != nil {
+			return fmt.Errorf("failed to take the migration lock: %w", err)
+		}
+		defer func() {
+			// Unlock even if ctx was cancelled, the lock outlives it
+			_, err := conn.ExecContext(context.WithoutCancel(ctx), `SELECT pg_advisory_unlock($1)`, lockKey)
+			if err != nil {
+				log.Printf("failed to release the migration lock: %v", err)
+			}
+		}()
+	}
+
+	if _, err := conn.ExecContext(ctx, createSchemaMigrations); err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+	return fn(conn)
+}
+
+type appliedMigration struct {
+	Version   int
+	Name      string
+	AppliedAt time.Time
+}
+
+func appliedMigrations(ctx context.Context, conn *sql.Conn) (map[int]appliedMigration, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT version, name, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := map[int]appliedMigration{}
+	for rows.Next() {
+		var record appliedMigration
+		if err := rows.Scan(&record.Version, &record.Name, &record.AppliedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan schema_migrations: %w", err)
+		}
+		applied[record.Version] = record
+	}
+	return applied, rows.Err()
+}
+
+func sortedVersions(applied map[int]appliedMigration) []int {
+	versions := make([]int, 0, len(applied))
+	for version := range applied {
+		versions = append(versions, version)
+	}
+	sort.Ints(versions)
+	return versions
+}
+
+func sortStatuses(statuses []MigrationStatus) {
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+}
@@ -0,0 +1,170 @@
+package migrations
+
+import (
+	"database/sql"
+	"testing"
+	"testing/fstest"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func openSQLite(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestLoadEmbedded(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		load func() ([]Migration, error)
+	}{
+		{"postgres", func() ([]Migration, error) { return Load(Postgres, "postgres") }},
+		{"sqlite", func() ([]Migration, error) { return Load(SQLite, "sqlite") }},
+	} {
+		migrations, err := tt.load()
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		for i, m := range migrations {
+			if m.Version != i+1 {
+				t.Errorf("%s: migration %s is number %d", tt.name, m, i+1)
+			}
+		}
+	}
+}
+
+func TestLoadRejectsIncompleteMigrations(t *testing.T) {
+	fsys := fstest.MapFS{
+		"m/001_create_things.up.sql":   {Data: []byte("CREATE TABLE things (id INTEGER)")},
+		"m/001_create_things.down.sql": {Data: []byte("DROP TABLE things")},
+		"m/002_add_column.up.sql":      {Data: []byte("ALTER TABLE things ADD COLUMN name TEXT")},
+	}
+	if _, err := Load(fsys, "m"); err == nil {
+		t.Error("Load accepted a migration without a down file")
+	}
+
+	fsys["m/002_add_column.down.sql"] = &fstest.MapFile{Data: []byte("ALTER TABLE things DROP COLUMN name")}
+	fsys["m/notes.txt"] = &fstest.MapFile{Data: []byte("hello")}
+	if _, err := Load(fsys, "m"); err == nil {
+		t.Error("Load accepted a file that isn't a migration")
+	}
+}
+
+// applied returns the versions recorded in schema_migrations
+func applied(t *testing.T, m *Migrator) []int {
+	statuses, err := m.Status(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var versions []int
+	for _, status := range statuses {
+		if status.AppliedAt != nil {
+			versions = append(versions, status.Version)
+		}
+	}
+	return versions
+}
+
+func tableExists(t *testing.T, db *sql.DB, name string) bool {
+	var n int
+	err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, name).Scan(&n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n > 0
+}
+
+func TestMigrateUpDownGoto(t *testing.T) {
+	db := openSQLite(t)
+	m, err := NewSQLite(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	latest := m.Latest()
+
+	steps, err := m.Up(t.Context())
+	if err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+	if len(steps) != latest || len(applied(t, m)) != latest || !tableExists(t, db, "users") {
+		t.Fatalf("Up applied %v, want all %d migrations", steps, latest)
+	}
+	if steps, err := m.Up(t.Context()); err != nil || len(steps) != 0 {
+		t.Errorf("second Up = %v, %v, want nothing to do", steps, err)
+	}
+
+	steps, err = m.Down(t.Context(), 1)
+	if err != nil {
+		t.Fatalf("Down: %v", err)
+	}
+	if len(steps) != 1 || !steps[0].Reverted || steps[0].Version != latest {
+		t.Errorf("Down(1) = %v, want migration %d reverted", steps, latest)
+	}
+	pending, err := m.Pending(t.Context())
+	if err != nil || len(pending) != 1 || pending[0].Version != latest {
+		t.Errorf("Pending after Down = %v, %v, want migration %d", pending, err, latest)
+	}
+
+	if _, err := m.Goto(t.Context(), 0); err != nil {
+		t.Fatalf("Goto(0): %v", err)
+	}
+	if len(applied(t, m)) != 0 || tableExists(t, db, "users") {
+		t.Error("Goto(0) left migrations applied")
+	}
+
+	if _, err := m.Goto(t.Context(), latest); err != nil {
+		t.Fatalf("Goto(%d): %v", latest, err)
+	}
+	if len(applied(t, m)) != latest {
+		t.Errorf("Goto(%d) applied %v", latest, applied(t, m))
+	}
+
+	if _, err := m.Goto(t.Context(), latest+1); err == nil {
+		t.Error("Goto accepted an unknown version")
+	}
+}
+
+func TestFailedMigrationIsNotRecorded(t *testing.T) {
+	db := openSQLite(t)
+	m := &Migrator{db: db, migrations: []Migration{
+		{Version: 1, Name: "create_things", Up: "CREATE TABLE things (id INTEGER)", Down: "DROP TABLE things"},
+		{Version: 2, Name: "broken", Up: "CREATE TABLE more (id INTEGER); ALTER TABLE missing ADD COLUMN x", Down: "DROP TABLE more"},
+	}}
+
+	steps, err := m.Up(t.Context())
+	if err == nil {
+		t.Fatal("Up succeeded despite a failing migration")
+	}
+	if len(steps) != 1 || steps[0].Version != 1 {
+		t.Errorf("Up ran %v, want only migration 1", steps)
+	}
+	if got := applied(t, m); len(got) != 1 || got[0] != 1 {
+		t.Errorf("applied = %v, want [1]", got)
+	}
+	if tableExists(t, db, "more") {
+		t.Error("failed migration left its changes behind")
+	}
+}
+
+func TestForce(t *testing.T) {
+	db := openSQLite(t)
+	m, err := NewSQLite(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Force(t.Context(), 1); err != nil {
+		t.Fatalf("Force: %v", err)
+	}
+	if got := applied(t, m); len(got) != 1 || got[0] != 1 {
+		t.Errorf("applied after Force(1) = %v, want [1]", got)
+	}
+	if tableExists(t, db, "users") {
+		t.Error("Force ran a migration")
+	}
+}
@@ -0,0 +1,20 @@
+CREATE TABLE scim_groups (
+    id SERIAL PRIMARY KEY,
+    display_name VARCHAR(255) UNIQUE NOT NULL,
+    external_id VARCHAR(255),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE TABLE scim_group_members (
+    group_id INTEGER NOT NULL REFERENCES scim_groups(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    PRIMARY KEY (group_id, user_id)
+);
+
+CREATE INDEX idx_scim_group_members_user_id ON scim_group_members(user_id);
+
+CREATE TRIGGER update_scim_groups_updated_at 
+    BEFORE UPDATE ON scim_groups 
+    FOR EACH ROW 
+    EXECUTE FUNCTION update_updated_at_column();
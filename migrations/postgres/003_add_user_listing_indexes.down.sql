@@ -0,0 +1,4 @@
+DROP INDEX idx_users_created_at_id;
+DROP INDEX idx_users_last_name_id;
+DROP INDEX idx_users_first_name_id;
+DROP INDEX idx_users_language;
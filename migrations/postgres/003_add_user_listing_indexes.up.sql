@@ -0,0 +1,5 @@
+-- Composite indexes backing keyset pagination of user listings
+CREATE INDEX idx_users_created_at_id ON users(created_at, id);
+CREATE INDEX idx_users_last_name_id ON users(last_name, id);
+CREATE INDEX idx_users_first_name_id ON users(first_name, id);
+CREATE INDEX idx_users_language ON users(language);
@@ -0,0 +1,6 @@
+DROP INDEX idx_users_first_name_trgm;
+DROP INDEX idx_users_last_name_trgm;
+DROP INDEX idx_users_email_trgm;
+DROP INDEX idx_users_search_tsv;
+
+DROP EXTENSION IF EXISTS pg_trgm;
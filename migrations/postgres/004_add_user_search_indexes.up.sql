@@ -0,0 +1,11 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+-- Trigram indexes for partial and fuzzy matches on names and email
+CREATE INDEX idx_users_first_name_trgm ON users USING GIN (first_name gin_trgm_ops);
+CREATE INDEX idx_users_last_name_trgm ON users USING GIN (last_name gin_trgm_ops);
+CREATE INDEX idx_users_email_trgm ON users USING GIN (email gin_trgm_ops);
+
+-- Full-text index; the expression must match the one used by the search query
+CREATE INDEX idx_users_search_tsv ON users USING GIN (
+    to_tsvector('simple', first_name || ' ' || last_name || ' ' || email)
+);
@@ -0,0 +1,4 @@
+DROP TRIGGER increment_users_version ON users;
+DROP FUNCTION increment_version_column();
+
+ALTER TABLE users DROP COLUMN version;
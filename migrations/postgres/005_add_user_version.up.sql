@@ -0,0 +1,15 @@
+-- Row version used for optimistic concurrency control and exposed as ETag
+ALTER TABLE users ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+
+CREATE OR REPLACE FUNCTION increment_version_column()
+RETURNS TRIGGER AS $$
+BEGIN
+    NEW.version = OLD.version + 1;
+    RETURN NEW;
+END;
+$$ language 'plpgsql';
+
+CREATE TRIGGER increment_users_version 
+    BEFORE UPDATE ON users 
+    FOR EACH ROW 
+    EXECUTE FUNCTION increment_version_column();
@@ -0,0 +1,4 @@
+DROP INDEX idx_users_deactivated_at;
+
+ALTER TABLE users DROP COLUMN anonymized_at;
+ALTER TABLE users DROP COLUMN deactivated_at;
@@ -0,0 +1,8 @@
+-- Track when accounts were deactivated and anonymized so that retention
+-- policies can act on them
+ALTER TABLE users ADD COLUMN deactivated_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE users ADD COLUMN anonymized_at TIMESTAMP WITH TIME ZONE;
+
+UPDATE users SET deactivated_at = updated_at WHERE is_active = false;
+
+CREATE INDEX idx_users_deactivated_at ON users(deactivated_at) WHERE is_active = false;
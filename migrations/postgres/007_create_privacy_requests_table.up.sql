@@ -0,0 +1,11 @@
+-- Record of data subject requests (GDPR access and erasure). Rows only hold
+-- the user ID so they remain after the user's personal data is erased.
+CREATE TABLE privacy_requests (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    kind VARCHAR(20) NOT NULL CHECK (kind IN ('export', 'erasure')),
+    requested_by VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX idx_privacy_requests_user_id ON privacy_requests(user_id);
@@ -0,0 +1,4 @@
+DROP TABLE user_attribute_schema;
+
+DROP INDEX idx_users_attributes;
+ALTER TABLE users DROP COLUMN attributes;
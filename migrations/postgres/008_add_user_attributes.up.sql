@@ -0,0 +1,11 @@
+-- Custom per-user attributes, validated against an admin-managed schema
+ALTER TABLE users ADD COLUMN attributes JSONB NOT NULL DEFAULT '{}';
+
+CREATE INDEX idx_users_attributes ON users USING GIN (attributes jsonb_path_ops);
+
+-- Holds a single row with the current attribute schema
+CREATE TABLE user_attribute_schema (
+    id SMALLINT PRIMARY KEY DEFAULT 1 CHECK (id = 1),
+    schema JSONB NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
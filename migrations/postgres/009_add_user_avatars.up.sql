@@ -0,0 +1,2 @@
+-- URLs of the stored avatar renditions, keyed by size
+ALTER TABLE users ADD COLUMN avatar_urls JSONB;
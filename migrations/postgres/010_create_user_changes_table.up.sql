@@ -0,0 +1,17 @@
+-- History of changes to users. snapshot holds the user's editable fields
+-- after the change so a user can be reverted to any recorded version.
+CREATE TABLE user_changes (
+    id BIGSERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    version INTEGER NOT NULL,
+    action VARCHAR(20) NOT NULL,
+    actor_id INTEGER,
+    actor_email VARCHAR(255),
+    source VARCHAR(20) NOT NULL,
+    request_id VARCHAR(100),
+    changes JSONB NOT NULL,
+    snapshot JSONB NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX idx_user_changes_user_id ON user_changes(user_id, id);
@@ -0,0 +1,4 @@
+-- Emails stay lowercased; the original casing is not recorded
+DROP INDEX idx_users_email_lower;
+ALTER TABLE users ADD CONSTRAINT users_email_key UNIQUE (email);
+CREATE INDEX idx_users_email ON users(email);
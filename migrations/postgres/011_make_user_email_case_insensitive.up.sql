@@ -0,0 +1,28 @@
+-- Emails are unique regardless of case and stored lowercased. Accounts whose
+-- emails differ only by case have to be merged or renamed by hand first, so
+-- the migration stops and lists them instead of picking a winner.
+DO $$
+DECLARE
+    duplicates TEXT;
+BEGIN
+    SELECT string_agg(format('%s (user ids %s)', email, ids), E'\n' ORDER BY email)
+    INTO duplicates
+    FROM (
+        SELECT lower(email) AS email, string_agg(id::text, ', ' ORDER BY id) AS ids
+        FROM users
+        GROUP BY lower(email)
+        HAVING count(*) > 1
+    ) AS case_duplicates;
+
+    IF duplicates IS NOT NULL THEN
+        RAISE EXCEPTION E'users with emails that differ only by case:\n%', duplicates
+            USING HINT = 'Merge or rename these accounts, then rerun the migration.';
+    END IF;
+END
+$$;
+
+UPDATE users SET email = lower(email) WHERE email <> lower(email);
+
+ALTER TABLE users DROP CONSTRAINT users_email_key;
+DROP INDEX idx_users_email;
+CREATE UNIQUE INDEX idx_users_email_lower ON users(lower(email));
@@ -0,0 +1,3 @@
+DROP INDEX idx_users_external_id;
+
+ALTER TABLE users DROP COLUMN external_id;
@@ -0,0 +1,5 @@
+-- The identifier a SCIM client assigns to a user (RFC 7643 section 3.1),
+-- which clients look users up by
+ALTER TABLE users ADD COLUMN external_id VARCHAR(255) NOT NULL DEFAULT '';
+
+CREATE INDEX idx_users_external_id ON users(external_id) WHERE external_id <> '';
@@ -0,0 +1,7 @@
+-- Keys used by several callers would collide, and the responses are only
+-- kept for replays, so they are dropped
+DELETE FROM idempotency_keys;
+
+ALTER TABLE idempotency_keys DROP CONSTRAINT idempotency_keys_pkey;
+ALTER TABLE idempotency_keys DROP COLUMN caller;
+ALTER TABLE idempotency_keys ADD PRIMARY KEY (key);
@@ -0,0 +1,8 @@
+-- Clients choose their keys, so a key is only unique for the authenticated
+-- user that sent it. Stored responses can't be assigned a caller after the
+-- fact and are only kept for replays, so they are dropped.
+DELETE FROM idempotency_keys;
+
+ALTER TABLE idempotency_keys ADD COLUMN caller VARCHAR(255) NOT NULL;
+ALTER TABLE idempotency_keys DROP CONSTRAINT idempotency_keys_pkey;
+ALTER TABLE idempotency_keys ADD PRIMARY KEY (caller, key);
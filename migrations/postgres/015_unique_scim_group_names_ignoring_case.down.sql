@@ -0,0 +1,3 @@
+DROP INDEX idx_scim_groups_display_name;
+
+ALTER TABLE scim_groups ADD CONSTRAINT scim_groups_display_name_key UNIQUE (display_name);
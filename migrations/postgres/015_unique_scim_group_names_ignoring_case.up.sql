@@ -0,0 +1,5 @@
+-- SCIM compares display names case-insensitively, so the database enforces
+-- uniqueness the same way and concurrent creates can't both succeed
+ALTER TABLE scim_groups DROP CONSTRAINT scim_groups_display_name_key;
+
+CREATE UNIQUE INDEX idx_scim_groups_display_name ON scim_groups (lower(display_name));
@@ -0,0 +1,44 @@
+-- SQLite schema of the users table, equivalent to the Postgres migrations.
+-- Timestamps are stored as UTC text in a fixed-width format, so comparing
+-- them as strings compares them in time.
+CREATE TABLE IF NOT EXISTS users (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    email VARCHAR(255) NOT NULL,
+    password_hash VARCHAR(255) NOT NULL,
+    first_name VARCHAR(100) NOT NULL,
+    last_name VARCHAR(100) NOT NULL,
+    country VARCHAR(2) NOT NULL, -- ISO 3166-1 alpha-2 country code
+    language VARCHAR(5) NOT NULL DEFAULT 'en',
+    is_active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%f000Z', 'now')),
+    updated_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%f000Z', 'now')),
+    version INTEGER NOT NULL DEFAULT 1,
+    deactivated_at TIMESTAMP,
+    anonymized_at TIMESTAMP,
+    attributes JSON NOT NULL DEFAULT '{}',
+    avatar_urls JSON
+);
+
+-- Emails are unique ignoring case
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email_lower ON users(lower(email));
+
+CREATE INDEX IF NOT EXISTS idx_users_country ON users(country);
+CREATE INDEX IF NOT EXISTS idx_users_language ON users(language);
+CREATE INDEX IF NOT EXISTS idx_users_is_active ON users(is_active);
+CREATE INDEX IF NOT EXISTS idx_users_created_at_id ON users(created_at, id);
+CREATE INDEX IF NOT EXISTS idx_users_last_name_id ON users(last_name, id);
+CREATE INDEX IF NOT EXISTS idx_users_first_name_id ON users(first_name, id);
+CREATE INDEX IF NOT EXISTS idx_users_deactivated_at ON users(deactivated_at) WHERE is_active = FALSE;
+
+-- Like the Postgres triggers, every update bumps version and updated_at.
+-- The application sets both in its own updates, so RETURNING sees them;
+-- this catches updates that don't.
+CREATE TRIGGER IF NOT EXISTS update_users_version
+AFTER UPDATE ON users
+FOR EACH ROW WHEN NEW.version = OLD.version
+BEGIN
+    UPDATE users
+    SET version = OLD.version + 1,
+        updated_at = strftime('%Y-%m-%dT%H:%M:%f000Z', 'now')
+    WHERE id = NEW.id;
+END;
@@ -0,0 +1,3 @@
+DROP TABLE user_changes;
+DROP TABLE user_attribute_schema;
+DROP TABLE privacy_requests;
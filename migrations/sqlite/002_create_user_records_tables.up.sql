@@ -0,0 +1,34 @@
+-- Records kept about users, as in the Postgres migrations 007, 008 and 010
+
+CREATE TABLE IF NOT EXISTS privacy_requests (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    kind VARCHAR(20) NOT NULL CHECK (kind IN ('export', 'erasure')),
+    requested_by VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%f000Z', 'now'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_privacy_requests_user_id ON privacy_requests(user_id);
+
+-- Holds a single row with the current attribute schema
+CREATE TABLE IF NOT EXISTS user_attribute_schema (
+    id INTEGER PRIMARY KEY DEFAULT 1 CHECK (id = 1),
+    schema JSON NOT NULL,
+    updated_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%f000Z', 'now'))
+);
+
+CREATE TABLE IF NOT EXISTS user_changes (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    version INTEGER NOT NULL,
+    action VARCHAR(20) NOT NULL,
+    actor_id INTEGER,
+    actor_email VARCHAR(255),
+    source VARCHAR(20) NOT NULL,
+    request_id VARCHAR(100),
+    changes JSON NOT NULL,
+    snapshot JSON NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%f000Z', 'now'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_changes_user_id ON user_changes(user_id, id);
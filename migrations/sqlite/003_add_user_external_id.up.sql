@@ -0,0 +1,4 @@
+-- The identifier a SCIM client assigns to a user, as in Postgres
+ALTER TABLE users ADD COLUMN external_id VARCHAR(255) NOT NULL DEFAULT '';
+
+CREATE INDEX idx_users_external_id ON users(external_id) WHERE external_id <> '';
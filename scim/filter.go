@@ -0,0 +1,514 @@
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"goAPI/auth"
+)
+
+// Filter is a parsed SCIM filter expression (RFC 7644 section 3.4.2.2).
+// Filters are evaluated against the JSON form of a resource so that any
+// attribute we expose can be filtered on.
+type Filter interface {
+	Match(resource map[string]interface{}) bool
+}
+
+type logicalFilter struct {
+	op          string
+	left, right Filter
+}
+
+type notFilter struct {
+	inner Filter
+}
+
+type compareFilter struct {
+	path  string
+	op    string
+	value interface{}
+}
+
+type valuePathFilter struct {
+	attr   string
+	filter Filter
+}
+
+var compareOps = map[string]bool{
+	"eq": true, "ne": true, "co": true, "sw": true, "ew": true,
+	"gt": true, "ge": true, "lt": true, "le": true, "pr": true,
+}
+
+type tokenKind int
+
+const (
+	tokenWord tokenKind = iota
+	tokenString
+	tokenLParen
+	tokenRParen
+	tokenLBracket
+	tokenRBracket
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+func tokenize(input string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(input); {
+		c := input[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			tokens = append(tokens, token{tokenLParen, "("})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{tokenRParen, ")"})
+			i++
+		case c == '[':
+			tokens = append(tokens, token{tokenLBracket, "["})
+			i++
+		case c == ']':
+			tokens = append(tokens, token{tokenRBracket, "]"})
+			i++
+		case c == '"':
+			j := i + 1
+			for ; j < len(input); j++ {
+				if input[j] == '\\' {
+					j++
+					continue
+				}
+				if input[j] == '"' {
+					break
+				}
+			}
+			if j >= len(input) {
+				return nil, fmt.Errorf("unterminated string in filter")
+			}
+			var s string
+			if err := json.Unmarshal([]byte(input[i:j+1]), &s); err != nil {
+				return nil, fmt.Errorf("invalid string in filter: %w", err)
+			}
+			tokens = append(tokens, token{tokenString, s})
+			i = j + 1
+		default:
+			j := i
+			for j < len(input) && !strings.ContainsRune(" \t\r\n()[]\"", rune(input[j])) {
+				j++
+			}
+			tokens = append(tokens, token{tokenWord, input[i:j]})
+			i = j
+		}
+	}
+	return tokens, nil
+}
+
+type filterParser struct {
+	tokens []token
+	pos    int
+}
+
+// ParseFilter parses a SCIM filter expression
+func ParseFilter(input string) (Filter, error) {
+	tokens, err := tokenize(input)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("empty filter")
+	}
+
+	p := &filterParser{tokens: tokens}
+	f, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q in filter", p.tokens[p.pos].text)
+	}
+	return f, nil
+}
+
+func (p *filterParser) peek() *token {
+	if p.pos >= len(p.tokens) {
+		return nil
+	}
+	return &p.tokens[p.pos]
+}
+
+func (p *filterParser) peekWord(word string) bool {
+	t := p.peek()
+	return t != nil && t.kind == tokenWord && strings.EqualFold(t.text, word)
+}
+
+func (p *filterParser) expect(kind tokenKind, text string) error {
+	t := p.peek()
+	if t == nil || t.kind != kind {
+		return fmt.Errorf("expected %q in filter", text)
+	}
+	p.pos++
+	return nil
+}
+
+func (p *filterParser) parseOr() (Filter, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekWord("or") {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalFilter{op: "or", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (Filter, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekWord("and") {
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalFilter{op: "and", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseUnary() (Filter, error) {
+	if p.peekWord("not") {
+		p.pos++
+		if err := p.expect(tokenLParen, "("); err != nil {
+			return nil, err
+		}
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(tokenRParen, ")"); err != nil {
+			return nil, err
+		}
+		return &notFilter{inner: inner}, nil
+	}
+
+	if t := p.peek(); t != nil && t.kind == tokenLParen {
+		p.pos++
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(tokenRParen, ")"); err != nil {
+			return nil, err
+		}
+		return inner, nil
+	}
+
+	return p.parseAttrExpr()
+}
+
+func (p *filterParser) parseAttrExpr() (Filter, error) {
+	t := p.peek()
+	if t == nil || t.kind != tokenWord {
+		return nil, fmt.Errorf("expected attribute path in filter")
+	}
+	path := stripSchemaPrefix(t.text)
+	p.pos++
+
+	if next := p.peek(); next != nil && next.kind == tokenLBracket {
+		p.pos++
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(tokenRBracket, "]"); err != nil {
+			return nil, err
+		}
+		return &valuePathFilter{attr: path, filter: inner}, nil
+	}
+
+	opTok := p.peek()
+	if opTok == nil || opTok.kind != tokenWord || !compareOps[strings.ToLower(opTok.text)] {
+		return nil, fmt.Errorf("expected comparison operator after %q", path)
+	}
+	op := strings.ToLower(opTok.text)
+	p.pos++
+
+	if op == "pr" {
+		return &compareFilter{path: path, op: op}, nil
+	}
+
+	valTok := p.peek()
+	if valTok == nil {
+		return nil, fmt.Errorf("expected value after %q", op)
+	}
+	p.pos++
+
+	if valTok.kind == tokenString {
+		return &compareFilter{path: path, op: op, value: valTok.text}, nil
+	}
+	if valTok.kind != tokenWord {
+		return nil, fmt.Errorf("expected value after %q", op)
+	}
+
+	switch strings.ToLower(valTok.text) {
+	case "true":
+		return &compareFilter{path: path, op: op, value: true}, nil
+	case "false":
+		return &compareFilter{path: path, op: op, value: false}, nil
+	case "null":
+		return &compareFilter{path: path, op: op, value: nil}, nil
+	}
+
+	n, err := strconv.ParseFloat(valTok.text, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value %q in filter", valTok.text)
+	}
+	return &compareFilter{path: path, op: op, value: n}, nil
+}
+
+// errUnsupportedUserFilter is returned for user filters the store can't
+// answer
+var errUnsupportedUserFilter = fmt.Errorf(
+	"unsupported filter: users can be filtered with eq on userName, externalId and active, joined by and")
+
+// userQuery translates a user filter into listing parameters, so that the
+// store does the filtering. Only equality tests of userName, externalId and
+// active joined with "and" are supported, each attribute at most once.
+func userQuery(filter Filter, params *auth.ListUsersParams) error {
+	switch f := filter.(type) {
+	case nil:
+		return nil
+
+	case *logicalFilter:
+		if f.op != "and" {
+			return errUnsupportedUserFilter
+		}
+		if err := userQuery(f.left, params); err != nil {
+			return err
+		}
+		return userQuery(f.right, params)
+
+	case *compareFilter:
+		if f.op != "eq" {
+			return errUnsupportedUserFilter
+		}
+		switch strings.ToLower(f.path) {
+		case "username":
+			value, ok := f.value.(string)
+			if !ok || value == "" || params.Email != "" {
+				return errUnsupportedUserFilter
+			}
+			params.Email = value
+			return nil
+		case "externalid":
+			value, ok := f.value.(string)
+			if !ok || value == "" || params.ExternalID != "" {
+				return errUnsupportedUserFilter
+			}
+			params.ExternalID = value
+			return nil
+		case "active":
+			value, ok := f.value.(bool)
+			if !ok || params.Active != nil {
+				return errUnsupportedUserFilter
+			}
+			params.Active = &value
+			return nil
+		}
+	}
+	return errUnsupportedUserFilter
+}
+
+// stripSchemaPrefix turns a fully qualified attribute such as
+// "urn:ietf:params:scim:schemas:core:2.0:User:userName" into "userName"
+func stripSchemaPrefix(path string) string {
+	for _, schema := range []string{UserSchema, GroupSchema} {
+		if len(path) > len(schema) && strings.EqualFold(path[:len(schema)+1], schema+":") {
+			return path[len(schema)+1:]
+		}
+	}
+	return path
+}
+
+func (f *logicalFilter) Match(resource map[string]interface{}) bool {
+	if f.op == "and" {
+		return f.left.Match(resource) && f.right.Match(resource)
+	}
+	return f.left.Match(resource) || f.right.Match(resource)
+}
+
+func (f *notFilter) Match(resource map[string]interface{}) bool {
+	return !f.inner.Match(resource)
+}
+
+func (f *valuePathFilter) Match(resource map[string]interface{}) bool {
+	for _, v := range lookupValues(resource, f.attr) {
+		if elem, ok := v.(map[string]interface{}); ok && f.filter.Match(elem) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *compareFilter) Match(resource map[string]interface{}) bool {
+	values := lookupValues(resource, f.path)
+
+	if f.op == "pr" {
+		for _, v := range values {
+			if isPresent(v) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if f.value == nil {
+		hasValue := false
+		for _, v := range values {
+			if isPresent(v) {
+				hasValue = true
+			}
+		}
+		if f.op == "ne" {
+			return hasValue
+		}
+		return f.op == "eq" && !hasValue
+	}
+
+	for _, v := range values {
+		if compareValue(f.op, v, f.value) {
+			return true
+		}
+	}
+	return false
+}
+
+func isPresent(v interface{}) bool {
+	switch val := v.(type) {
+	case nil:
+		return false
+	case string:
+		return val != ""
+	case []interface{}:
+		return len(val) > 0
+	case map[string]interface{}:
+		return len(val) > 0
+	}
+	return true
+}
+
+func compareValue(op string, actual, expected interface{}) bool {
+	switch exp := expected.(type) {
+	case bool:
+		act, ok := actual.(bool)
+		if !ok {
+			return false
+		}
+		switch op {
+		case "eq":
+			return act == exp
+		case "ne":
+			return act != exp
+		}
+		return false
+
+	case float64:
+		act, ok := actual.(float64)
+		if !ok {
+			return false
+		}
+		switch op {
+		case "eq":
+			return act == exp
+		case "ne":
+			return act != exp
+		case "gt":
+			return act > exp
+		case "ge":
+			return act >= exp
+		case "lt":
+			return act < exp
+		case "le":
+			return act <= exp
+		}
+		return false
+
+	case string:
+		act, ok := actual.(string)
+		if !ok {
+			return false
+		}
+		act, exp = strings.ToLower(act), strings.ToLower(exp)
+		switch op {
+		case "eq":
+			return act == exp
+		case "ne":
+			return act != exp
+		case "co":
+			return strings.Contains(act, exp)
+		case "sw":
+			return strings.HasPrefix(act, exp)
+		case "ew":
+			return strings.HasSuffix(act, exp)
+		case "gt":
+			return act > exp
+		case "ge":
+			return act >= exp
+		case "lt":
+			return act < exp
+		case "le":
+			return act <= exp
+		}
+	}
+	return false
+}
+
+// lookupValues resolves a dotted attribute path against a resource,
+// flattening multi-valued attributes. Attribute names are case-insensitive.
+func lookupValues(resource map[string]interface{}, path string) []interface{} {
+	current := []interface{}{resource}
+	for _, part := range strings.Split(path, ".") {
+		var next []interface{}
+		for _, node := range current {
+			obj, ok := node.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			key, ok := findKey(obj, part)
+			if !ok {
+				continue
+			}
+			if list, ok := obj[key].([]interface{}); ok {
+				next = append(next, list...)
+			} else {
+				next = append(next, obj[key])
+			}
+		}
+		current = next
+	}
+	return current
+}
+
+func findKey(obj map[string]interface{}, name string) (string, bool) {
+	if _, ok := obj[name]; ok {
+		return name, true
+	}
+	for key := range obj {
+		if strings.EqualFold(key, name) {
+			return key, true
+		}
+	}
+	return name, false
+}
@@ -0,0 +1,235 @@
+package scim
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"goAPI/auth"
+)
+
+// filterTestUser is a user resource in the JSON form filters are
+// evaluated against
+const filterTestUser = `{
+	"userName": "alice@example.com",
+	"active": true,
+	"title": "",
+	"nickName": null,
+	"phoneNumbers": [],
+	"loginCount": 3,
+	"name": {"givenName": "Alice", "familyName": "Smith"},
+	"emails": [
+		{"type": "work", "value": "alice@corp.example.org", "primary": true},
+		{"type": "home", "value": "alice@example.com"}
+	]
+}`
+
+func TestFilterMatch(t *testing.T) {
+	var resource map[string]interface{}
+	if err := json.Unmarshal([]byte(filterTestUser), &resource); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		filter string
+		want   bool
+	}{
+		// Comparisons
+		{`userName eq "alice@example.com"`, true},
+		{`userName eq "ALICE@EXAMPLE.COM"`, true},
+		{`USERNAME EQ "alice@example.com"`, true},
+		{`userName ne "alice@example.com"`, false},
+		{`userName co "example"`, true},
+		{`userName sw "alice"`, true},
+		{`userName ew ".com"`, true},
+		{`userName gt "a"`, true},
+		{`userName lt "a"`, false},
+		{`active eq true`, true},
+		{`active eq false`, false},
+		{`active ne false`, true},
+		{`loginCount gt 2`, true},
+		{`loginCount ge 3`, true},
+		{`loginCount lt 3`, false},
+		{`loginCount le 3`, true},
+		{`loginCount eq "3"`, false},
+		{`name.givenName eq "alice"`, true},
+		{`urn:ietf:params:scim:schemas:core:2.0:User:userName eq "alice@example.com"`, true},
+		{`missing eq "x"`, false},
+
+		// Presence and null
+		{`userName pr`, true},
+		{`name pr`, true},
+		{`emails pr`, true},
+		{`title pr`, false},
+		{`nickName pr`, false},
+		{`phoneNumbers pr`, false},
+		{`missing pr`, false},
+		{`nickName eq null`, true},
+		{`missing eq null`, true},
+		{`userName eq null`, false},
+		{`userName ne null`, true},
+
+		// Multi-valued attributes match if any value does
+		{`emails.value ew ".org"`, true},
+		{`emails.type eq "other"`, false},
+
+		// Value paths match within a single element
+		{`emails[type eq "work" and value co "corp"]`, true},
+		{`emails[type eq "home" and value co "corp"]`, false},
+		{`emails[primary eq true]`, true},
+		{`emails[not (type eq "work")]`, true},
+		{`name[givenName eq "Alice"]`, true},
+
+		// Logical operators; and binds tighter than or
+		{`userName pr and active eq true`, true},
+		{`userName pr and active eq false`, false},
+		{`active eq false or userName pr`, true},
+		{`active eq false or missing pr`, false},
+		{`userName pr or active eq false and missing pr`, true},
+		{`(userName pr or active eq false) and missing pr`, false},
+		{`missing pr and active eq false or userName pr`, true},
+		{`AND eq "x" or userName pr`, true},
+
+		// Negation
+		{`not (active eq false)`, true},
+		{`not (active eq true)`, false},
+		{`not (userName pr and missing pr)`, true},
+		{`not (missing pr) and not (title pr)`, true},
+		{`not (not (userName pr))`, true},
+	}
+
+	for _, tt := range tests {
+		filter, err := ParseFilter(tt.filter)
+		if err != nil {
+			t.Errorf("ParseFilter(%s): %v", tt.filter, err)
+			continue
+		}
+		if got := filter.Match(resource); got != tt.want {
+			t.Errorf("%s matched %v, want %v", tt.filter, got, tt.want)
+		}
+	}
+}
+
+func TestParseFilterPrecedence(t *testing.T) {
+	filter, err := ParseFilter(`a pr or b pr and c pr`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &logicalFilter{
+		op:   "or",
+		left: &compareFilter{path: "a", op: "pr"},
+		right: &logicalFilter{
+			op:    "and",
+			left:  &compareFilter{path: "b", op: "pr"},
+			right: &compareFilter{path: "c", op: "pr"},
+		},
+	}
+	if !reflect.DeepEqual(filter, want) {
+		t.Errorf("parsed %#v, want %#v", filter, want)
+	}
+}
+
+var invalidFilters = []string{
+	``,
+	`   `,
+	`userName`,
+	`userName eq`,
+	`userName foo "x"`,
+	`userName eq bareword`,
+	`userName eq "unterminated`,
+	`userName eq "bad \q escape"`,
+	`(userName pr`,
+	`userName pr)`,
+	`userName pr and`,
+	`or userName pr`,
+	`not userName pr`,
+	`not (userName pr`,
+	`emails[type eq "work"`,
+	`emails[]`,
+	`userName pr userName pr`,
+	`"userName" pr`,
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	for _, expr := range invalidFilters {
+		if filter, err := ParseFilter(expr); err == nil {
+			t.Errorf("ParseFilter(%q) = %#v, want an error", expr, filter)
+		}
+	}
+}
+
+func TestUserQuery(t *testing.T) {
+	active := true
+	tests := []struct {
+		filter string
+		want   auth.ListUsersParams
+	}{
+		{`userName eq "alice@example.com"`, auth.ListUsersParams{Email: "alice@example.com"}},
+		{`externalId eq "idp-42"`, auth.ListUsersParams{ExternalID: "idp-42"}},
+		{`active eq true`, auth.ListUsersParams{Active: &active}},
+		{`userName eq "a@example.com" and externalId eq "x" and active eq true`,
+			auth.ListUsersParams{Email: "a@example.com", ExternalID: "x", Active: &active}},
+		{`urn:ietf:params:scim:schemas:core:2.0:User:UserName eq "a@example.com"`, auth.ListUsersParams{Email: "a@example.com"}},
+	}
+	for _, tt := range tests {
+		filter, err := ParseFilter(tt.filter)
+		if err != nil {
+			t.Fatalf("ParseFilter(%s): %v", tt.filter, err)
+		}
+		var params auth.ListUsersParams
+		if err := userQuery(filter, &params); err != nil {
+			t.Errorf("userQuery(%s): %v", tt.filter, err)
+			continue
+		}
+		if !reflect.DeepEqual(params, tt.want) {
+			t.Errorf("userQuery(%s) = %+v, want %+v", tt.filter, params, tt.want)
+		}
+	}
+}
+
+// unsupportedUserFilters parse, but can't be answered by the user store
+var unsupportedUserFilters = []string{
+	`userName eq "a@example.com" or active eq true`,
+	`not (active eq true)`,
+	`userName co "example"`,
+	`userName pr`,
+	`userName eq ""`,
+	`userName eq 42`,
+	`active eq "true"`,
+	`userName eq "a@example.com" and userName eq "b@example.com"`,
+	`emails[type eq "work"]`,
+	`name.givenName eq "Alice"`,
+}
+
+func TestUserQueryUnsupported(t *testing.T) {
+	for _, expr := range unsupportedUserFilters {
+		filter, err := ParseFilter(expr)
+		if err != nil {
+			t.Fatalf("ParseFilter(%s): %v", expr, err)
+		}
+		var params auth.ListUsersParams
+		if err := userQuery(filter, &params); err != errUnsupportedUserFilter {
+			t.Errorf("userQuery(%s) = %v, want errUnsupportedUserFilter", expr, err)
+		}
+	}
+}
+
+func TestListUsersInvalidFilter(t *testing.T) {
+	h := NewHandler(auth.NewMemoryStore(), nil)
+
+	// An empty filter parameter means no filter
+	for _, expr := range append(invalidFilters[1:], unsupportedUserFilters...) {
+		r := httptest.NewRequest(http.MethodGet, "/scim/v2/Users?filter="+url.QueryEscape(expr), nil)
+		w := httptest.NewRecorder()
+		h.ListUsers(w, r)
+
+		var resp Error
+		json.NewDecoder(w.Body).Decode(&resp)
+		if w.Code != http.StatusBadRequest || resp.ScimType != "invalidFilter" {
+			t.Errorf("filter %s: status %d, scimType %q; want 400 invalidFilter", expr, w.Code, resp.ScimType)
+		}
+	}
+}
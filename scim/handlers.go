@@ -0,0 +1,743 @@
+package scim
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"goAPI/auth"
+	"goAPI/iso"
+)
+
+type Handler struct {
+	users  auth.UserStore
+	groups *GroupRepository
+}
+
+func NewHandler(users auth.UserStore, groups *GroupRepository) *Handler {
+	return &Handler{
+		users:  users,
+		groups: groups,
+	}
+}
+
+func (h *Handler) respondWithError(w http.ResponseWriter, code int, scimType, detail string) {
+	w.Header().Set("Content-Type", ContentType)
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(Error{
+		Schemas:  []string{ErrorSchema},
+		Status:   strconv.Itoa(code),
+		ScimType: scimType,
+		Detail:   detail,
+	})
+}
+
+// internalError logs an unexpected failure and responds with a generic
+// message, so that database errors don't reach the client. Requests the
+// client abandoned only get their status, like auth.WriteError does.
+func (h *Handler) internalError(w http.ResponseWriter, r *http.Request, err error) {
+	if appErr := auth.AsError(err); appErr.Kind == auth.KindCanceled {
+		w.WriteHeader(appErr.HTTPStatus())
+		return
+	}
+	log.Printf("request %s: %s %s: %v", auth.RequestIDFromContext(r.Context()), r.Method, r.URL.Path, err)
+	h.respondWithError(w, http.StatusInternalServerError, "", "internal server error")
+}
+
+func (h *Handler) respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
+	w.Header().Set("Content-Type", ContentType)
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(payload)
+}
+
+func (h *Handler) ListUsers(w http.ResponseWriter, r *http.Request) {
+	filter, ok := h.parseFilter(w, r)
+	if !ok {
+		return
+	}
+
+	params := auth.ListUsersParams{Sort: "id", Order: "asc", IncludeTotal: true}
+	if err := userQuery(filter, &params); err != nil {
+		h.respondWithError(w, http.StatusBadRequest, "invalidFilter", err.Error())
+		return
+	}
+	startIndex, count := pageParams(r)
+	params.Offset = startIndex - 1
+	params.Limit = count
+
+	page, err := h.users.ListUsers(r.Context(), params)
+	if err != nil {
+		h.internalError(w, r, err)
+		return
+	}
+
+	resources := []interface{}{}
+	for i := range page.Data {
+		resources = append(resources, userToResource(r, &page.Data[i]))
+	}
+
+	h.respondWithJSON(w, http.StatusOK, ListResponse{
+		Schemas:      []string{ListResponseSchema},
+		TotalResults: *page.Total,
+		StartIndex:   startIndex,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	})
+}
+
+func (h *Handler) GetUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.loadUser(w, r)
+	if !ok {
+		return
+	}
+
+	h.respondWithJSON(w, http.StatusOK, userToResource(r, user))
+}
+
+func (h *Handler) CreateUser(w http.ResponseWriter, r *http.Request) {
+	var resource map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&resource); err != nil {
+		h.respondWithError(w, http.StatusBadRequest, "invalidSyntax", "Invalid request body")
+		return
+	}
+
+	user := &auth.User{}
+	password, err := applyUserAttributes(resource, user)
+	if err != nil {
+		h.respondWithError(w, http.StatusBadRequest, "invalidValue", err.Error())
+		return
+	}
+
+	// Provisioned users usually authenticate through the identity provider,
+	// so give them an unguessable password unless one was supplied.
+	if password == "" {
+		password, err = randomPassword()
+		if err != nil {
+			h.internalError(w, r, err)
+			return
+		}
+	}
+	if err := user.HashPassword(password); err != nil {
+		h.internalError(w, r, err)
+		return
+	}
+
+	active := user.IsActive
+	err = h.users.WithTx(r.Context(), func(tx auth.UserStore) error {
+		if err := tx.CreateUser(r.Context(), user); err != nil {
+			return err
+		}
+		if !active {
+			if err := tx.SetUserActive(r.Context(), user.ID, false); err != nil {
+				return err
+			}
+			user.IsActive = false
+		}
+		return tx.RecordUserChange(r.Context(), auth.ChangeCreate, nil, user.ID, actor(r))
+	})
+	if err != nil {
+		if errors.Is(err, auth.ErrEmailTaken) {
+			h.respondWithError(w, http.StatusConflict, "uniqueness",
+				fmt.Sprintf("user with userName %s already exists", user.Email))
+			return
+		}
+		h.internalError(w, r, err)
+		return
+	}
+
+	resp := userToResource(r, user)
+	w.Header().Set("Location", resp.Meta.Location)
+	h.respondWithJSON(w, http.StatusCreated, resp)
+}
+
+func (h *Handler) ReplaceUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.loadUser(w, r)
+	if !ok {
+		return
+	}
+
+	var resource map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&resource); err != nil {
+		h.respondWithError(w, http.StatusBadRequest, "invalidSyntax", "Invalid request body")
+		return
+	}
+
+	h.saveUser(w, r, user, resource)
+}
+
+func (h *Handler) PatchUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.loadUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req PatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondWithError(w, http.StatusBadRequest, "invalidSyntax", "Invalid request body")
+		return
+	}
+
+	resource := toMap(userToResource(r, user))
+	if err := applyPatch(resource, req.Operations); err != nil {
+		h.respondWithError(w, http.StatusBadRequest, "invalidPath", err.Error())
+		return
+	}
+
+	h.saveUser(w, r, user, resource)
+}
+
+// DeleteUser removes the user. A deleted resource must be gone for every
+// later request (RFC 7644 section 3.6), so rather than deactivating, as the
+// REST API does, the user is purged together with its history and group
+// memberships. Clients that only want to disable a user set active to false.
+func (h *Handler) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.loadUser(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.users.WithTx(r.Context(), func(tx auth.UserStore) error {
+		if err := tx.DeleteUser(r.Context(), user.ID, user.Version); err != nil {
+			return err
+		}
+		return tx.PurgeUser(r.Context(), user.ID)
+	})
+	if errors.Is(err, auth.ErrPreconditionFailed) {
+		h.respondWithError(w, http.StatusPreconditionFailed, "", err.Error())
+		return
+	}
+	if err != nil {
+		h.internalError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) ListGroups(w http.ResponseWriter, r *http.Request) {
+	filter, ok := h.parseFilter(w, r)
+	if !ok {
+		return
+	}
+
+	groups, err := h.groups.GetAllGroups(r.Context())
+	if err != nil {
+		h.internalError(w, r, err)
+		return
+	}
+
+	var resources []interface{}
+	for i := range groups {
+		resource := groupToResource(r, &groups[i])
+		if filter != nil && !filter.Match(toMap(resource)) {
+			continue
+		}
+		resources = append(resources, resource)
+	}
+
+	h.respondWithJSON(w, http.StatusOK, paginate(r, resources))
+}
+
+func (h *Handler) GetGroup(w http.ResponseWriter, r *http.Request) {
+	group, ok := h.loadGroup(w, r)
+	if !ok {
+		return
+	}
+
+	h.respondWithJSON(w, http.StatusOK, groupToResource(r, group))
+}
+
+func (h *Handler) CreateGroup(w http.ResponseWriter, r *http.Request) {
+	var resource map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&resource); err != nil {
+		h.respondWithError(w, http.StatusBadRequest, "invalidSyntax", "Invalid request body")
+		return
+	}
+
+	group := &Group{}
+	if !h.applyGroup(w, r, resource, group) {
+		return
+	}
+
+	if err := h.groups.CreateGroup(r.Context(), group); err != nil {
+		h.groupWriteError(w, r, group, err)
+		return
+	}
+
+	resp := groupToResource(r, group)
+	w.Header().Set("Location", resp.Meta.Location)
+	h.respondWithJSON(w, http.StatusCreated, resp)
+}
+
+func (h *Handler) ReplaceGroup(w http.ResponseWriter, r *http.Request) {
+	group, ok := h.loadGroup(w, r)
+	if !ok {
+		return
+	}
+
+	var resource map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&resource); err != nil {
+		h.respondWithError(w, http.StatusBadRequest, "invalidSyntax", "Invalid request body")
+		return
+	}
+
+	h.saveGroup(w, r, group, resource)
+}
+
+func (h *Handler) PatchGroup(w http.ResponseWriter, r *http.Request) {
+	group, ok := h.loadGroup(w, r)
+	if !ok {
+		return
+	}
+
+	var req PatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondWithError(w, http.StatusBadRequest, "invalidSyntax", "Invalid request body")
+		return
+	}
+
+	resource := toMap(groupToResource(r, group))
+	if err := applyPatch(resource, req.Operations); err != nil {
+		h.respondWithError(w, http.StatusBadRequest, "invalidPath", err.Error())
+		return
+	}
+
+	h.saveGroup(w, r, group, resource)
+}
+
+func (h *Handler) DeleteGroup(w http.ResponseWriter, r *http.Request) {
+	group, ok := h.loadGroup(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.groups.DeleteGroup(r.Context(), group.ID); err != nil {
+		h.internalError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) ServiceProviderConfig(w http.ResponseWriter, r *http.Request) {
+	h.respondWithJSON(w, http.StatusOK, map[string]interface{}{
+		"schemas":        []string{ServiceConfigSchema},
+		"patch":          map[string]bool{"supported": true},
+		"bulk":           map[string]interface{}{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         map[string]interface{}{"supported": true, "maxResults": maxCount},
+		"changePassword": map[string]bool{"supported": true},
+		"sort":           map[string]bool{"supported": false},
+		"etag":           map[string]bool{"supported": false},
+		"authenticationSchemes": []map[string]interface{}{{
+			"type":        "oauthbearertoken",
+			"name":        "OAuth Bearer Token",
+			"description": "Authentication using a static bearer token",
+			"primary":     true,
+		}},
+	})
+}
+
+func (h *Handler) ResourceTypes(w http.ResponseWriter, r *http.Request) {
+	types := []interface{}{
+		map[string]interface{}{
+			"schemas":  []string{ResourceTypeSchema},
+			"id":       "User",
+			"name":     "User",
+			"endpoint": "/Users",
+			"schema":   UserSchema,
+		},
+		map[string]interface{}{
+			"schemas":  []string{ResourceTypeSchema},
+			"id":       "Group",
+			"name":     "Group",
+			"endpoint": "/Groups",
+			"schema":   GroupSchema,
+		},
+	}
+
+	h.respondWithJSON(w, http.StatusOK, ListResponse{
+		Schemas:      []string{ListResponseSchema},
+		TotalResults: len(types),
+		StartIndex:   1,
+		ItemsPerPage: len(types),
+		Resources:    types,
+	})
+}
+
+// saveUser applies a complete SCIM representation to the user. The update,
+// any password and activation change and the history record are written
+// in one transaction.
+func (h *Handler) saveUser(w http.ResponseWriter, r *http.Request, user *auth.User, resource map[string]interface{}) {
+	before := *user
+	password, err := applyUserAttributes(resource, user)
+	if err != nil {
+		h.respondWithError(w, http.StatusBadRequest, "invalidValue", err.Error())
+		return
+	}
+
+	// Hash outside the transaction, bcrypt is slow
+	if password != "" {
+		if err := user.HashPassword(password); err != nil {
+			h.internalError(w, r, err)
+			return
+		}
+	}
+
+	var updated *auth.User
+	err = h.users.WithTx(r.Context(), func(tx auth.UserStore) error {
+		if err := tx.UpdateUser(r.Context(), user); err != nil {
+			return err
+		}
+		if password != "" {
+			if err := tx.UpdatePassword(r.Context(), user.ID, user.Password); err != nil {
+				return err
+			}
+		}
+		if user.IsActive != before.IsActive {
+			if err := tx.SetUserActive(r.Context(), user.ID, user.IsActive); err != nil {
+				return err
+			}
+		}
+		if err := tx.RecordUserChange(r.Context(), auth.ChangeUpdate, &before, user.ID, actor(r)); err != nil {
+			return err
+		}
+
+		var err error
+		updated, err = tx.GetUserByIDIncludingInactive(r.Context(), user.ID)
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, auth.ErrPreconditionFailed) {
+			h.respondWithError(w, http.StatusPreconditionFailed, "", err.Error())
+			return
+		}
+		if errors.Is(err, auth.ErrEmailTaken) {
+			h.respondWithError(w, http.StatusConflict, "uniqueness",
+				fmt.Sprintf("user with userName %s already exists", user.Email))
+			return
+		}
+		h.internalError(w, r, err)
+		return
+	}
+
+	h.respondWithJSON(w, http.StatusOK, userToResource(r, updated))
+}
+
+func (h *Handler) saveGroup(w http.ResponseWriter, r *http.Request, group *Group, resource map[string]interface{}) {
+	if !h.applyGroup(w, r, resource, group) {
+		return
+	}
+
+	if err := h.groups.UpdateGroup(r.Context(), group); err != nil {
+		h.groupWriteError(w, r, group, err)
+		return
+	}
+
+	h.respondWithJSON(w, http.StatusOK, groupToResource(r, group))
+}
+
+// groupWriteError reports a failed group write, mapping a display name
+// that another group already has to the SCIM uniqueness error
+func (h *Handler) groupWriteError(w http.ResponseWriter, r *http.Request, group *Group, err error) {
+	if errors.Is(err, ErrGroupNameTaken) {
+		h.respondWithError(w, http.StatusConflict, "uniqueness",
+			fmt.Sprintf("group with displayName %s already exists", group.DisplayName))
+		return
+	}
+	h.internalError(w, r, err)
+}
+
+// applyGroup copies the group attributes from a SCIM resource, checking
+// that every member exists. The database enforces that display names are
+// unique; see groupWriteError.
+func (h *Handler) applyGroup(w http.ResponseWriter, r *http.Request, resource map[string]interface{}, group *Group) bool {
+	displayName := firstString(resource, "displayName")
+	if displayName == "" {
+		h.respondWithError(w, http.StatusBadRequest, "invalidValue", "displayName is required")
+		return false
+	}
+
+	var memberIDs []int
+	for _, v := range lookupValues(resource, "members.value") {
+		id, err := strconv.Atoi(fmt.Sprint(v))
+		if err != nil {
+			h.respondWithError(w, http.StatusBadRequest, "invalidValue", fmt.Sprintf("invalid member %v", v))
+			return false
+		}
+		if _, err := h.users.GetUserByIDIncludingInactive(r.Context(), id); err != nil {
+			h.respondWithError(w, http.StatusBadRequest, "invalidValue", fmt.Sprintf("member %d does not exist", id))
+			return false
+		}
+		memberIDs = append(memberIDs, id)
+	}
+
+	group.DisplayName = displayName
+	group.ExternalID = firstString(resource, "externalId")
+	group.MemberIDs = memberIDs
+	return true
+}
+
+func (h *Handler) parseFilter(w http.ResponseWriter, r *http.Request) (Filter, bool) {
+	expr := r.URL.Query().Get("filter")
+	if expr == "" {
+		return nil, true
+	}
+
+	filter, err := ParseFilter(expr)
+	if err != nil {
+		h.respondWithError(w, http.StatusBadRequest, "invalidFilter", err.Error())
+		return nil, false
+	}
+	return filter, true
+}
+
+func (h *Handler) loadUser(w http.ResponseWriter, r *http.Request) (*auth.User, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		h.respondWithError(w, http.StatusNotFound, "", "user not found")
+		return nil, false
+	}
+
+	user, err := h.users.GetUserByIDIncludingInactive(r.Context(), id)
+	if errors.Is(err, auth.ErrUserNotFound) {
+		h.respondWithError(w, http.StatusNotFound, "", "user not found")
+		return nil, false
+	}
+	if err != nil {
+		h.internalError(w, r, err)
+		return nil, false
+	}
+	return user, true
+}
+
+func (h *Handler) loadGroup(w http.ResponseWriter, r *http.Request) (*Group, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		h.respondWithError(w, http.StatusNotFound, "", "group not found")
+		return nil, false
+	}
+
+	group, err := h.groups.GetGroupByID(r.Context(), id)
+	if err != nil {
+		h.respondWithError(w, http.StatusNotFound, "", "group not found")
+		return nil, false
+	}
+	return group, true
+}
+
+func userToResource(r *http.Request, user *auth.User) UserResource {
+	id := strconv.Itoa(user.ID)
+	resource := UserResource{
+		Schemas:    []string{UserSchema},
+		ID:         id,
+		ExternalID: user.ExternalID,
+		UserName:   user.Email,
+		Name: Name{
+			Formatted:  strings.TrimSpace(user.FirstName + " " + user.LastName),
+			GivenName:  user.FirstName,
+			FamilyName: user.LastName,
+		},
+		DisplayName: strings.TrimSpace(user.FirstName + " " + user.LastName),
+		Emails:      []Email{{Value: user.Email, Type: "work", Primary: true}},
+		Locale:      user.Language,
+		Active:      user.IsActive,
+		Meta: Meta{
+			ResourceType: "User",
+			Created:      user.CreatedAt,
+			LastModified: user.UpdatedAt,
+			Location:     resourceLocation(r, "Users", id),
+		},
+	}
+	if user.Country != "" {
+		resource.Addresses = []Address{{Country: user.Country, Type: "work", Primary: true}}
+	}
+	return resource
+}
+
+func groupToResource(r *http.Request, group *Group) GroupResource {
+	id := strconv.Itoa(group.ID)
+	members := []Member{}
+	for _, userID := range group.MemberIDs {
+		memberID := strconv.Itoa(userID)
+		members = append(members, Member{
+			Value: memberID,
+			Ref:   resourceLocation(r, "Users", memberID),
+			Type:  "User",
+		})
+	}
+
+	return GroupResource{
+		Schemas:     []string{GroupSchema},
+		ID:          id,
+		DisplayName: group.DisplayName,
+		ExternalID:  group.ExternalID,
+		Members:     members,
+		Meta: Meta{
+			ResourceType: "Group",
+			Created:      group.CreatedAt,
+			LastModified: group.UpdatedAt,
+			Location:     resourceLocation(r, "Groups", id),
+		},
+	}
+}
+
+// applyUserAttributes maps a SCIM User resource onto an auth.User and
+// returns the password, if one was supplied. The resource is treated as a
+// complete representation, as with PUT.
+func applyUserAttributes(resource map[string]interface{}, user *auth.User) (string, error) {
+	email := firstString(resource, "userName")
+	if email == "" {
+		email = primaryValue(resource, "emails", "value")
+	}
+	if email == "" {
+		return "", fmt.Errorf("userName is required")
+	}
+
+	user.Email = email
+	user.ExternalID = firstString(resource, "externalId")
+	user.FirstName = firstString(resource, "name.givenName")
+	user.LastName = firstString(resource, "name.familyName")
+	user.Country = primaryValue(resource, "addresses", "country")
+	if user.Country != "" && !iso.IsCountry(user.Country) {
+		return "", fmt.Errorf("invalid country %q: must be an ISO 3166-1 alpha-2 code", user.Country)
+	}
+
+	// SCIM locales may use an underscore, e.g. en_US
+	user.Language = strings.ReplaceAll(firstString(resource, "locale"), "_", "-")
+	if user.Language == "" {
+		user.Language = "en"
+	}
+	if !iso.IsLanguageTag(user.Language) {
+		return "", fmt.Errorf("invalid locale %q", user.Language)
+	}
+
+	user.IsActive = true
+	if values := lookupValues(resource, "active"); len(values) > 0 {
+		switch v := values[0].(type) {
+		case bool:
+			user.IsActive = v
+		case string:
+			active, err := strconv.ParseBool(v)
+			if err != nil {
+				return "", fmt.Errorf("invalid value for active: %q", v)
+			}
+			user.IsActive = active
+		case nil:
+		default:
+			return "", fmt.Errorf("invalid value for active")
+		}
+	}
+
+	return firstString(resource, "password"), nil
+}
+
+// actor attributes changes to the provisioning client
+func actor(r *http.Request) auth.Actor {
+	return auth.ActorFromRequest(r, auth.SourceSCIM)
+}
+
+func firstString(resource map[string]interface{}, path string) string {
+	for _, v := range lookupValues(resource, path) {
+		if s, ok := v.(string); ok && s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+// primaryValue returns the sub-attribute of the primary element of a
+// multi-valued attribute, falling back to the first element
+func primaryValue(resource map[string]interface{}, attr, sub string) string {
+	var first string
+	for _, v := range lookupValues(resource, attr) {
+		elem, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		value := firstString(elem, sub)
+		if value == "" {
+			continue
+		}
+		if primary, _ := elem["primary"].(bool); primary {
+			return value
+		}
+		if first == "" {
+			first = value
+		}
+	}
+	return first
+}
+
+func paginate(r *http.Request, resources []interface{}) ListResponse {
+	startIndex, count := pageParams(r)
+
+	page := []interface{}{}
+	if start := startIndex - 1; start < len(resources) {
+		end := start + count
+		if end > len(resources) {
+			end = len(resources)
+		}
+		page = resources[start:end]
+	}
+
+	return ListResponse{
+		Schemas:      []string{ListResponseSchema},
+		TotalResults: len(resources),
+		StartIndex:   startIndex,
+		ItemsPerPage: len(page),
+		Resources:    page,
+	}
+}
+
+// pageParams reads the 1-based startIndex and the page size of a listing
+func pageParams(r *http.Request) (startIndex, count int) {
+	var err error
+	startIndex, err = strconv.Atoi(r.URL.Query().Get("startIndex"))
+	if err != nil || startIndex < 1 {
+		startIndex = 1
+	}
+
+	count, err = strconv.Atoi(r.URL.Query().Get("count"))
+	if err != nil {
+		count = defaultCount
+	}
+	if count < 0 {
+		count = 0
+	}
+	if count > maxCount {
+		count = maxCount
+	}
+
+	return startIndex, count
+}
+
+func resourceLocation(r *http.Request, resourceType, id string) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s/scim/v2/%s/%s", scheme, r.Host, resourceType, id)
+}
+
+func toMap(v interface{}) map[string]interface{} {
+	data, _ := json.Marshal(v)
+	var m map[string]interface{}
+	json.Unmarshal(data, &m)
+	return m
+}
+
+func randomPassword() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate password: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
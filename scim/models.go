@@ -0,0 +1,113 @@
+package scim
+
+import (
+	"time"
+)
+
+const (
+	UserSchema          = "urn:ietf:params:scim:schemas:core:2.0:User"
+	GroupSchema         = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	ListResponseSchema  = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	PatchOpSchema       = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	ErrorSchema         = "urn:ietf:params:scim:api:messages:2.0:Error"
+	ServiceConfigSchema = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	ResourceTypeSchema  = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+
+	ContentType = "application/scim+json"
+
+	defaultCount = 100
+	maxCount     = 200
+)
+
+// Group is a provisioned group as stored in the database
+type Group struct {
+	ID          int       `db:"id"`
+	DisplayName string    `db:"display_name"`
+	ExternalID  string    `db:"external_id"`
+	MemberIDs   []int     `db:"-"`
+	CreatedAt   time.Time `db:"created_at"`
+	UpdatedAt   time.Time `db:"updated_at"`
+}
+
+type Meta struct {
+	ResourceType string    `json:"resourceType"`
+	Created      time.Time `json:"created"`
+	LastModified time.Time `json:"lastModified"`
+	Location     string    `json:"location,omitempty"`
+}
+
+type Name struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+type Email struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+type Address struct {
+	Country string `json:"country,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+// UserResource is the SCIM representation of an auth.User
+type UserResource struct {
+	Schemas     []string  `json:"schemas"`
+	ID          string    `json:"id"`
+	ExternalID  string    `json:"externalId,omitempty"`
+	UserName    string    `json:"userName"`
+	Name        Name      `json:"name"`
+	DisplayName string    `json:"displayName,omitempty"`
+	Emails      []Email   `json:"emails,omitempty"`
+	Addresses   []Address `json:"addresses,omitempty"`
+	Locale      string    `json:"locale,omitempty"`
+	Active      bool      `json:"active"`
+	Meta        Meta      `json:"meta"`
+}
+
+type Member struct {
+	Value string `json:"value"`
+	Ref   string `json:"$ref,omitempty"`
+	Type  string `json:"type,omitempty"`
+}
+
+// GroupResource is the SCIM representation of a Group
+type GroupResource struct {
+	Schemas     []string `json:"schemas"`
+	ID          string   `json:"id"`
+	DisplayName string   `json:"displayName"`
+	ExternalID  string   `json:"externalId,omitempty"`
+	Members     []Member `json:"members"`
+	Meta        Meta     `json:"meta"`
+}
+
+type ListResponse struct {
+	Schemas      []string      `json:"schemas"`
+	TotalResults int           `json:"totalResults"`
+	StartIndex   int           `json:"startIndex"`
+	ItemsPerPage int           `json:"itemsPerPage"`
+	Resources    []interface{} `json:"Resources"`
+}
+
+type PatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path,omitempty"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+type PatchRequest struct {
+	Schemas    []string         `json:"schemas"`
+	Operations []PatchOperation `json:"Operations"`
+}
+
+// Error is the SCIM error response body (RFC 7644 section 3.12)
+type Error struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail,omitempty"`
+}
@@ -0,0 +1,218 @@
+package scim
+
+import (
+	"fmt"
+	"strings"
+)
+
+type patchPath struct {
+	attr   string
+	filter Filter
+	sub    string
+}
+
+// parsePatchPath parses the "path" of a PATCH operation: attr, attr.sub,
+// attr[filter] or attr[filter].sub
+func parsePatchPath(path string) (*patchPath, error) {
+	path = stripSchemaPrefix(strings.TrimSpace(path))
+
+	open := strings.Index(path, "[")
+	if open < 0 {
+		attr, sub, _ := strings.Cut(path, ".")
+		if attr == "" {
+			return nil, fmt.Errorf("invalid path %q", path)
+		}
+		return &patchPath{attr: attr, sub: sub}, nil
+	}
+
+	close := strings.LastIndex(path, "]")
+	if close < open {
+		return nil, fmt.Errorf("invalid path %q", path)
+	}
+
+	filter, err := ParseFilter(path[open+1 : close])
+	if err != nil {
+		return nil, err
+	}
+
+	p := &patchPath{attr: path[:open], filter: filter}
+	rest := path[close+1:]
+	if rest != "" {
+		if !strings.HasPrefix(rest, ".") {
+			return nil, fmt.Errorf("invalid path %q", path)
+		}
+		p.sub = rest[1:]
+	}
+	return p, nil
+}
+
+// applyPatch applies PATCH operations (RFC 7644 section 3.5.2) to the JSON
+// form of a resource in place
+func applyPatch(resource map[string]interface{}, ops []PatchOperation) error {
+	for _, op := range ops {
+		name := strings.ToLower(op.Op)
+		if name != "add" && name != "replace" && name != "remove" {
+			return fmt.Errorf("unsupported patch operation %q", op.Op)
+		}
+
+		if op.Path == "" {
+			if name == "remove" {
+				return fmt.Errorf("remove operation requires a path")
+			}
+			values, ok := op.Value.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("operation without path requires an object value")
+			}
+			for key, value := range values {
+				p, err := parsePatchPath(key)
+				if err != nil {
+					return err
+				}
+				applyPatchPath(resource, name, p, value)
+			}
+			continue
+		}
+
+		p, err := parsePatchPath(op.Path)
+		if err != nil {
+			return err
+		}
+		applyPatchPath(resource, name, p, op.Value)
+	}
+	return nil
+}
+
+func applyPatchPath(resource map[string]interface{}, op string, p *patchPath, value interface{}) {
+	key, _ := findKey(resource, p.attr)
+
+	if p.filter == nil {
+		if p.sub != "" {
+			parent, ok := resource[key].(map[string]interface{})
+			if !ok {
+				if op == "remove" {
+					return
+				}
+				parent = map[string]interface{}{}
+				resource[key] = parent
+			}
+			subKey, _ := findKey(parent, p.sub)
+			if op == "remove" {
+				delete(parent, subKey)
+			} else {
+				parent[subKey] = value
+			}
+			return
+		}
+
+		existing, exists := resource[key]
+		switch op {
+		case "remove":
+			list, isList := existing.([]interface{})
+			removals, hasValues := value.([]interface{})
+			if !isList || !hasValues {
+				delete(resource, key)
+				return
+			}
+			resource[key] = removeMatching(list, removals)
+		case "add":
+			if list, ok := existing.([]interface{}); ok && exists {
+				if additions, ok := value.([]interface{}); ok {
+					resource[key] = append(list, additions...)
+					return
+				}
+				resource[key] = append(list, value)
+				return
+			}
+			resource[key] = mergeValue(existing, value)
+		case "replace":
+			resource[key] = mergeValue(existing, value)
+		}
+		return
+	}
+
+	list, _ := resource[key].([]interface{})
+	var out []interface{}
+	matched := false
+	for _, elem := range list {
+		obj, ok := elem.(map[string]interface{})
+		if !ok || !p.filter.Match(obj) {
+			out = append(out, elem)
+			continue
+		}
+		matched = true
+
+		switch {
+		case op == "remove" && p.sub == "":
+			continue
+		case p.sub != "":
+			subKey, _ := findKey(obj, p.sub)
+			if op == "remove" {
+				delete(obj, subKey)
+			} else {
+				obj[subKey] = value
+			}
+		default:
+			if values, ok := value.(map[string]interface{}); ok {
+				for k, v := range values {
+					obj[k] = v
+				}
+			}
+		}
+		out = append(out, obj)
+	}
+
+	// Identity providers commonly "replace" emails[type eq "work"].value
+	// on users that have no work email yet, so create the element.
+	if !matched && op != "remove" {
+		elem := map[string]interface{}{}
+		if cf, ok := p.filter.(*compareFilter); ok && cf.op == "eq" {
+			elem[cf.path] = cf.value
+		}
+		if p.sub != "" {
+			elem[p.sub] = value
+		} else if values, ok := value.(map[string]interface{}); ok {
+			for k, v := range values {
+				elem[k] = v
+			}
+		}
+		out = append(out, elem)
+	}
+
+	resource[key] = out
+}
+
+// mergeValue merges complex attributes sub-attribute by sub-attribute and
+// replaces everything else
+func mergeValue(existing, value interface{}) interface{} {
+	current, ok := existing.(map[string]interface{})
+	update, ok2 := value.(map[string]interface{})
+	if !ok || !ok2 {
+		return value
+	}
+	for k, v := range update {
+		key, _ := findKey(current, k)
+		current[key] = v
+	}
+	return current
+}
+
+// removeMatching drops elements of a multi-valued attribute whose "value"
+// matches one of the given removals
+func removeMatching(list, removals []interface{}) []interface{} {
+	var out []interface{}
+	for _, elem := range list {
+		obj, _ := elem.(map[string]interface{})
+		remove := false
+		for _, r := range removals {
+			robj, _ := r.(map[string]interface{})
+			if obj != nil && robj != nil && fmt.Sprint(obj["value"]) == fmt.Sprint(robj["value"]) {
+				remove = true
+				break
+			}
+		}
+		if !remove {
+			out = append(out, elem)
+		}
+	}
+	return out
+}
@@ -0,0 +1,240 @@
+package scim
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ErrGroupNameTaken is returned when a write would give a group the display
+// name of another group, compared case-insensitively
+var ErrGroupNameTaken = errors.New("group display name is taken")
+
+type GroupRepository struct {
+	db      *sql.DB
+	timeout time.Duration
+}
+
+// NewGroupRepository returns a repository whose operations give up after
+// timeout; zero means they are bounded only by the caller's context
+func NewGroupRepository(db *sql.DB, timeout time.Duration) *GroupRepository {
+	return &GroupRepository{db: db, timeout: timeout}
+}
+
+func (r *GroupRepository) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if r.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, r.timeout)
+}
+
+func (r *GroupRepository) CreateGroup(ctx context.Context, group *Group) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO scim_groups (display_name, external_id, created_at, updated_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id`
+
+	now := time.Now()
+	group.CreatedAt = now
+	group.UpdatedAt = now
+
+	err = tx.QueryRowContext(ctx, query, group.DisplayName, nullString(group.ExternalID),
+		group.CreatedAt, group.UpdatedAt).Scan(&group.ID)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrGroupNameTaken
+		}
+		return fmt.Errorf("failed to create group: %w", err)
+	}
+
+	if err := insertMembers(ctx, tx, group.ID, group.MemberIDs); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *GroupRepository) GetGroupByID(ctx context.Context, id int) (*Group, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	group := &Group{}
+	var externalID sql.NullString
+	query := `
+		SELECT id, display_name, external_id, created_at, updated_at
+		FROM scim_groups
+		WHERE id = $1`
+
+	err := r.db.QueryRowContext(ctx, query, id).Scan(&group.ID, &group.DisplayName,
+		&externalID, &group.CreatedAt, &group.UpdatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("group not found")
+		}
+		return nil, fmt.Errorf("failed to get group: %w", err)
+	}
+	group.ExternalID = externalID.String
+
+	members, err := r.getMembers(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	group.MemberIDs = members
+
+	return group, nil
+}
+
+func (r *GroupRepository) GetAllGroups(ctx context.Context) ([]Group, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT id, display_name, external_id, created_at, updated_at
+		FROM scim_groups
+		ORDER BY id`
+
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get groups: %w", err)
+	}
+	defer rows.Close()
+
+	var groups []Group
+	for rows.Next() {
+		var group Group
+		var externalID sql.NullString
+		err := rows.Scan(&group.ID, &group.DisplayName, &externalID,
+			&group.CreatedAt, &group.UpdatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan group: %w", err)
+		}
+		group.ExternalID = externalID.String
+		groups = append(groups, group)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate rows: %w", err)
+	}
+
+	for i := range groups {
+		members, err := r.getMembers(ctx, groups[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		groups[i].MemberIDs = members
+	}
+
+	return groups, nil
+}
+
+// UpdateGroup saves the group's attributes and replaces its member list
+func (r *GroupRepository) UpdateGroup(ctx context.Context, group *Group) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE scim_groups
+		SET display_name = $1, external_id = $2, updated_at = $3
+		WHERE id = $4`
+
+	group.UpdatedAt = time.Now()
+	_, err = tx.ExecContext(ctx, query, group.DisplayName, nullString(group.ExternalID),
+		group.UpdatedAt, group.ID)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrGroupNameTaken
+		}
+		return fmt.Errorf("failed to update group: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM scim_group_members WHERE group_id = $1`, group.ID); err != nil {
+		return fmt.Errorf("failed to clear group members: %w", err)
+	}
+
+	if err := insertMembers(ctx, tx, group.ID, group.MemberIDs); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *GroupRepository) DeleteGroup(ctx context.Context, id int) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	_, err := r.db.ExecContext(ctx, `DELETE FROM scim_groups WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete group: %w", err)
+	}
+	return nil
+}
+
+func (r *GroupRepository) getMembers(ctx context.Context, groupID int) ([]int, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT user_id FROM scim_group_members
+		WHERE group_id = $1
+		ORDER BY user_id`, groupID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get group members: %w", err)
+	}
+	defer rows.Close()
+
+	var members []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan group member: %w", err)
+		}
+		members = append(members, id)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate rows: %w", err)
+	}
+
+	return members, nil
+}
+
+func insertMembers(ctx context.Context, tx *sql.Tx, groupID int, userIDs []int) error {
+	query := `
+		INSERT INTO scim_group_members (group_id, user_id)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING`
+
+	for _, userID := range userIDs {
+		if _, err := tx.ExecContext(ctx, query, groupID, userID); err != nil {
+			return fmt.Errorf("failed to add group member: %w", err)
+		}
+	}
+	return nil
+}
+
+// isUniqueViolation reports whether err is a Postgres unique constraint
+// violation
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
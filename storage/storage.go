@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+var ErrNotFound = errors.New("blob not found")
+
+// BlobStore stores binary objects such as avatar images under
+// slash-separated keys
+type BlobStore interface {
+	// Put stores the content under key, replacing any existing blob
+	Put(key string, r io.Reader, contentType string) error
+	// Delete removes the blob; deleting a missing blob is not an error
+	Delete(key string) error
+	// URL returns the public URL the blob is served from
+	URL(key string) string
+}
+
+// LocalStore keeps blobs as files below a root directory. The files are
+// expected to be served from baseURL, e.g. by an http.FileServer on Root.
+type LocalStore struct {
+	root    string
+	baseURL string
+}
+
+func NewLocalStore(root, baseURL string) (*LocalStore, error) {
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create storage directory: %w", err)
+	}
+
+	return &LocalStore{root: root, baseURL: strings.TrimSuffix(baseURL, "/")}, nil
+}
+
+func (s *LocalStore) Put(key string, r io.Reader, contentType string) error {
+	file, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return fmt.Errorf("failed to create blob directory: %w", err)
+	}
+
+	// Write to a temporary file first so readers never see a partial blob
+	tmp, err := os.CreateTemp(filepath.Dir(file), ".upload-*")
+	if err != nil {
+		return fmt.Errorf("failed to create blob: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write blob: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write blob: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), file); err != nil {
+		return fmt.Errorf("failed to store blob: %w", err)
+	}
+	return nil
+}
+
+func (s *LocalStore) Delete(key string) error {
+	file, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(file); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete blob: %w", err)
+	}
+	return nil
+}
+
+func (s *LocalStore) URL(key string) string {
+	return s.baseURL + "/" + (&url.URL{Path: key}).EscapedPath()
+}
+
+// Root returns the directory blobs are stored in
+func (s *LocalStore) Root() string {
+	return s.root
+}
+
+// path maps a key to a file below the root, rejecting keys that would
+// escape it
+func (s *LocalStore) path(key string) (string, error) {
+	if key == "" || strings.HasPrefix(key, "/") || path.Clean(key) != key || strings.HasPrefix(key, "../") || key == ".." {
+		return "", fmt.Errorf("invalid blob key %q", key)
+	}
+	return filepath.Join(s.root, filepath.FromSlash(key)), nil
+}